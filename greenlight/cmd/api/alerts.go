@@ -0,0 +1,198 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"greenlight.alexedwards.net/internal/data"
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+func (app *application) listAlertsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+	filters := data.Filters{
+		Page:         app.readInt(qs, "page", 1, v),
+		PageSize:     app.readInt(qs, "page_size", 20, v),
+		Sort:         app.readString(qs, "sort", "-created_at"),
+		SortSafelist: []string{"id", "created_at", "type", "-id", "-created_at", "-type"},
+	}
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	alerts, metadata, err := app.models.Alerts.GetAll(app.contextGetUser(r).ID, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"alerts": alerts, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The createAlertHandler() subscribes the user to price drops or restocks of a single
+// watch, or of every watch matching a filter.
+func (app *application) createAlertHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Type        string            `json:"type"`
+		WatchID     *int64            `json:"watch_id"`
+		Filter      *data.AlertFilter `json:"filter"`
+		TargetPrice *data.Money       `json:"target_price"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	alert := &data.Alert{
+		UserID:      app.contextGetUser(r).ID,
+		Type:        input.Type,
+		WatchID:     input.WatchID,
+		Filter:      input.Filter,
+		TargetPrice: input.TargetPrice,
+	}
+	v := validator.New()
+	if data.ValidateAlert(v, alert); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	if alert.WatchID != nil {
+		_, err = app.findVisibleWatch(r, *alert.WatchID)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				v.AddError("watch_id", "watch does not exist")
+				app.failedValidationResponse(w, r, v.Errors)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+	}
+
+	err = app.models.Alerts.Insert(alert)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("watch_id", "watch does not exist")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusCreated, envelope{"alert": alert}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteAlertHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	err = app.models.Alerts.Delete(id, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "alert successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The unsubscribeAlertHandler() serves the unsubscribe links in alert emails. It
+// doesn't require authentication, so the link carries a token signed with the
+// server's secret instead.
+func (app *application) unsubscribeAlertHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	token := app.readString(r.URL.Query(), "token", "")
+	expected := app.alertUnsubscribeToken(id)
+	if !hmac.Equal([]byte(token), []byte(expected)) {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Alerts.Delete(id, 0)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	// Following the link twice isn't an error.
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "you have been unsubscribed from this alert"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// alertUnsubscribeToken signs an alert id for use in an unsubscribe link.
+func (app *application) alertUnsubscribeToken(alertID int64) string {
+	mac := hmac.New(sha256.New, []byte(app.config.alerts.signingSecret))
+	fmt.Fprintf(mac, "alert-unsubscribe:%d", alertID)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliverAlerts is run periodically to email the queued alert notifications, one
+// email per user, skipping users who were emailed within the throttle period. Their
+// notifications stay queued and go out together in the next email.
+func (app *application) deliverAlerts() {
+	digests, err := app.models.Alerts.GetDigests(app.config.alerts.throttle)
+	if err != nil {
+		app.logger.PrintError(err, map[string]string{"job": "deliver alerts"})
+		return
+	}
+
+	type notification struct {
+		Title          string
+		Type           string
+		Price          data.Money
+		UnsubscribeURL string
+	}
+	for _, digest := range digests {
+		notifications := make([]notification, len(digest.Notifications))
+		for i, n := range digest.Notifications {
+			notifications[i] = notification{
+				Title: n.WatchTitle,
+				Type:  n.Type,
+				Price: n.Price,
+				UnsubscribeURL: fmt.Sprintf("%s/v1/alerts/%d/unsubscribe?token=%s",
+					app.config.baseURL, n.AlertID, url.QueryEscape(app.alertUnsubscribeToken(n.AlertID))),
+			}
+		}
+		data := map[string]interface{}{
+			"name":          digest.Name,
+			"notifications": notifications,
+		}
+		properties := map[string]string{"job": "deliver alerts", "user_id": fmt.Sprint(digest.UserID)}
+		err := app.mailer.Send(digest.Email, "alert_notification.tmpl", data)
+		if err != nil {
+			app.logger.PrintError(err, properties)
+			continue
+		}
+		err = app.models.Alerts.MarkSent(digest.Notifications...)
+		if err != nil {
+			app.logger.PrintError(err, properties)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"greenlight.alexedwards.net/internal/data"
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+// readAnalyticsQuery reads the watch list filters and the "format" parameter, which
+// is "json" (the default) or "csv", shared by all the analytics endpoints.
+func (app *application) readAnalyticsQuery(r *http.Request, v *validator.Validator) (data.WatchesQuery, string, error) {
+	qs := r.URL.Query()
+	format := app.readString(qs, "format", "json")
+	v.Check(validator.In(format, "json", "csv"), "format", "must be json or csv")
+	query, err := app.readWatchesFilter(qs, v)
+	return query, format, err
+}
+
+// writeCSV sends a CSV file with a header row, as a download with the given name.
+func (app *application) writeCSV(w http.ResponseWriter, filename string, header []string, rows [][]string) error {
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	w.WriteHeader(http.StatusOK)
+
+	cw := csv.NewWriter(w)
+	cw.Write(header)
+	cw.WriteAll(rows)
+	return cw.Error()
+}
+
+func formatPrice(f float64) string {
+	return strconv.FormatFloat(f, 'f', 2, 64)
+}
+
+// The priceStatsHandler() reports price statistics, overall or grouped by brand,
+// material or year with "group_by".
+func (app *application) priceStatsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	query, format, err := app.readAnalyticsQuery(r, v)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	group := app.readString(r.URL.Query(), "group_by", "")
+	if group != "" {
+		v.Check(validator.In(group, data.AnalyticsGroups...), "group_by", "must be brand, material or year")
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	stats, err := app.models.Analytics.PriceStats(query, group)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if format == "csv" {
+		rows := make([][]string, len(stats))
+		for i, s := range stats {
+			rows[i] = []string{s.Group, strconv.Itoa(s.Count), formatPrice(s.Min), formatPrice(s.Max), formatPrice(s.Avg), formatPrice(s.Median)}
+		}
+		err = app.writeCSV(w, "price-stats.csv", []string{"group", "count", "min", "max", "avg", "median"}, rows)
+	} else {
+		err = app.writeJSON(w, http.StatusOK, envelope{"stats": stats, "currency": data.BaseCurrency}, nil)
+	}
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) priceHistogramHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	query, format, err := app.readAnalyticsQuery(r, v)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	buckets := app.readInt(r.URL.Query(), "buckets", 10, v)
+	v.Check(buckets >= 1 && buckets <= 100, "buckets", "must be between 1 and 100")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	histogram, err := app.models.Analytics.PriceHistogram(query, buckets)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if format == "csv" {
+		rows := make([][]string, len(histogram))
+		for i, b := range histogram {
+			rows[i] = []string{formatPrice(b.From), formatPrice(b.To), strconv.Itoa(b.Count)}
+		}
+		err = app.writeCSV(w, "price-histogram.csv", []string{"from", "to", "count"}, rows)
+	} else {
+		err = app.writeJSON(w, http.StatusOK, envelope{"histogram": histogram, "currency": data.BaseCurrency}, nil)
+	}
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) yearCountsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	query, format, err := app.readAnalyticsQuery(r, v)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	counts, err := app.models.Analytics.CountsByYear(query)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if format == "csv" {
+		rows := make([][]string, len(counts))
+		for i, c := range counts {
+			rows[i] = []string{strconv.Itoa(int(c.Year)), strconv.Itoa(c.Count)}
+		}
+		err = app.writeCSV(w, "years.csv", []string{"year", "count"}, rows)
+	} else {
+		err = app.writeJSON(w, http.StatusOK, envelope{"years": counts}, nil)
+	}
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The priceTrendHandler() reports how the prices of the watches in the catalog have
+// moved, per "interval" (a month by default).
+func (app *application) priceTrendHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	query, format, err := app.readAnalyticsQuery(r, v)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	interval := app.readString(r.URL.Query(), "interval", "month")
+	v.Check(validator.In(interval, data.AnalyticsIntervals...), "interval", "must be day, week, month, quarter or year")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	trend, err := app.models.Analytics.PriceTrend(query, interval)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if format == "csv" {
+		rows := make([][]string, len(trend))
+		for i, p := range trend {
+			rows[i] = []string{p.Period.Format(time.RFC3339), strconv.Itoa(p.Count), formatPrice(p.Avg), formatPrice(p.Median)}
+		}
+		err = app.writeCSV(w, "price-trend.csv", []string{"period", "count", "avg", "median"}, rows)
+	} else {
+		err = app.writeJSON(w, http.StatusOK, envelope{"trend": trend, "currency": data.BaseCurrency}, nil)
+	}
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"greenlight.alexedwards.net/internal/data"
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+func (app *application) createAuctionHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		WatchID         int64      `json:"watch_id"`
+		StartsAt        *time.Time `json:"starts_at"`
+		EndsAt          time.Time  `json:"ends_at"`
+		StartingPrice   data.Money `json:"starting_price"`
+		ReservePrice    data.Money `json:"reserve_price"`
+		MinIncrement    data.Money `json:"min_increment"`
+		ExtensionWindow *int32     `json:"extension_window_seconds"`
+		Extension       *int32     `json:"extension_seconds"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	auction := &data.Auction{
+		WatchID:         input.WatchID,
+		StartsAt:        time.Now(),
+		EndsAt:          input.EndsAt,
+		StartingPrice:   input.StartingPrice,
+		ReservePrice:    input.ReservePrice,
+		MinIncrement:    input.MinIncrement,
+		ExtensionWindow: 120,
+		Extension:       120,
+	}
+	if input.StartsAt != nil {
+		auction.StartsAt = *input.StartsAt
+	}
+	if input.ExtensionWindow != nil {
+		auction.ExtensionWindow = *input.ExtensionWindow
+	}
+	if input.Extension != nil {
+		auction.Extension = *input.Extension
+	}
+
+	v := validator.New()
+	if data.ValidateAuction(v, auction); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Auctions.Insert(auction)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("watch_id", "watch does not exist")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrNoUnitsAvailable):
+			app.conflictResponse(w, r, "the watch isn't published or every unit is already reserved")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/auctions/%d", auction.ID))
+	err = app.writeJSON(w, http.StatusCreated, envelope{"auction": auction}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// getAuction fetches the auction named in the URL, writing the error response and
+// returning nil if it can't be found or the user may not see the watch on sale.
+func (app *application) getAuction(w http.ResponseWriter, r *http.Request) *data.Auction {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil
+	}
+	auction, err := app.models.Auctions.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil
+	}
+	_, err = app.findVisibleWatch(r, auction.WatchID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil
+	}
+	return auction
+}
+
+func (app *application) showAuctionHandler(w http.ResponseWriter, r *http.Request) {
+	auction := app.getAuction(w, r)
+	if auction == nil {
+		return
+	}
+	err := app.writeJSON(w, http.StatusOK, envelope{"auction": auction, "minimum_bid": auction.MinimumBid()}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listAuctionsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		WatchID int
+		Status  string
+		data.Filters
+	}
+	v := validator.New()
+	qs := r.URL.Query()
+	input.WatchID = app.readInt(qs, "watch_id", 0, v)
+	input.Status = app.readString(qs, "status", "")
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "ends_at")
+	input.Filters.SortSafelist = []string{"id", "starts_at", "ends_at", "-id", "-starts_at", "-ends_at"}
+	if input.Status != "" {
+		v.Check(validator.In(input.Status, data.AuctionStatuses...), "status", "must be a valid auction status")
+	}
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	auctions, metadata, err := app.models.Auctions.GetAll(int64(input.WatchID), input.Status, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"auctions": auctions, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) cancelAuctionHandler(w http.ResponseWriter, r *http.Request) {
+	auction := app.getAuction(w, r)
+	if auction == nil {
+		return
+	}
+	if validator.In(auction.Status, data.AuctionEnded, data.AuctionClosed, data.AuctionCancelled) {
+		app.conflictResponse(w, r, fmt.Sprintf("the auction is already %s", auction.Status))
+		return
+	}
+	err := app.models.Auctions.Cancel(auction)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"auction": auction}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listBidsHandler(w http.ResponseWriter, r *http.Request) {
+	auction := app.getAuction(w, r)
+	if auction == nil {
+		return
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+	filters := data.Filters{
+		Page:         app.readInt(qs, "page", 1, v),
+		PageSize:     app.readInt(qs, "page_size", 20, v),
+		Sort:         app.readString(qs, "sort", "-amount"),
+		SortSafelist: []string{"amount", "created_at", "-amount", "-created_at"},
+	}
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	bids, metadata, err := app.models.Auctions.GetBids(auction.ID, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"bids": bids, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createBidHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Amount data.Money `json:"amount"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	current := app.getAuction(w, r)
+	if current == nil {
+		return
+	}
+	bid := &data.Bid{AuctionID: current.ID, UserID: app.contextGetUser(r).ID, Amount: input.Amount}
+	v := validator.New()
+	if data.ValidateBid(v, bid); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	auction, outbid, err := app.models.Auctions.PlaceBid(bid)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrAuctionNotOpen):
+			app.conflictResponse(w, r, "the auction is not open for bidding")
+		case errors.Is(err, data.ErrDuplicateBid):
+			app.conflictResponse(w, r, err.Error())
+		case errors.Is(err, data.ErrBidTooLow):
+			v.AddError("amount", err.Error())
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if outbid != nil {
+		app.background(func() {
+			data := map[string]interface{}{
+				"name":       outbid.Name,
+				"auctionID":  auction.ID,
+				"yourBid":    outbid.Amount,
+				"currentBid": bid.Amount,
+				"endsAt":     auction.EndsAt.Format(time.RFC1123),
+			}
+			err := app.mailer.Send(outbid.Email, "auction_outbid.tmpl", data)
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+		})
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"bid": bid, "auction": auction}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// closeAuctions is run periodically to close the auctions that have ended, and to
+// tell the winners about the orders placed for them.
+func (app *application) closeAuctions() {
+	results, err := app.models.Auctions.CloseDue(app.config.auctions.paymentDuration)
+	if err != nil {
+		app.logger.PrintError(err, map[string]string{"job": "close auctions"})
+		return
+	}
+	for _, result := range results {
+		properties := map[string]string{"auction_id": fmt.Sprint(result.AuctionID), "winner": "none"}
+		if result.Winner != nil {
+			properties["winner"] = fmt.Sprint(result.Winner.UserID)
+			properties["order_id"] = fmt.Sprint(result.Order.ID)
+			data := map[string]interface{}{
+				"name":      result.Winner.Name,
+				"auctionID": result.AuctionID,
+				"amount":    result.Winner.Amount,
+				"order":     result.Order,
+			}
+			err := app.mailer.Send(result.Winner.Email, "auction_won.tmpl", data)
+			if err != nil {
+				app.logger.PrintError(err, properties)
+			}
+		}
+		app.logger.PrintInfo("auction closed", properties)
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"greenlight.alexedwards.net/internal/data"
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+// catalogEntityKind describes one of the normalized catalog tables, so that brands and
+// materials can share the same handlers.
+type catalogEntityKind struct {
+	model    data.CatalogEntityModel
+	singular string
+	plural   string
+}
+
+func (app *application) brandKind() catalogEntityKind {
+	return catalogEntityKind{model: app.models.Brands, singular: "brand", plural: "brands"}
+}
+
+func (app *application) materialKind() catalogEntityKind {
+	return catalogEntityKind{model: app.models.Materials, singular: "material", plural: "materials"}
+}
+
+// The resolveWatchEntities() helper maps the brand and material names on a watch to
+// their canonical entities, replacing the names with the canonical ones and setting
+// the ids to link. Unknown names are recorded as validation errors unless the
+// catalog-auto-create setting is enabled, in which case they are left for the watch
+// model to create in the same transaction that saves the watch.
+func (app *application) resolveWatchEntities(watch *data.Watches, v *validator.Validator) error {
+	var err error
+	watch.Brand, watch.BrandIDs, watch.NewBrands, err = app.resolveEntityNames(app.brandKind(), watch.Brand, "watchesBrand", v)
+	if err != nil {
+		return err
+	}
+	watch.Material, watch.MaterialIDs, watch.NewMaterials, err = app.resolveEntityNames(app.materialKind(), watch.Material, "watchesMaterial", v)
+	return err
+}
+
+func (app *application) resolveEntityNames(kind catalogEntityKind, names []string, key string, v *validator.Validator) ([]string, []int64, []string, error) {
+	entities, unknown, err := kind.model.Resolve(names)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	canonicalNames := make([]string, 0, len(names))
+	ids := make([]int64, 0, len(entities))
+	for _, entity := range entities {
+		canonicalNames = append(canonicalNames, entity.Name)
+		ids = append(ids, entity.ID)
+	}
+
+	var created, rejected []string
+	seen := make(map[string]bool)
+	for _, name := range unknown {
+		slug := data.Slugify(name)
+		if !app.config.catalog.autoCreate || slug == "" {
+			rejected = append(rejected, name)
+			continue
+		}
+		if !seen[slug] {
+			seen[slug] = true
+			created = append(created, strings.TrimSpace(name))
+		}
+	}
+	if len(rejected) > 0 {
+		v.AddError(key, fmt.Sprintf("unknown %s: %s", kind.singular, strings.Join(rejected, ", ")))
+	}
+	return append(canonicalNames, created...), ids, created, nil
+}
+
+func (app *application) createCatalogEntityHandler(kind catalogEntityKind) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var input struct {
+			Name        string   `json:"name"`
+			Slug        string   `json:"slug"`
+			Aliases     []string `json:"aliases"`
+			Description string   `json:"description"`
+		}
+		err := app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+
+		entity := &data.CatalogEntity{
+			Name:        strings.TrimSpace(input.Name),
+			Slug:        input.Slug,
+			Aliases:     input.Aliases,
+			Description: input.Description,
+		}
+		if entity.Slug == "" {
+			entity.Slug = data.Slugify(entity.Name)
+		}
+		if entity.Aliases == nil {
+			entity.Aliases = []string{}
+		}
+
+		v := validator.New()
+		if data.ValidateCatalogEntity(v, entity); !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		err = kind.model.Insert(entity)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrDuplicateSlug):
+				v.AddError("slug", fmt.Sprintf("a %s with this slug or alias already exists", kind.singular))
+				app.failedValidationResponse(w, r, v.Errors)
+			case errors.Is(err, data.ErrDuplicateAlias):
+				v.AddError("aliases", fmt.Sprintf("must not contain the slug or alias of another %s", kind.singular))
+				app.failedValidationResponse(w, r, v.Errors)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		headers := make(http.Header)
+		headers.Set("Location", fmt.Sprintf("/v1/%s/%d", kind.plural, entity.ID))
+		err = app.writeJSON(w, http.StatusCreated, envelope{kind.singular: entity}, headers)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+	}
+}
+
+func (app *application) showCatalogEntityHandler(kind catalogEntityKind) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := app.readIDParam(r)
+		if err != nil {
+			app.notFoundResponse(w, r)
+			return
+		}
+		entity, err := kind.model.Get(id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+		err = app.writeJSON(w, http.StatusOK, envelope{kind.singular: entity}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+	}
+}
+
+func (app *application) updateCatalogEntityHandler(kind catalogEntityKind) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := app.readIDParam(r)
+		if err != nil {
+			app.notFoundResponse(w, r)
+			return
+		}
+		entity, err := kind.model.Get(id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		var input struct {
+			Name        *string   `json:"name"`
+			Slug        *string   `json:"slug"`
+			Aliases     *[]string `json:"aliases"`
+			Description *string   `json:"description"`
+		}
+		err = app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+		if input.Name != nil {
+			entity.Name = strings.TrimSpace(*input.Name)
+		}
+		if input.Slug != nil {
+			entity.Slug = *input.Slug
+		}
+		if input.Aliases != nil {
+			entity.Aliases = *input.Aliases
+		}
+		if input.Description != nil {
+			entity.Description = *input.Description
+		}
+
+		v := validator.New()
+		if data.ValidateCatalogEntity(v, entity); !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		err = kind.model.Update(entity)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrDuplicateSlug):
+				v.AddError("slug", fmt.Sprintf("a %s with this slug or alias already exists", kind.singular))
+				app.failedValidationResponse(w, r, v.Errors)
+			case errors.Is(err, data.ErrDuplicateAlias):
+				v.AddError("aliases", fmt.Sprintf("must not contain the slug or alias of another %s", kind.singular))
+				app.failedValidationResponse(w, r, v.Errors)
+			case errors.Is(err, data.ErrEditConflict):
+				app.editConflictResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+		err = app.writeJSON(w, http.StatusOK, envelope{kind.singular: entity}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+	}
+}
+
+func (app *application) deleteCatalogEntityHandler(kind catalogEntityKind) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := app.readIDParam(r)
+		if err != nil {
+			app.notFoundResponse(w, r)
+			return
+		}
+		err = kind.model.Delete(id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			case errors.Is(err, data.ErrEntityInUse):
+				message := fmt.Sprintf("the %s is still linked to watches and can't be deleted", kind.singular)
+				app.errorResponse(w, r, http.StatusConflict, message)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+		err = app.writeJSON(w, http.StatusOK, envelope{"message": kind.singular + " successfully deleted"}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+	}
+}
+
+func (app *application) listCatalogEntitiesHandler(kind catalogEntityKind) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var input struct {
+			Name string
+			data.Filters
+		}
+		v := validator.New()
+		qs := r.URL.Query()
+		input.Name = app.readString(qs, "name", "")
+		input.Filters.Page = app.readInt(qs, "page", 1, v)
+		input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+		input.Filters.Sort = app.readString(qs, "sort", "name")
+		input.Filters.SortSafelist = []string{"id", "name", "slug", "-id", "-name", "-slug"}
+		if data.ValidateFilters(v, input.Filters); !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+		entities, metadata, err := kind.model.GetAll(input.Name, input.Filters)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		err = app.writeJSON(w, http.StatusOK, envelope{kind.plural: entities, "metadata": metadata}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"greenlight.alexedwards.net/internal/data"
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+// The attachWatchDetails() helper fills in the images and category breadcrumbs of
+// the given watches, for responses that return whole watches.
+func (app *application) attachWatchDetails(watches ...*data.Watches) error {
+	err := app.attachImages(watches...)
+	if err != nil {
+		return err
+	}
+	ids := make([]int64, 0, len(watches))
+	for _, watch := range watches {
+		ids = append(ids, watch.ID)
+	}
+	breadcrumbs, err := app.models.Categories.GetBreadcrumbsForWatches(ids...)
+	if err != nil {
+		return err
+	}
+	for _, watch := range watches {
+		watch.Categories = breadcrumbs[watch.ID]
+	}
+	return nil
+}
+
+// The listCategoriesHandler() returns the whole category tree.
+func (app *application) listCategoriesHandler(w http.ResponseWriter, r *http.Request) {
+	categories, err := app.models.Categories.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"categories": data.CategoryTree(categories)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		ParentID    *int64 `json:"parent_id"`
+		Name        string `json:"name"`
+		Slug        string `json:"slug"`
+		Description string `json:"description"`
+		Position    int    `json:"position"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	category := &data.Category{
+		ParentID:    input.ParentID,
+		Name:        strings.TrimSpace(input.Name),
+		Slug:        input.Slug,
+		Description: input.Description,
+		Position:    input.Position,
+	}
+	if category.Slug == "" {
+		category.Slug = data.Slugify(category.Name)
+	}
+
+	v := validator.New()
+	if data.ValidateCategory(v, category); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Categories.Insert(category)
+	if err != nil {
+		app.categoryErrorResponse(w, r, v, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/categories/%d", category.ID))
+	err = app.writeJSON(w, http.StatusCreated, envelope{"category": category}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// getCategory fetches the category named in the URL, writing the error response and
+// returning nil if it can't be found.
+func (app *application) getCategory(w http.ResponseWriter, r *http.Request) *data.Category {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil
+	}
+	category, err := app.models.Categories.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil
+	}
+	return category
+}
+
+// The showCategoryHandler() returns a category with its breadcrumb.
+func (app *application) showCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	category := app.getCategory(w, r)
+	if category == nil {
+		return
+	}
+	breadcrumb, err := app.models.Categories.GetBreadcrumbs(category.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"category": category, "breadcrumb": breadcrumb}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The updateCategoryHandler() edits a category. It can also move the category to
+// another parent, or to the top level with "parent_id": 0.
+func (app *application) updateCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	category := app.getCategory(w, r)
+	if category == nil {
+		return
+	}
+
+	var input struct {
+		ParentID    *int64  `json:"parent_id"`
+		Name        *string `json:"name"`
+		Slug        *string `json:"slug"`
+		Description *string `json:"description"`
+		Position    *int    `json:"position"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if input.ParentID != nil {
+		category.ParentID = input.ParentID
+		if *input.ParentID == 0 {
+			category.ParentID = nil
+		}
+	}
+	if input.Name != nil {
+		category.Name = strings.TrimSpace(*input.Name)
+	}
+	if input.Slug != nil {
+		category.Slug = *input.Slug
+	}
+	if input.Description != nil {
+		category.Description = *input.Description
+	}
+	if input.Position != nil {
+		category.Position = *input.Position
+	}
+
+	v := validator.New()
+	if data.ValidateCategory(v, category); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Categories.Update(category)
+	if err != nil {
+		app.categoryErrorResponse(w, r, v, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"category": category}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) categoryErrorResponse(w http.ResponseWriter, r *http.Request, v *validator.Validator, err error) {
+	switch {
+	case errors.Is(err, data.ErrDuplicateSlug):
+		v.AddError("slug", "a category with this slug already exists")
+		app.failedValidationResponse(w, r, v.Errors)
+	case errors.Is(err, data.ErrUnknownCategory):
+		v.AddError("parent_id", "must be an existing category")
+		app.failedValidationResponse(w, r, v.Errors)
+	case errors.Is(err, data.ErrCategoryCycle):
+		v.AddError("parent_id", "must not be the category itself or one of its subcategories")
+		app.failedValidationResponse(w, r, v.Errors)
+	case errors.Is(err, data.ErrEditConflict):
+		app.editConflictResponse(w, r)
+	default:
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	err = app.models.Categories.Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrCategoryHasChildren):
+			app.conflictResponse(w, r, "the category has subcategories and can't be deleted")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "category successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The setWatchCategoriesHandler() replaces the categories that a watch is in.
+func (app *application) setWatchCategoriesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	var input struct {
+		CategoryIDs []int64 `json:"category_ids"`
+	}
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	v.Check(input.CategoryIDs != nil, "category_ids", "must be provided")
+	v.Check(len(input.CategoryIDs) <= 20, "category_ids", "must not contain more than 20 categories")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	watch, err := app.models.Watches.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.models.Categories.SetForWatch(watch.ID, input.CategoryIDs)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrUnknownCategory):
+			v.AddError("category_ids", "must only contain existing categories")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.attachWatchDetails(watch)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"watches": watch}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
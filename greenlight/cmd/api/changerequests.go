@@ -0,0 +1,291 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"greenlight.alexedwards.net/internal/data"
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+// requestApproval holds back a sensitive change to a watch by saving it as a pending
+// change request, and responds with 202 Accepted. This applies to approvers too: no
+// one can review their own request, so someone else always decides.
+func (app *application) requestApproval(w http.ResponseWriter, r *http.Request, watch *data.Watches, action string, changes interface{}) {
+	var err error
+	cr := &data.ChangeRequest{
+		WatchID:      &watch.ID,
+		WatchTitle:   watch.Title,
+		UserID:       app.contextGetUser(r).ID,
+		Action:       action,
+		WatchVersion: watch.Version,
+	}
+	if changes != nil {
+		cr.Changes, err = json.Marshal(changes)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+	err = app.models.Changes.Insert(cr)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/change-requests/%d", cr.ID))
+	env := envelope{"change_request": cr, "message": "the change needs approval and has been submitted for review"}
+	err = app.writeJSON(w, http.StatusAccepted, env, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) readChangeRequestFilters(r *http.Request, v *validator.Validator) data.Filters {
+	qs := r.URL.Query()
+	filters := data.Filters{
+		Page:         app.readInt(qs, "page", 1, v),
+		PageSize:     app.readInt(qs, "page_size", 20, v),
+		Sort:         app.readString(qs, "sort", "created_at"),
+		SortSafelist: []string{"id", "created_at", "-id", "-created_at"},
+	}
+	data.ValidateFilters(v, filters)
+	return filters
+}
+
+// The listChangeRequestsHandler() is the approval queue. It lists pending requests,
+// oldest first, unless another status is asked for.
+func (app *application) listChangeRequestsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+	watchID := app.readInt(qs, "watch_id", 0, v)
+	status := app.readString(qs, "status", data.ReviewPending)
+	v.Check(validator.In(status, data.ChangeRequestStatuses...), "status", "must be pending, approved or rejected")
+	filters := app.readChangeRequestFilters(r, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	requests, metadata, err := app.models.Changes.GetAll(int64(watchID), 0, status, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"change_requests": requests, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The listMyChangeRequestsHandler() lets an editor follow the changes they submitted.
+func (app *application) listMyChangeRequestsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	filters := app.readChangeRequestFilters(r, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	requests, metadata, err := app.models.Changes.GetAll(0, app.contextGetUser(r).ID, "", filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"change_requests": requests, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// getChangeRequest fetches the change request named in the URL, writing the error
+// response and returning nil if it can't be found.
+func (app *application) getChangeRequest(w http.ResponseWriter, r *http.Request) *data.ChangeRequest {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil
+	}
+	cr, err := app.models.Changes.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil
+	}
+	return cr
+}
+
+func (app *application) showChangeRequestHandler(w http.ResponseWriter, r *http.Request) {
+	cr := app.getChangeRequest(w, r)
+	if cr == nil {
+		return
+	}
+	err := app.writeJSON(w, http.StatusOK, envelope{"change_request": cr}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The approveChangeRequestHandler() applies a pending change. An update goes through
+// the usual version check against the version of the watch the change was made on, so
+// a watch that has been edited since can't be overwritten; such a request can only be
+// rejected. A delete is approved in the same transaction that deletes the watch.
+// Requesters can't approve their own changes.
+func (app *application) approveChangeRequestHandler(w http.ResponseWriter, r *http.Request) {
+	cr, note := app.readChangeRequestReview(w, r)
+	if cr == nil {
+		return
+	}
+	if cr.WatchID == nil {
+		app.conflictResponse(w, r, "the watch has been deleted, so the change can only be rejected")
+		return
+	}
+
+	watch, err := app.models.Watches.Get(*cr.WatchID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	switch cr.Action {
+	case data.ChangeUpdate:
+		var patch watchPatch
+		err = json.Unmarshal(cr.Changes, &patch)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		patch.apply(watch)
+		watch.Version = cr.WatchVersion
+
+		v := validator.New()
+		err = app.validateWatchUpdate(watch, v)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+		err = app.models.Watches.Update(watch, cr.UserID)
+	case data.ChangeDelete:
+		if watch.Version != cr.WatchVersion {
+			err = data.ErrEditConflict
+			break
+		}
+		app.reviewChangeRequest(w, r, cr, data.ReviewApproved, note, func(cr *data.ChangeRequest) error {
+			return app.deleteWatch(watch.ID, func() error { return app.models.Changes.ApproveDelete(cr) })
+		})
+		return
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.conflictResponse(w, r, "the watch has changed since the change was requested, so it can only be rejected")
+		case errors.Is(err, data.ErrWatchInUse):
+			app.conflictResponse(w, r, "the watch has orders or auctions and can't be deleted")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.reviewChangeRequest(w, r, cr, data.ReviewApproved, note, app.models.Changes.Update)
+}
+
+func (app *application) rejectChangeRequestHandler(w http.ResponseWriter, r *http.Request) {
+	cr, note := app.readChangeRequestReview(w, r)
+	if cr == nil {
+		return
+	}
+	app.reviewChangeRequest(w, r, cr, data.ReviewRejected, note, app.models.Changes.Update)
+}
+
+// readChangeRequestReview fetches the change request named in the URL and the
+// reviewer's optional note, and checks that the user may review it. It writes the
+// error response and returns nil when they can't.
+func (app *application) readChangeRequestReview(w http.ResponseWriter, r *http.Request) (*data.ChangeRequest, string) {
+	var input struct {
+		Note string `json:"note"`
+	}
+	if r.ContentLength != 0 {
+		err := app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return nil, ""
+		}
+	}
+
+	cr := app.getChangeRequest(w, r)
+	if cr == nil {
+		return nil, ""
+	}
+	if cr.Status != data.ReviewPending {
+		app.conflictResponse(w, r, fmt.Sprintf("the change request has already been %s", cr.Status))
+		return nil, ""
+	}
+	if cr.UserID == app.contextGetUser(r).ID {
+		app.errorResponse(w, r, http.StatusForbidden, "change requests must be reviewed by someone else")
+		return nil, ""
+	}
+	return cr, input.Note
+}
+
+// reviewChangeRequest records the decision on a change request with save and emails it
+// to the requester.
+func (app *application) reviewChangeRequest(w http.ResponseWriter, r *http.Request, cr *data.ChangeRequest, status, note string, save func(*data.ChangeRequest) error) {
+	reviewer := app.contextGetUser(r).ID
+	now := time.Now()
+	cr.Status = status
+	cr.ReviewedBy = &reviewer
+	cr.ReviewedAt = &now
+	cr.ReviewNote = note
+
+	v := validator.New()
+	if data.ValidateChangeRequestReview(v, cr); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err := save(cr)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrWatchInUse):
+			app.conflictResponse(w, r, "the watch has orders or auctions and can't be deleted")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.background(func() {
+		data := map[string]interface{}{
+			"name":    cr.RequesterName,
+			"request": cr,
+		}
+		err := app.mailer.Send(cr.RequesterEmail, "change_request_reviewed.tmpl", data)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"change_request_id": fmt.Sprint(cr.ID)})
+		}
+	})
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"change_request": cr}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"greenlight.alexedwards.net/internal/data"
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+func (app *application) listCollectionsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+	filters := data.Filters{
+		Page:         app.readInt(qs, "page", 1, v),
+		PageSize:     app.readInt(qs, "page_size", 20, v),
+		Sort:         app.readString(qs, "sort", "name"),
+		SortSafelist: []string{"id", "name", "slug", "-id", "-name", "-slug"},
+	}
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	collections, metadata, err := app.models.Collections.GetAll(filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"collections": collections, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name        string `json:"name"`
+		Slug        string `json:"slug"`
+		Description string `json:"description"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	collection := &data.Collection{
+		Name:        strings.TrimSpace(input.Name),
+		Slug:        input.Slug,
+		Description: input.Description,
+	}
+	if collection.Slug == "" {
+		collection.Slug = data.Slugify(collection.Name)
+	}
+
+	v := validator.New()
+	if data.ValidateCollection(v, collection); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Collections.Insert(collection)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateSlug):
+			v.AddError("slug", "a collection with this slug already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/collections/%d", collection.ID))
+	err = app.writeJSON(w, http.StatusCreated, envelope{"collection": collection}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// getCollection fetches the collection named in the URL, writing the error response
+// and returning nil if it can't be found.
+func (app *application) getCollection(w http.ResponseWriter, r *http.Request) *data.Collection {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil
+	}
+	collection, err := app.models.Collections.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil
+	}
+	return collection
+}
+
+func (app *application) showCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	collection := app.getCollection(w, r)
+	if collection == nil {
+		return
+	}
+	err := app.writeJSON(w, http.StatusOK, envelope{"collection": collection}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	collection := app.getCollection(w, r)
+	if collection == nil {
+		return
+	}
+
+	var input struct {
+		Name        *string `json:"name"`
+		Slug        *string `json:"slug"`
+		Description *string `json:"description"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if input.Name != nil {
+		collection.Name = strings.TrimSpace(*input.Name)
+	}
+	if input.Slug != nil {
+		collection.Slug = *input.Slug
+	}
+	if input.Description != nil {
+		collection.Description = *input.Description
+	}
+
+	v := validator.New()
+	if data.ValidateCollection(v, collection); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Collections.Update(collection)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateSlug):
+			v.AddError("slug", "a collection with this slug already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"collection": collection}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	err = app.models.Collections.Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "collection successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The listCollectionWatchesHandler() lists the watches in a collection, in the
+// collection's own order unless another sort is asked for. Only editors see the
+// watches that aren't published.
+func (app *application) listCollectionWatchesHandler(w http.ResponseWriter, r *http.Request) {
+	collection := app.getCollection(w, r)
+	if collection == nil {
+		return
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+	filters := data.Filters{
+		Page:         app.readInt(qs, "page", 1, v),
+		PageSize:     app.readInt(qs, "page_size", 20, v),
+		Sort:         app.readString(qs, "sort", "position"),
+		SortSafelist: []string{"position", "id", "brand", "year", "price", "rating", "-position", "-id", "-brand", "-year", "-price", "-rating"},
+	}
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	statuses := []string{data.WatchPublished}
+	canEdit, err := app.canEditWatches(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if canEdit {
+		statuses = data.WatchStatuses
+	}
+
+	watches, metadata, err := app.models.Collections.GetWatches(collection.ID, statuses, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.attachWatchDetails(watches...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	env := envelope{"collection": collection, "watches": watches, "metadata": metadata}
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The setCollectionWatchesHandler() replaces the watches in a collection. The order
+// of "watch_ids" becomes the collection's order.
+func (app *application) setCollectionWatchesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	var input struct {
+		WatchIDs []int64 `json:"watch_ids"`
+	}
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	if data.ValidateCollectionWatches(v, input.WatchIDs); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Collections.SetWatches(id, input.WatchIDs)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrUnknownWatch):
+			v.AddError("watch_ids", "must only contain existing watches")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	collection, err := app.models.Collections.Get(id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"collection": collection}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
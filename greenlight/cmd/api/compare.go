@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"greenlight.alexedwards.net/internal/data"
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+// watchSegmentHandler serves the fixed paths directly under /v1/watches/ that share
+// the :id wildcard with the routes for a single watch. Any other value of the segment
+// is not found.
+func (app *application) watchSegmentHandler(handlers map[string]http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		segment := httprouter.ParamsFromContext(r.Context()).ByName("id")
+		next, ok := handlers[segment]
+		if !ok {
+			app.notFoundResponse(w, r)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// The compareWatchesHandler() returns 2 to 5 watches, given as "ids=1,2,3", side by
+// side with an attribute matrix that marks the attributes that differ. Prices are
+// converted into one currency so that they can be compared; it defaults to the base
+// currency. An id that doesn't exist is reported in "errors" rather than failing the
+// whole comparison.
+func (app *application) compareWatchesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+	currency := app.readCurrency(qs, v)
+	if currency == "" {
+		currency = data.BaseCurrency
+	}
+	ids := []int64{}
+	for _, s := range app.readCSV(qs, "ids", []string{}) {
+		id, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+		if err != nil || id < 1 {
+			v.AddError("ids", "must be a comma-separated list of watch ids")
+			break
+		}
+		ids = append(ids, id)
+	}
+	if data.ValidateCompareIDs(v, ids); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	watches, err := app.models.Watches.GetMany(ids)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	// Watches the user isn't allowed to see are reported as not found.
+	visible := watches[:0]
+	for _, watch := range watches {
+		ok, err := app.canSeeWatch(r, watch)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if ok {
+			visible = append(visible, watch)
+		}
+	}
+	watches = visible
+	err = app.attachWatchDetails(watches...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	rates, err := app.models.Rates.GetLatest()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	conversion := rates.ConvertWatches(currency, watches...)
+
+	env := envelope{
+		"watches":    watches,
+		"comparison": data.CompareWatches(watches),
+		"metadata":   envelope{"conversion": conversion},
+	}
+	found := make(map[int64]bool, len(watches))
+	for _, watch := range watches {
+		found[watch.ID] = true
+	}
+	missing := make(map[string]string)
+	for _, id := range ids {
+		if !found[id] {
+			missing[fmt.Sprint(id)] = "watch not found"
+		}
+	}
+	if len(missing) > 0 {
+		env["errors"] = missing
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
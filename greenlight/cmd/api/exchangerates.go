@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"greenlight.alexedwards.net/internal/data"
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+func (app *application) createExchangeRatesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		RateDate string             `json:"rate_date"`
+		Source   string             `json:"source"`
+		Rates    map[string]float64 `json:"rates"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	rateDate, err := time.Parse("2006-01-02", input.RateDate)
+	if err != nil {
+		v.AddError("rate_date", "must be a date in the format YYYY-MM-DD")
+	}
+	v.Check(len(input.Rates) > 0, "rates", "must be provided")
+
+	rates := []*data.ExchangeRate{}
+	for currency, rate := range input.Rates {
+		exchangeRate := &data.ExchangeRate{
+			Currency: currency,
+			Rate:     rate,
+			RateDate: rateDate,
+			Source:   input.Source,
+		}
+		data.ValidateExchangeRate(v, "rates."+currency, exchangeRate)
+		rates = append(rates, exchangeRate)
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Rates.Insert(rates)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusCreated, envelope{"exchange_rates": rates}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The importExchangeRatesHandler() accepts a CSV body with a header row of
+// "currency,rate,rate_date,source" and one rate per line.
+func (app *application) importExchangeRatesHandler(w http.ResponseWriter, r *http.Request) {
+	maxBytes := 1_048_576
+	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
+
+	reader := csv.NewReader(r.Body)
+	reader.FieldsPerRecord = 4
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		switch {
+		case errors.Is(err, io.EOF):
+			app.badRequestResponse(w, r, errors.New("body must not be empty"))
+		default:
+			app.badRequestResponse(w, r, fmt.Errorf("body contains badly-formed CSV: %w", err))
+		}
+		return
+	}
+	if strings.Join(header, ",") != "currency,rate,rate_date,source" {
+		app.badRequestResponse(w, r, errors.New("CSV header must be currency,rate,rate_date,source"))
+		return
+	}
+
+	v := validator.New()
+	rates := []*data.ExchangeRate{}
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			app.badRequestResponse(w, r, fmt.Errorf("body contains badly-formed CSV: %w", err))
+			return
+		}
+
+		key := fmt.Sprintf("line %d", line)
+		rate, err := strconv.ParseFloat(record[1], 64)
+		if err != nil {
+			v.AddError(key, "rate must be a decimal number")
+			continue
+		}
+		rateDate, err := time.Parse("2006-01-02", record[2])
+		if err != nil {
+			v.AddError(key, "rate date must be in the format YYYY-MM-DD")
+			continue
+		}
+		exchangeRate := &data.ExchangeRate{
+			Currency: record[0],
+			Rate:     rate,
+			RateDate: rateDate,
+			Source:   record[3],
+		}
+		data.ValidateExchangeRate(v, key, exchangeRate)
+		rates = append(rates, exchangeRate)
+	}
+	v.Check(len(rates) > 0 || !v.Valid(), "body", "must contain at least one rate")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Rates.Insert(rates)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusCreated, envelope{"exchange_rates": rates}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listExchangeRatesHandler(w http.ResponseWriter, r *http.Request) {
+	rates, err := app.models.Rates.GetLatest()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"base_currency": data.BaseCurrency, "exchange_rates": rates}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"github.com/julienschmidt/httprouter"
+	"greenlight.alexedwards.net/internal/data"
 	"greenlight.alexedwards.net/internal/validator" // New import
 	"io"
 	"net/http"
@@ -96,6 +97,17 @@ func (app *application) readCSV(qs url.Values, key string, defaultValue []string
 	}
 	return strings.Split(csv, ",")
 }
+
+// The readCurrency() helper reads the optional "currency" query string parameter used to
+// request converted prices, recording a validation error if the code isn't supported.
+func (app *application) readCurrency(qs url.Values, v *validator.Validator) string {
+	currency := strings.ToUpper(qs.Get("currency"))
+	if currency != "" {
+		v.Check(data.SupportedCurrency(currency), "currency", "must be a supported ISO 4217 currency code")
+	}
+	return currency
+}
+
 func (app *application) readInt(qs url.Values, key string, defaultValue int, v *validator.Validator) int {
 	s := qs.Get(key)
 	if s == "" {
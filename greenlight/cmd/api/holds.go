@@ -0,0 +1,221 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"greenlight.alexedwards.net/internal/data"
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+// readHoldDuration parses an optional duration such as "24h" from a request body,
+// falling back to the configured default.
+func (app *application) readHoldDuration(value *string, v *validator.Validator) time.Duration {
+	if value == nil {
+		return app.config.holds.duration
+	}
+	d, err := time.ParseDuration(*value)
+	if err != nil {
+		v.AddError("duration", "must be a duration such as 48h")
+		return 0
+	}
+	data.ValidateHoldDuration(v, d, app.config.holds.maxDuration)
+	return d
+}
+
+func (app *application) createHoldHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		WatchID  int64   `json:"watch_id"`
+		ClientID *int64  `json:"client_id"`
+		Client   string  `json:"client"`
+		Note     string  `json:"note"`
+		Duration *string `json:"duration"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	duration := app.readHoldDuration(input.Duration, v)
+	hold := &data.Hold{
+		WatchID:   input.WatchID,
+		UserID:    app.contextGetUser(r).ID,
+		ClientID:  input.ClientID,
+		Client:    input.Client,
+		Note:      input.Note,
+		ExpiresAt: time.Now().Add(duration),
+	}
+	if data.ValidateHold(v, hold); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Holds.Insert(hold)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("watch_id", "watch does not exist")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrUnknownClient):
+			v.AddError("client_id", "user does not exist")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrNoUnitsAvailable):
+			app.conflictResponse(w, r, "every unit of this watch is already on hold or out of stock")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/holds/%d", hold.ID))
+	err = app.writeJSON(w, http.StatusCreated, envelope{"hold": hold}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// getHold fetches the hold named in the URL, writing the error response and returning
+// nil if it can't be found.
+func (app *application) getHold(w http.ResponseWriter, r *http.Request) *data.Hold {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil
+	}
+	hold, err := app.models.Holds.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil
+	}
+	return hold
+}
+
+func (app *application) showHoldHandler(w http.ResponseWriter, r *http.Request) {
+	hold := app.getHold(w, r)
+	if hold == nil {
+		return
+	}
+	err := app.writeJSON(w, http.StatusOK, envelope{"hold": hold}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The extendHoldHandler() sets a new expiry, measured from now, on an active hold.
+func (app *application) extendHoldHandler(w http.ResponseWriter, r *http.Request) {
+	hold := app.getHold(w, r)
+	if hold == nil {
+		return
+	}
+
+	var input struct {
+		Duration *string `json:"duration"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	duration := app.readHoldDuration(input.Duration, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if hold.Status != data.HoldActive {
+		app.conflictResponse(w, r, fmt.Sprintf("the hold is %s and can no longer be extended", hold.Status))
+		return
+	}
+	hold.ExpiresAt = time.Now().Add(duration)
+
+	err = app.models.Holds.Update(hold)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"hold": hold}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) releaseHoldHandler(w http.ResponseWriter, r *http.Request) {
+	hold := app.getHold(w, r)
+	if hold == nil {
+		return
+	}
+	if hold.Status != data.HoldActive {
+		app.conflictResponse(w, r, fmt.Sprintf("the hold is already %s", hold.Status))
+		return
+	}
+
+	now := time.Now()
+	hold.Status = data.HoldReleased
+	hold.ReleasedAt = &now
+
+	err := app.models.Holds.Update(hold)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"hold": hold}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listHoldsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		WatchID  int
+		ClientID int
+		Status   string
+		data.Filters
+	}
+	v := validator.New()
+	qs := r.URL.Query()
+	input.WatchID = app.readInt(qs, "watch_id", 0, v)
+	input.ClientID = app.readInt(qs, "client_id", 0, v)
+	input.Status = app.readString(qs, "status", "")
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "expires_at")
+	input.Filters.SortSafelist = []string{"id", "created_at", "expires_at", "-id", "-created_at", "-expires_at"}
+	if input.Status != "" {
+		v.Check(validator.In(input.Status, data.HoldActive, data.HoldReleased, data.HoldExpired), "status", "must be active, released or expired")
+	}
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	holds, metadata, err := app.models.Holds.GetAll(int64(input.WatchID), int64(input.ClientID), input.Status, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"holds": holds, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
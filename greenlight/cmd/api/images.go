@@ -0,0 +1,392 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"greenlight.alexedwards.net/internal/data"
+	"greenlight.alexedwards.net/internal/images"
+	"greenlight.alexedwards.net/internal/storage"
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+// readImageIDParam reads the "image_id" URL parameter in the same way as readIDParam().
+func (app *application) readImageIDParam(r *http.Request) (int64, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+	id, err := strconv.ParseInt(params.ByName("image_id"), 10, 64)
+	if err != nil || id < 1 {
+		return 0, errors.New("invalid image_id parameter")
+	}
+	return id, nil
+}
+
+// The readImageUpload() helper streams the multipart body and returns the contents of
+// the "image" part, plus the value of the optional "primary" part. Uploads have their
+// own size limit rather than the 1MB limit used by readJSON().
+func (app *application) readImageUpload(w http.ResponseWriter, r *http.Request) ([]byte, bool, error) {
+	maxBytes := app.config.images.maxBytes
+	// Allow some room on top of the image itself for the multipart boundaries and
+	// headers.
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes+64*1024)
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, false, errors.New("body must be multipart/form-data")
+	}
+
+	var (
+		content []byte
+		primary bool
+	)
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			var maxBytesError *http.MaxBytesError
+			if errors.As(err, &maxBytesError) {
+				return nil, false, fmt.Errorf("image must not be larger than %d bytes", maxBytes)
+			}
+			return nil, false, errors.New("body contains badly-formed multipart data")
+		}
+
+		switch part.FormName() {
+		case "image":
+			content, err = io.ReadAll(io.LimitReader(part, maxBytes+1))
+			if err != nil {
+				return nil, false, errors.New("body contains badly-formed multipart data")
+			}
+			if int64(len(content)) > maxBytes {
+				return nil, false, fmt.Errorf("image must not be larger than %d bytes", maxBytes)
+			}
+		case "primary":
+			value, err := io.ReadAll(io.LimitReader(part, 16))
+			if err != nil {
+				return nil, false, errors.New("body contains badly-formed multipart data")
+			}
+			primary, err = strconv.ParseBool(string(value))
+			if err != nil {
+				return nil, false, errors.New("primary must be true or false")
+			}
+		}
+		part.Close()
+	}
+	if content == nil {
+		return nil, false, errors.New("body must contain an image part")
+	}
+	return content, primary, nil
+}
+
+func (app *application) uploadWatchImageHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	_, err = app.models.Watches.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	content, primary, err := app.readImageUpload(w, r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	contentType, err := images.Sniff(content)
+	if err != nil {
+		v.AddError("image", err.Error())
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	stripped, err := images.StripMetadata(content, contentType)
+	if err != nil {
+		v.AddError("image", err.Error())
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	decoded, err := images.Decode(stripped)
+	if err != nil {
+		v.AddError("image", err.Error())
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	// Stripping removed the EXIF orientation, so turn the pixels upright instead and
+	// store the re-encoded image as the original.
+	if orientation := images.Orientation(content, contentType); orientation != 1 {
+		decoded = images.Orient(decoded, orientation)
+		var buf bytes.Buffer
+		err = images.Encode(&buf, decoded, contentType)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		stripped = buf.Bytes()
+	}
+
+	prefix, err := newImageKeyPrefix(id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	img := &data.WatchImage{
+		WatchID:     id,
+		KeyPrefix:   prefix,
+		ContentType: contentType,
+		Width:       decoded.Bounds().Dx(),
+		Height:      decoded.Bounds().Dy(),
+	}
+
+	err = app.storeImageFiles(img, stripped, decoded)
+	if err != nil {
+		app.deleteImageFiles(img)
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Images.Insert(img)
+	if err == nil && primary && !img.Primary {
+		err = app.models.Images.SetPrimary(id, img.ID)
+		img.Primary = err == nil
+	}
+	if err != nil {
+		app.deleteImageFiles(img)
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.setImageURLs(img)
+	headers := make(http.Header)
+	headers.Set("Location", img.URL)
+	err = app.writeJSON(w, http.StatusCreated, envelope{"image": img}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listWatchImagesHandler(w http.ResponseWriter, r *http.Request) {
+	watch := app.getVisibleWatch(w, r)
+	if watch == nil {
+		return
+	}
+	err := app.attachImages(watch)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if watch.Images == nil {
+		watch.Images = []*data.WatchImage{}
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"images": watch.Images}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateWatchImageHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	imageID, err := app.readImageIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Position *int  `json:"position"`
+		Primary  *bool `json:"primary"`
+	}
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	v.Check(input.Position == nil || *input.Position >= 1, "position", "must be greater than zero")
+	v.Check(input.Primary == nil || *input.Primary, "primary", "can only be set to true; make another image primary instead")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if input.Position != nil {
+		err = app.models.Images.Move(id, imageID, *input.Position)
+	}
+	if err == nil && input.Primary != nil {
+		err = app.models.Images.SetPrimary(id, imageID)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	img, err := app.models.Images.Get(id, imageID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.setImageURLs(img)
+	err = app.writeJSON(w, http.StatusOK, envelope{"image": img}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteWatchImageHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	imageID, err := app.readImageIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	img, err := app.models.Images.Delete(id, imageID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.deleteImageFiles(img)
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "image successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The serveStaticHandler() serves stored files. Keys contain a random component and are
+// never overwritten, so responses can be cached indefinitely.
+func (app *application) serveStaticHandler(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	key := strings.TrimPrefix(params.ByName("filepath"), "/")
+
+	file, err := app.storage.Open(key)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNotFound), errors.Is(err, storage.ErrInvalidKey):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	http.ServeContent(w, r, key, time.Time{}, file)
+}
+
+// The attachImages() helper loads the images for the given watches and fills in their
+// public URLs.
+func (app *application) attachImages(watches ...*data.Watches) error {
+	ids := make([]int64, 0, len(watches))
+	for _, watch := range watches {
+		ids = append(ids, watch.ID)
+	}
+	imagesByWatch, err := app.models.Images.GetAllForWatches(ids...)
+	if err != nil {
+		return err
+	}
+	for _, watch := range watches {
+		watch.Images = imagesByWatch[watch.ID]
+		for _, img := range watch.Images {
+			app.setImageURLs(img)
+		}
+	}
+	return nil
+}
+
+func (app *application) setImageURLs(img *data.WatchImage) {
+	img.URL = app.storage.URL(img.OriginalKey())
+	img.Thumbnails = make(map[string]string, len(images.ThumbnailSizes))
+	for size := range images.ThumbnailSizes {
+		img.Thumbnails[size] = app.storage.URL(img.ThumbnailKey(size))
+	}
+}
+
+// storeImageFiles writes the metadata-stripped original and a thumbnail for each of
+// the configured sizes.
+func (app *application) storeImageFiles(img *data.WatchImage, original []byte, decoded image.Image) error {
+	err := app.storage.Put(img.OriginalKey(), bytes.NewReader(original))
+	if err != nil {
+		return err
+	}
+	for size, maxSide := range images.ThumbnailSizes {
+		var buf bytes.Buffer
+		err = images.EncodeJPEG(&buf, images.Thumbnail(decoded, maxSide))
+		if err != nil {
+			return err
+		}
+		err = app.storage.Put(img.ThumbnailKey(size), &buf)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (app *application) deleteImageFiles(img *data.WatchImage) {
+	keys := []string{img.OriginalKey()}
+	for size := range images.ThumbnailSizes {
+		keys = append(keys, img.ThumbnailKey(size))
+	}
+	for _, key := range keys {
+		err := app.storage.Delete(key)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"key": key})
+		}
+	}
+}
+
+// newImageKeyPrefix returns a unique storage prefix for a new image of a watch.
+func newImageKeyPrefix(watchID int64) (string, error) {
+	randomBytes := make([]byte, 16)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("watches/%d/%s", watchID, hex.EncodeToString(randomBytes)), nil
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"greenlight.alexedwards.net/internal/data"
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+func (app *application) createLocationHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name    string `json:"name"`
+		Address string `json:"address"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	location := &data.Location{Name: input.Name, Address: input.Address}
+	v := validator.New()
+	if data.ValidateLocation(v, location); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Locations.Insert(location)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateLocation):
+			v.AddError("name", "a location with this name already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/locations/%d", location.ID))
+	err = app.writeJSON(w, http.StatusCreated, envelope{"location": location}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showLocationHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	location, err := app.models.Locations.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"location": location}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateLocationHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	location, err := app.models.Locations.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		Name    *string `json:"name"`
+		Address *string `json:"address"`
+	}
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if input.Name != nil {
+		location.Name = *input.Name
+	}
+	if input.Address != nil {
+		location.Address = *input.Address
+	}
+
+	v := validator.New()
+	if data.ValidateLocation(v, location); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.Locations.Update(location)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateLocation):
+			v.AddError("name", "a location with this name already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"location": location}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listLocationsHandler(w http.ResponseWriter, r *http.Request) {
+	locations, err := app.models.Locations.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"locations": locations}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showLocationStockHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	_, err = app.models.Locations.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	levels, err := app.models.Inventory.GetLevels(0, id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"stock": levels}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showWatchStockHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	_, err = app.models.Watches.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	levels, err := app.models.Inventory.GetLevels(id, 0)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	total := 0
+	for _, level := range levels {
+		total += level.Quantity
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"stock": levels, "total_quantity": total}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createStockMovementHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	_, err = app.models.Watches.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		Type           string `json:"type"`
+		FromLocationID *int64 `json:"from_location_id"`
+		ToLocationID   *int64 `json:"to_location_id"`
+		Quantity       int    `json:"quantity"`
+		Reason         string `json:"reason"`
+	}
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	movement := &data.StockMovement{
+		WatchID:        id,
+		Type:           input.Type,
+		FromLocationID: input.FromLocationID,
+		ToLocationID:   input.ToLocationID,
+		Quantity:       input.Quantity,
+		Reason:         input.Reason,
+		UserID:         app.contextGetUser(r).ID,
+	}
+	v := validator.New()
+	if data.ValidateStockMovement(v, movement); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Read the current levels at the affected locations and work out the new
+	// quantities. ApplyMovement() only saves them if nobody else has changed the
+	// same levels in the meantime.
+	var levels []*data.StockLevel
+	if movement.FromLocationID != nil {
+		from, err := app.models.Inventory.GetLevel(id, *movement.FromLocationID)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				v.AddError("from_location_id", "location does not exist")
+			default:
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+		} else {
+			from.Quantity -= movement.Quantity
+			v.Check(from.Quantity >= 0, "quantity", "must not be more than the "+strconv.Itoa(from.Quantity+movement.Quantity)+" units held at the source location")
+			levels = append(levels, from)
+		}
+	}
+	if movement.ToLocationID != nil {
+		to, err := app.models.Inventory.GetLevel(id, *movement.ToLocationID)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				v.AddError("to_location_id", "location does not exist")
+			default:
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+		} else {
+			to.Quantity += movement.Quantity
+			levels = append(levels, to)
+		}
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Inventory.ApplyMovement(movement, levels...)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrStockReserved):
+			app.conflictResponse(w, r, "the movement would leave fewer units in stock than are reserved by holds, orders and auctions")
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"movement": movement, "stock": levels}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listStockMovementsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		LocationID int
+		data.Filters
+	}
+	v := validator.New()
+	qs := r.URL.Query()
+	input.LocationID = app.readInt(qs, "location_id", 0, v)
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-created_at")
+	input.Filters.SortSafelist = []string{"id", "created_at", "-id", "-created_at"}
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	_, err = app.models.Watches.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	movements, metadata, err := app.models.Inventory.GetMovements(id, int64(input.LocationID), input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"movements": movements, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// The every() helper runs fn on a fixed interval in a background goroutine, until the
+// shutdown channel is closed. It is started with app.background(), so a graceful
+// shutdown waits for any run that is in progress. A panic in fn is logged and doesn't
+// stop later runs.
+func (app *application) every(name string, interval time.Duration, fn func()) {
+	app.background(func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-app.shutdown:
+				return
+			case <-ticker.C:
+				func() {
+					defer func() {
+						if err := recover(); err != nil {
+							app.logger.PrintError(fmt.Errorf("%s", err), map[string]string{"job": name})
+						}
+					}()
+					fn()
+				}()
+			}
+		}
+	})
+}
+
+// The startJobs() method launches the periodic background jobs.
+func (app *application) startJobs() {
+	app.every("expire holds", app.config.holds.sweepInterval, app.expireHolds)
+	app.every("close auctions", app.config.auctions.closeInterval, app.closeAuctions)
+	app.every("deliver alerts", app.config.alerts.interval, app.deliverAlerts)
+	app.every("saved search digests", app.config.searches.digestInterval, app.sendSearchDigests)
+	app.every("publish scheduled watches", app.config.publishing.interval, app.publishScheduledWatches)
+	app.every("service reminders", app.config.service.reminderInterval, app.sendServiceReminders)
+	app.every("expire orders", app.config.orders.expiryInterval, app.expireOrders)
+}
+
+func (app *application) expireHolds() {
+	n, err := app.models.Holds.ExpireDue()
+	if err != nil {
+		app.logger.PrintError(err, map[string]string{"job": "expire holds"})
+		return
+	}
+	if n > 0 {
+		app.logger.PrintInfo("expired holds released", map[string]string{"count": fmt.Sprint(n)})
+	}
+}
+
+func (app *application) expireOrders() {
+	n, err := app.models.Orders.ExpirePending()
+	if err != nil {
+		app.logger.PrintError(err, map[string]string{"job": "expire orders"})
+		return
+	}
+	if n > 0 {
+		app.logger.PrintInfo("expired orders cancelled", map[string]string{"count": fmt.Sprint(n)})
+	}
+}
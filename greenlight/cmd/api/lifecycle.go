@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"greenlight.alexedwards.net/internal/data"
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+// hasPermission reports whether the user making the request has the permission, for
+// handlers whose behaviour depends on it rather than being refused outright.
+func (app *application) hasPermission(r *http.Request, code string) (bool, error) {
+	permissions, err := app.models.Permissions.GetAllForUser(app.contextGetUser(r).ID)
+	if err != nil {
+		return false, err
+	}
+	return permissions.Include(code), nil
+}
+
+// canEditWatches reports whether the user may edit watches, and so may also see the
+// watches that aren't published.
+func (app *application) canEditWatches(r *http.Request) (bool, error) {
+	return app.hasPermission(r, "watches:write")
+}
+
+// canSeeWatch reports whether the user may see the given watch: published watches
+// are visible to everyone, the others only to editors.
+func (app *application) canSeeWatch(r *http.Request, watch *data.Watches) (bool, error) {
+	if watch.Status == data.WatchPublished {
+		return true, nil
+	}
+	return app.canEditWatches(r)
+}
+
+// findVisibleWatch fetches a watch for the user making the request. A watch they may
+// not see is reported as data.ErrRecordNotFound, the same as one that doesn't exist.
+func (app *application) findVisibleWatch(r *http.Request, id int64) (*data.Watches, error) {
+	watch, err := app.models.Watches.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	visible, err := app.canSeeWatch(r, watch)
+	if err != nil {
+		return nil, err
+	}
+	if !visible {
+		return nil, data.ErrRecordNotFound
+	}
+	return watch, nil
+}
+
+// getVisibleWatch fetches the watch named in the URL, writing the error response and
+// returning nil if it doesn't exist or the user may not see it.
+func (app *application) getVisibleWatch(w http.ResponseWriter, r *http.Request) *data.Watches {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil
+	}
+	watch, err := app.findVisibleWatch(r, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil
+	}
+	return watch
+}
+
+// The publishWatchHandler() publishes a watch straight away, or schedules it when the
+// body has a "publish_at" time in the future. The body may be left out.
+func (app *application) publishWatchHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		PublishAt *time.Time `json:"publish_at"`
+	}
+	if r.ContentLength != 0 {
+		err := app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+	}
+
+	app.changeWatchStatus(w, r, func(watch *data.Watches, v *validator.Validator) {
+		v.Check(watch.Status != data.WatchPublished, "status", "watch is already published")
+		var at time.Time
+		if input.PublishAt != nil {
+			at = *input.PublishAt
+		}
+		watch.Publish(at)
+	})
+}
+
+// The unpublishWatchHandler() takes a published or scheduled watch back to a draft.
+func (app *application) unpublishWatchHandler(w http.ResponseWriter, r *http.Request) {
+	app.changeWatchStatus(w, r, func(watch *data.Watches, v *validator.Validator) {
+		v.Check(watch.Status == data.WatchPublished || watch.Status == data.WatchScheduled, "status", "watch is not published or scheduled")
+		watch.Unpublish(data.WatchDraft)
+	})
+}
+
+// The archiveWatchHandler() retires a watch from the storefront. An archived watch can
+// be published again.
+func (app *application) archiveWatchHandler(w http.ResponseWriter, r *http.Request) {
+	app.changeWatchStatus(w, r, func(watch *data.Watches, v *validator.Validator) {
+		v.Check(watch.Status != data.WatchArchived, "status", "watch is already archived")
+		watch.Unpublish(data.WatchArchived)
+	})
+}
+
+// changeWatchStatus loads the watch named in the URL, applies change to it and saves
+// it with the usual version check. The change reports transitions that aren't allowed
+// through v.
+func (app *application) changeWatchStatus(w http.ResponseWriter, r *http.Request, change func(*data.Watches, *validator.Validator)) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	watch, err := app.models.Watches.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	v := validator.New()
+	change(watch, v)
+	if data.ValidateWatchStatus(v, watch); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Watches.Update(watch, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.attachWatchDetails(watch)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"watches": watch}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) publishScheduledWatches() {
+	n, err := app.models.Watches.PublishDue()
+	if err != nil {
+		app.logger.PrintError(err, map[string]string{"job": "publish scheduled watches"})
+		return
+	}
+	if n > 0 {
+		app.logger.PrintInfo("scheduled watches published", map[string]string{"count": fmt.Sprint(n)})
+	}
+}
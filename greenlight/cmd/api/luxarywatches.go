@@ -6,15 +6,22 @@ import (
 	"greenlight.alexedwards.net/internal/data"
 	"greenlight.alexedwards.net/internal/validator"
 	"net/http"
+	"net/url"
+	"time"
 )
 
 func (app *application) createWatchesHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Title    string   `json:"title"`
-		Year     int32    `json:"year"`
-		Price    float64  `json:"price"`
-		Brand    []string `json:"brand"`
-		Material []string `json:"material"`
+		Title    string     `json:"title"`
+		Year     int32      `json:"year"`
+		Price    data.Money `json:"price"`
+		Brand    []string   `json:"brand"`
+		Material []string   `json:"material"`
+		Specs    data.Specs `json:"specs"`
+		// New watches are drafts unless they are published or scheduled
+		// straight away.
+		Status    string     `json:"status"`
+		PublishAt *time.Time `json:"publish_at"`
 	}
 	err := app.readJSON(w, r, &input)
 	if err != nil {
@@ -27,12 +34,41 @@ func (app *application) createWatchesHandler(w http.ResponseWriter, r *http.Requ
 		Price:    input.Price,
 		Brand:    input.Brand,
 		Material: input.Material,
+		Specs:    input.Specs,
+		Status:   input.Status,
 	}
 	v := validator.New()
+	switch input.Status {
+	case "":
+		watch.Status = data.WatchDraft
+	case data.WatchPublished:
+		watch.Publish(time.Time{})
+	case data.WatchScheduled:
+		if input.PublishAt != nil {
+			v.Check(input.PublishAt.After(time.Now()), "publish_at", "must be in the future")
+			watch.Publish(*input.PublishAt)
+		}
+	}
+	v.Check(watch.Status != data.WatchArchived, "status", "must not be archived")
+	data.ValidateWatchStatus(v, watch)
 	if data.ValidateWatches(v, watch); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
+	err = app.resolveWatchEntities(watch, v)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.validateWatchSpecs(watch, v)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
 
 	err = app.models.Watches.Insert(watch)
 	if err != nil {
@@ -45,8 +81,6 @@ func (app *application) createWatchesHandler(w http.ResponseWriter, r *http.Requ
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
-
-	fmt.Fprintf(w, "%+v\n", input)
 }
 
 func (app *application) showWatchesHandler(w http.ResponseWriter, r *http.Request) {
@@ -56,6 +90,13 @@ func (app *application) showWatchesHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	v := validator.New()
+	currency := app.readCurrency(r.URL.Query(), v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
 	watch, err := app.models.Watches.Get(id)
 	if err != nil {
 		switch {
@@ -66,12 +107,82 @@ func (app *application) showWatchesHandler(w http.ResponseWriter, r *http.Reques
 		}
 		return
 	}
-	err = app.writeJSON(w, http.StatusOK, envelope{"watches": watch}, nil)
+	visible, err := app.canSeeWatch(r, watch)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !visible {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.attachWatchDetails(watch)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{"watches": watch}
+	if currency != "" {
+		rates, err := app.models.Rates.GetLatest()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		env["metadata"] = envelope{"conversion": rates.ConvertWatches(currency, watch)}
+	}
+	err = app.writeJSON(w, http.StatusOK, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
+// watchPatch is the body of a watch update; fields that are left out are unchanged.
+// It is also what a change request stores, to be applied once it is approved.
+type watchPatch struct {
+	Title    *string     `json:"title,omitempty"`
+	Year     *int32      `json:"year,omitempty"`
+	Price    *data.Money `json:"price,omitempty"`
+	Brand    *[]string   `json:"brand,omitempty"`
+	Material *[]string   `json:"material,omitempty"`
+	Specs    *data.Specs `json:"specs,omitempty"`
+}
+
+func (p watchPatch) apply(watch *data.Watches) {
+	if p.Title != nil {
+		watch.Title = *p.Title
+	}
+	if p.Year != nil {
+		watch.Year = *p.Year
+	}
+	if p.Price != nil {
+		watch.Price = *p.Price
+	}
+	if p.Brand != nil {
+		watch.Brand = *p.Brand
+	}
+	if p.Material != nil {
+		watch.Material = *p.Material
+	}
+	if p.Specs != nil {
+		watch.Specs = *p.Specs
+	}
+}
+
+// validateWatchUpdate checks an edited watch and resolves its brands and materials.
+// The problems are reported through v.
+func (app *application) validateWatchUpdate(watch *data.Watches, v *validator.Validator) error {
+	if data.ValidateWatches(v, watch); !v.Valid() {
+		return nil
+	}
+	err := app.resolveWatchEntities(watch, v)
+	if err != nil {
+		return err
+	}
+	return app.validateWatchSpecs(watch, v)
+}
+
 func (app *application) updateWatchesHandler(w http.ResponseWriter, r *http.Request) {
 	id, err := app.readIDParam(r)
 	if err != nil {
@@ -88,40 +199,33 @@ func (app *application) updateWatchesHandler(w http.ResponseWriter, r *http.Requ
 		}
 		return
 	}
-	var input struct {
-		Title    *string   `json:"title"`
-		Year     *int32    `json:"year"`
-		Price    *float64  `json:"price"`
-		Brand    *[]string `json:"brand"`
-		Material *[]string `json:"material"`
-	}
+	var input watchPatch
 	err = app.readJSON(w, r, &input)
 	if err != nil {
 		app.badRequestResponse(w, r, err)
 		return
 	}
-	if input.Title != nil {
-		watch.Title = *input.Title
-	}
-	if input.Year != nil {
-		watch.Year = *input.Year
-	}
-	if input.Price != nil {
-		watch.Price = *input.Price
-	}
-	if input.Brand != nil {
-		watch.Brand = *input.Brand
-	}
-	if input.Material != nil {
-		watch.Material = *input.Material
-	}
+	oldPrice := watch.Price
+	input.apply(watch)
 
 	v := validator.New()
-	if data.ValidateWatches(v, watch); !v.Valid() {
+	err = app.validateWatchUpdate(watch, v)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
-	err = app.models.Watches.Update(watch)
+
+	// Large price changes have to be approved by someone else.
+	if data.PriceChangeExceeds(oldPrice, watch.Price, app.config.approval.priceThreshold) {
+		app.requestApproval(w, r, watch, data.ChangeUpdate, input)
+		return
+	}
+
+	err = app.models.Watches.Update(watch, app.contextGetUser(r).ID)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
@@ -132,6 +236,12 @@ func (app *application) updateWatchesHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	err = app.attachWatchDetails(watch)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
 	err = app.writeJSON(w, http.StatusOK, envelope{"watches": watch}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -144,7 +254,7 @@ func (app *application) deleteWatchesHandler(w http.ResponseWriter, r *http.Requ
 		app.notFoundResponse(w, r)
 		return
 	}
-	err = app.models.Watches.Delete(id)
+	watch, err := app.models.Watches.Get(id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -154,34 +264,153 @@ func (app *application) deleteWatchesHandler(w http.ResponseWriter, r *http.Requ
 		}
 		return
 	}
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "watches successfully deleted"}, nil)
+	// Deleting a watch always needs approval by someone else.
+	app.requestApproval(w, r, watch, data.ChangeDelete, nil)
+}
+
+// deleteWatch deletes a watch with remove and then, in the background, its image files.
+func (app *application) deleteWatch(id int64, remove func() error) error {
+	// The image records are removed along with the watch, so look them up first in
+	// order to delete the stored files afterwards.
+	imagesByWatch, err := app.models.Images.GetAllForWatches(id)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		return err
 	}
+	err = remove()
+	if err != nil {
+		return err
+	}
+	app.background(func() {
+		for _, img := range imagesByWatch[id] {
+			app.deleteImageFiles(img)
+		}
+	})
+	return nil
 }
 
-func (app *application) listWatchesHandler(w http.ResponseWriter, r *http.Request) {
-	var input struct {
-		Brand string
-		data.Filters
+// readWatchesFilter reads the search parameters of the watch list, which the
+// analytics endpoints accept too. Only published watches are included; the watch list
+// lets editors ask for other states.
+func (app *application) readWatchesFilter(qs url.Values, v *validator.Validator) (data.WatchesQuery, error) {
+	query := data.WatchesQuery{Statuses: []string{data.WatchPublished}}
+	query.Title = app.readString(qs, "title", "")
+	query.Brand = app.readString(qs, "brand", "")
+	query.Material = app.readString(qs, "material", "")
+	query.Category = app.readString(qs, "category", "")
+	// "tags=a,b" matches watches with all of the tags, or with any of them when
+	// "tags_match=any".
+	seen := make(map[string]bool)
+	for _, tag := range app.readCSV(qs, "tags", []string{}) {
+		slug := data.TagSlug(tag)
+		if slug != "" && !seen[slug] {
+			seen[slug] = true
+			query.Tags = append(query.Tags, slug)
+		}
 	}
+	tagsMatch := app.readString(qs, "tags_match", "all")
+	v.Check(validator.In(tagsMatch, "all", "any"), "tags_match", "must be all or any")
+	query.AllTags = tagsMatch == "all"
+	specFilters, err := app.readSpecFilters(qs, v)
+	if err != nil {
+		return query, err
+	}
+	query.Specs = specFilters
+
+	if since := app.readString(qs, "price_changed_since", ""); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			t, err = time.Parse("2006-01-02", since)
+		}
+		v.Check(err == nil, "price_changed_since", "must be a date (2006-01-02) or an RFC 3339 time")
+		query.PriceChangedSince = t
+	}
+	query.PriceChange = app.readString(qs, "price_change", "")
+	if query.PriceChange != "" {
+		v.Check(validator.In(query.PriceChange, "down", "up"), "price_change", "must be down or up")
+		v.Check(qs.Has("price_changed_since"), "price_change", "can only be used with price_changed_since")
+	}
+	return query, nil
+}
+
+// readWatchesQuery reads the search, paging and sorting parameters of the watch list.
+// Saved searches store the same query string and are read back with it too.
+func (app *application) readWatchesQuery(qs url.Values, v *validator.Validator) (data.WatchesQuery, data.Filters, error) {
+	query, err := app.readWatchesFilter(qs, v)
+	if err != nil {
+		return query, data.Filters{}, err
+	}
+
+	filters := data.Filters{
+		Page:         app.readInt(qs, "page", 1, v),
+		PageSize:     app.readInt(qs, "page_size", 20, v),
+		Sort:         app.readString(qs, "sort", "id"),
+		SortSafelist: []string{"id", "brand", "year", "price", "rating", "-id", "-brand", "-year", "-price", "-rating"},
+	}
+	data.ValidateFilters(v, filters)
+	return query, filters, nil
+}
+
+func (app *application) listWatchesHandler(w http.ResponseWriter, r *http.Request) {
 	v := validator.New()
 	qs := r.URL.Query()
-	input.Brand = app.readString(qs, "brand", "")
-	input.Filters.Page = app.readInt(qs, "page", 1, v)
-	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
-	input.Filters.Sort = app.readString(qs, "sort", "id")
-	input.Filters.SortSafelist = []string{"id", "Brand", "year", "price", "-id", "-brand", "-year", "-price"}
-	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+	currency := app.readCurrency(qs, v)
+	query, filters, err := app.readWatchesQuery(qs, v)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if qs.Has("status") {
+		query.Statuses = app.readCSV(qs, "status", nil)
+		for _, status := range query.Statuses {
+			v.Check(validator.In(status, data.WatchStatuses...), "status", "must be draft, scheduled, published or archived")
+		}
+	}
+	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
-	watches, metadata, err := app.models.Watches.GetAll(input.Brand, input.Filters)
+	for _, status := range query.Statuses {
+		if status != data.WatchPublished {
+			canEdit, err := app.canEditWatches(r)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+			if !canEdit {
+				app.notPermittedResponse(w, r)
+				return
+			}
+			break
+		}
+	}
+	watches, metadata, err := app.models.Watches.GetAll(query, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.attachWatchDetails(watches...)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
-	err = app.writeJSON(w, http.StatusOK, envelope{"watches": watches, "metadata": metadata}, nil)
+
+	// When prices are converted, the exchange rates used are reported alongside the
+	// usual pagination metadata.
+	var conversion *data.Conversion
+	if currency != "" {
+		rates, err := app.models.Rates.GetLatest()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		conversion = rates.ConvertWatches(currency, watches...)
+	}
+	responseMetadata := struct {
+		data.Metadata
+		Conversion *data.Conversion `json:"conversion,omitempty"`
+	}{metadata, conversion}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"watches": watches, "metadata": responseMetadata}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -95,6 +95,63 @@ func (app *application) rateLimit(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// limitReports rate limits stolen and lost reports per user, separately from the
+// global per-IP limiter, so that one account can't flood the review queue. It must run
+// after the user has been authenticated.
+func (app *application) limitReports(next http.HandlerFunc) http.HandlerFunc {
+	type reporter struct {
+		limiter  *rate.Limiter
+		lastSeen time.Time
+	}
+
+	var (
+		mu        sync.Mutex
+		reporters = make(map[int64]*reporter)
+	)
+
+	// Forget users once they have been idle for long enough that their limiter would
+	// be full again anyway.
+	idle := time.Duration(float64(app.config.registry.reportsBurst) / app.config.registry.reportsPerHour * float64(time.Hour))
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+
+			mu.Lock()
+			for id, reporter := range reporters {
+				if time.Since(reporter.lastSeen) > idle {
+					delete(reporters, id)
+				}
+			}
+			mu.Unlock()
+		}
+	}()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.config.registry.limiterEnabled {
+			id := app.contextGetUser(r).ID
+
+			mu.Lock()
+			if _, found := reporters[id]; !found {
+				reporters[id] = &reporter{
+					limiter: rate.NewLimiter(rate.Limit(app.config.registry.reportsPerHour/3600), app.config.registry.reportsBurst),
+				}
+			}
+			reporters[id].lastSeen = time.Now()
+
+			if !reporters[id].limiter.Allow() {
+				mu.Unlock()
+				app.rateLimitExceededResponse(w, r)
+				return
+			}
+
+			mu.Unlock()
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (app *application) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Add the "Vary: Authorization" header to the response. This indicates to any
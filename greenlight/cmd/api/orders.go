@@ -0,0 +1,370 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"greenlight.alexedwards.net/internal/data"
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+func (app *application) showCartHandler(w http.ResponseWriter, r *http.Request) {
+	watches, err := app.models.Cart.GetAll(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"cart": watches}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) addCartItemHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		WatchID int64 `json:"watch_id"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	if v.Check(input.WatchID > 0, "watch_id", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	_, err = app.findVisibleWatch(r, input.WatchID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("watch_id", "watch does not exist")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user := app.contextGetUser(r)
+	err = app.models.Cart.Add(user.ID, input.WatchID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("watch_id", "watch does not exist")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	watches, err := app.models.Cart.GetAll(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"cart": watches}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) removeCartItemHandler(w http.ResponseWriter, r *http.Request) {
+	watchID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	err = app.models.Cart.Remove(app.contextGetUser(r).ID, watchID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "watch successfully removed from cart"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The checkoutHandler() places an order for everything in the user's cart.
+func (app *application) checkoutHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	order := app.newOrder(user)
+
+	err := app.models.Orders.InsertFromCart(order, app.config.orders.maxPending)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEmptyCart):
+			app.conflictResponse(w, r, "your cart is empty")
+		default:
+			app.placeOrderErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.orderPlaced(w, r, user, order)
+}
+
+// The createOrderHandler() places an order directly for a list of watch ids, without
+// going through the cart.
+func (app *application) createOrderHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		WatchIDs []int64 `json:"watch_ids"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	if data.ValidateOrderWatchIDs(v, input.WatchIDs); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+	order := app.newOrder(user)
+	err = app.models.Orders.Insert(order, input.WatchIDs, app.config.orders.maxPending)
+	if err != nil {
+		app.placeOrderErrorResponse(w, r, err)
+		return
+	}
+	app.orderPlaced(w, r, user, order)
+}
+
+// newOrder returns a new order for the user, which reserves its watches for the
+// configured time to pay.
+func (app *application) newOrder(user *data.User) *data.Order {
+	expiresAt := time.Now().Add(app.config.orders.pendingDuration)
+	return &data.Order{UserID: user.ID, ExpiresAt: &expiresAt}
+}
+
+// placeOrderErrorResponse reports why an order couldn't be placed. The errors about a
+// single watch name the watch they refer to.
+func (app *application) placeOrderErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	v := validator.New()
+	switch {
+	case errors.Is(err, data.ErrRecordNotFound):
+		v.AddError("watch_ids", err.Error())
+		app.failedValidationResponse(w, r, v.Errors)
+	case errors.Is(err, data.ErrNoUnitsAvailable):
+		app.conflictResponse(w, r, err.Error())
+	case errors.Is(err, data.ErrMixedCurrencies):
+		v.AddError("watch_ids", "must all be priced in the same currency")
+		app.failedValidationResponse(w, r, v.Errors)
+	case errors.Is(err, data.ErrTooManyPending):
+		app.conflictResponse(w, r, fmt.Sprintf("you already have %d orders awaiting payment; pay for or cancel one of them first", app.config.orders.maxPending))
+	default:
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// orderPlaced sends the confirmation email in the background and responds with the
+// new order.
+func (app *application) orderPlaced(w http.ResponseWriter, r *http.Request, user *data.User, order *data.Order) {
+	app.background(func() {
+		data := map[string]interface{}{
+			"name":  user.Name,
+			"order": order,
+		}
+		err := app.mailer.Send(user.Email, "order_confirmation.tmpl", data)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/users/me/orders/%d", order.ID))
+	err := app.writeJSON(w, http.StatusCreated, envelope{"order": order}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// getOrder fetches the order named in the URL, writing the error response and
+// returning nil if it can't be found. When own is true, orders placed by other users
+// are reported as not found.
+func (app *application) getOrder(w http.ResponseWriter, r *http.Request, own bool) *data.Order {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil
+	}
+	order, err := app.models.Orders.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil
+	}
+	if own && order.UserID != app.contextGetUser(r).ID {
+		app.notFoundResponse(w, r)
+		return nil
+	}
+	return order
+}
+
+// readOrderFilters reads the paging, sorting and status parameters for an orders list.
+func (app *application) readOrderFilters(r *http.Request, v *validator.Validator) (string, data.Filters) {
+	qs := r.URL.Query()
+	status := app.readString(qs, "status", "")
+	filters := data.Filters{
+		Page:         app.readInt(qs, "page", 1, v),
+		PageSize:     app.readInt(qs, "page_size", 20, v),
+		Sort:         app.readString(qs, "sort", "-created_at"),
+		SortSafelist: []string{"id", "created_at", "-id", "-created_at"},
+	}
+	if status != "" {
+		v.Check(validator.In(status, data.OrderStatuses...), "status", "must be a valid order status")
+	}
+	data.ValidateFilters(v, filters)
+	return status, filters
+}
+
+func (app *application) listMyOrdersHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	status, filters := app.readOrderFilters(r, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	orders, metadata, err := app.models.Orders.GetAll(app.contextGetUser(r).ID, status, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"orders": orders, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showMyOrderHandler(w http.ResponseWriter, r *http.Request) {
+	order := app.getOrder(w, r, true)
+	if order == nil {
+		return
+	}
+	app.writeOrderWithEvents(w, r, order)
+}
+
+// The cancelMyOrderHandler() lets a customer cancel their own order while it is
+// still pending.
+func (app *application) cancelMyOrderHandler(w http.ResponseWriter, r *http.Request) {
+	order := app.getOrder(w, r, true)
+	if order == nil {
+		return
+	}
+	if order.Status != data.OrderPending {
+		app.conflictResponse(w, r, fmt.Sprintf("the order is %s and can no longer be cancelled", order.Status))
+		return
+	}
+	intents, err := app.models.Payments.GetAllForOrder(order.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	for _, intent := range intents {
+		if intent.Status != data.PaymentDeclined {
+			app.conflictResponse(w, r, "the order has a payment in progress and can no longer be cancelled")
+			return
+		}
+	}
+	event := &data.OrderEvent{ToStatus: data.OrderCancelled, UserID: app.contextGetUser(r).ID, Note: "cancelled by customer"}
+	app.transitionOrder(w, r, order, event)
+}
+
+func (app *application) listOrdersHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	userID := app.readInt(r.URL.Query(), "user_id", 0, v)
+	status, filters := app.readOrderFilters(r, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	orders, metadata, err := app.models.Orders.GetAll(int64(userID), status, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"orders": orders, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showOrderHandler(w http.ResponseWriter, r *http.Request) {
+	order := app.getOrder(w, r, false)
+	if order == nil {
+		return
+	}
+	app.writeOrderWithEvents(w, r, order)
+}
+
+// The updateOrderStatusHandler() lets staff move an order through its states, for
+// example marking it as shipped.
+func (app *application) updateOrderStatusHandler(w http.ResponseWriter, r *http.Request) {
+	order := app.getOrder(w, r, false)
+	if order == nil {
+		return
+	}
+
+	var input struct {
+		Status string `json:"status"`
+		Note   string `json:"note"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	event := &data.OrderEvent{ToStatus: input.Status, UserID: app.contextGetUser(r).ID, Note: input.Note}
+	v := validator.New()
+	if data.ValidateOrderEvent(v, event); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	app.transitionOrder(w, r, order, event)
+}
+
+func (app *application) transitionOrder(w http.ResponseWriter, r *http.Request, order *data.Order, event *data.OrderEvent) {
+	from := order.Status
+	err := app.models.Orders.Transition(order, event)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrInvalidTransition):
+			app.conflictResponse(w, r, fmt.Sprintf("an order can't move from %s to %s", from, event.ToStatus))
+		case errors.Is(err, data.ErrPaymentRequired):
+			app.conflictResponse(w, r, "orders are marked as paid or refunded by the payment provider")
+		case errors.Is(err, data.ErrNoStockToShip):
+			app.conflictResponse(w, r, "a watch in the order has no stock left to ship")
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.writeOrderWithEvents(w, r, order)
+}
+
+func (app *application) writeOrderWithEvents(w http.ResponseWriter, r *http.Request, order *data.Order) {
+	events, err := app.models.Orders.GetEvents(order.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"order": order, "events": events}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"greenlight.alexedwards.net/internal/data"
+	"greenlight.alexedwards.net/internal/payments"
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+// paymentEventStatuses maps the provider's webhook events to the state of the
+// payment intent that they confirm.
+var paymentEventStatuses = map[string]string{
+	payments.EventAuthorized: data.PaymentAuthorized,
+	payments.EventCaptured:   data.PaymentCaptured,
+	payments.EventDeclined:   data.PaymentDeclined,
+	payments.EventRefunded:   data.PaymentRefunded,
+}
+
+// The createPaymentHandler() starts paying for one of the user's own orders. The
+// client must send an Idempotency-Key header, and repeating a request with the same
+// key returns the original payment instead of charging again. If the provider couldn't
+// be reached the first time, the payment is left as created and repeating the request
+// asks the provider again; its own idempotency key stops that from charging twice. The
+// order itself is only marked as paid when the provider confirms the capture through
+// the webhook.
+func (app *application) createPaymentHandler(w http.ResponseWriter, r *http.Request) {
+	order := app.getOrder(w, r, true)
+	if order == nil {
+		return
+	}
+
+	var input struct {
+		PaymentMethod string `json:"payment_method"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	key := r.Header.Get("Idempotency-Key")
+	v := validator.New()
+	data.ValidateIdempotencyKey(v, key)
+	v.Check(input.PaymentMethod != "", "payment_method", "must be provided")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+	intent, done := app.writeExistingPayment(w, r, user.ID, order.ID, key)
+	if done {
+		return
+	}
+	if order.Status != data.OrderPending {
+		app.conflictResponse(w, r, fmt.Sprintf("the order is %s and can't be paid", order.Status))
+		return
+	}
+	if order.ExpiresAt != nil && !order.ExpiresAt.After(time.Now()) {
+		app.conflictResponse(w, r, "the order has expired and can't be paid")
+		return
+	}
+
+	if intent == nil {
+		intent = &data.PaymentIntent{
+			OrderID:        order.ID,
+			UserID:         user.ID,
+			IdempotencyKey: key,
+			Amount:         order.Total,
+			Provider:       app.payments.Name(),
+		}
+		err = app.models.Payments.Insert(intent)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrDuplicateIdempotencyKey):
+				// A concurrent request with the same key got there first. If it is
+				// still waiting for the provider, let it finish.
+				if _, done := app.writeExistingPayment(w, r, user.ID, order.ID, key); !done {
+					app.conflictResponse(w, r, "a request with this Idempotency-Key is already in progress")
+				}
+			case errors.Is(err, data.ErrPaymentInProgress):
+				app.conflictResponse(w, r, "the order already has a payment in progress; repeat its request with the same Idempotency-Key to retry it")
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+	}
+
+	charge, err := app.payments.Authorize(r.Context(), payments.AuthorizeRequest{
+		IdempotencyKey: fmt.Sprintf("payment-intent-%d", intent.ID),
+		Amount:         intent.Amount.Amount,
+		Currency:       intent.Amount.Currency,
+		PaymentMethod:  input.PaymentMethod,
+	})
+	if err != nil && !errors.Is(err, payments.ErrDeclined) {
+		// The intent stays created, so the client can retry with the same key.
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	intent.ProviderRef = charge.Reference
+	intent.Status = charge.Status
+	intent.FailureReason = charge.FailureReason
+
+	err = app.models.Payments.Update(intent)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if intent.Status == data.PaymentDeclined {
+		err = app.writeJSON(w, http.StatusPaymentRequired, envelope{"error": "the payment was declined", "payment": intent}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if intent.Status == data.PaymentAuthorized {
+		app.capturePayment(intent)
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"payment": intent}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// writeExistingPayment responds with the payment that the user already created with
+// an idempotency key, if there is one, and reports whether it did so. A payment that
+// is still created never got an answer from the provider, so it is returned instead
+// for the caller to authorize again.
+func (app *application) writeExistingPayment(w http.ResponseWriter, r *http.Request, userID, orderID int64, key string) (*data.PaymentIntent, bool) {
+	intent, err := app.models.Payments.GetByIdempotencyKey(userID, key)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return nil, false
+		}
+		app.serverErrorResponse(w, r, err)
+		return nil, true
+	}
+	if intent.OrderID != orderID {
+		v := validator.New()
+		v.AddError("idempotency_key", "has already been used for a different order")
+		app.failedValidationResponse(w, r, v.Errors)
+		return nil, true
+	}
+	if intent.Status == data.PaymentCreated {
+		return intent, false
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"payment": intent}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+	return nil, true
+}
+
+// capturePayment asks the provider to capture an authorized payment. The capture is
+// confirmed later through the webhook, so failures are only logged.
+func (app *application) capturePayment(intent *data.PaymentIntent) {
+	_, err := app.payments.Capture(context.Background(), intent.ProviderRef)
+	if err != nil {
+		app.logger.PrintError(err, map[string]string{"payment_intent": fmt.Sprint(intent.ID)})
+	}
+}
+
+// refundPayment asks the provider to refund a captured payment, in the same way as
+// capturePayment().
+func (app *application) refundPayment(intent *data.PaymentIntent) {
+	_, err := app.payments.Refund(context.Background(), intent.ProviderRef)
+	if err != nil {
+		app.logger.PrintError(err, map[string]string{"payment_intent": fmt.Sprint(intent.ID)})
+	}
+}
+
+// The paymentWebhookHandler() receives events from the payment provider. It doesn't
+// require authentication; instead the provider's signature is verified.
+func (app *application) paymentWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 65_536))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	event, err := app.payments.VerifyWebhook(r.Header, body)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	status, ok := paymentEventStatuses[event.Type]
+	if !ok {
+		// Acknowledge events we don't handle, so that the provider doesn't retry them.
+		err = app.writeJSON(w, http.StatusOK, envelope{"message": "event ignored"}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	intent, err := app.models.Payments.ApplyEvent(&data.PaymentEvent{
+		Provider:      app.payments.Name(),
+		EventID:       event.ID,
+		ProviderRef:   event.Reference,
+		Status:        status,
+		FailureReason: event.FailureReason,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicatePaymentEvent):
+			err = app.writeJSON(w, http.StatusOK, envelope{"message": "event already processed"}, nil)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+			}
+		case errors.Is(err, data.ErrStalePaymentEvent):
+			err = app.writeJSON(w, http.StatusOK, envelope{"message": "event ignored"}, nil)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+			}
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// The order may have been cancelled while the payment was in flight. An
+	// authorization for it isn't captured, and lapses at the provider, while money
+	// that has already been captured for it is refunded.
+	if intent.Status == data.PaymentAuthorized || intent.Status == data.PaymentCaptured {
+		order, err := app.models.Orders.Get(intent.OrderID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		switch {
+		case intent.Status == data.PaymentAuthorized && order.Status == data.OrderPending:
+			app.capturePayment(intent)
+		case intent.Status == data.PaymentCaptured && order.Status == data.OrderCancelled:
+			app.refundPayment(intent)
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "event processed"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listMyOrderPaymentsHandler(w http.ResponseWriter, r *http.Request) {
+	order := app.getOrder(w, r, true)
+	if order == nil {
+		return
+	}
+	app.writeOrderPayments(w, r, order)
+}
+
+func (app *application) listOrderPaymentsHandler(w http.ResponseWriter, r *http.Request) {
+	order := app.getOrder(w, r, false)
+	if order == nil {
+		return
+	}
+	app.writeOrderPayments(w, r, order)
+}
+
+func (app *application) writeOrderPayments(w http.ResponseWriter, r *http.Request, order *data.Order) {
+	intents, err := app.models.Payments.GetAllForOrder(order.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"payments": intents}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The refundOrderHandler() asks the provider to refund the captured payment for an
+// order. The order is marked as refunded once the provider confirms the refund, so the
+// order must be in a state that can move to refunded before the provider is asked. A
+// payment captured for a cancelled order is refunded automatically, but can also be
+// refunded here if that failed; the order stays cancelled.
+func (app *application) refundOrderHandler(w http.ResponseWriter, r *http.Request) {
+	order := app.getOrder(w, r, false)
+	if order == nil {
+		return
+	}
+	if order.Status != data.OrderCancelled && !data.CanTransition(order.Status, data.OrderRefunded) {
+		app.conflictResponse(w, r, fmt.Sprintf("the order is %s and can't be refunded", order.Status))
+		return
+	}
+	intents, err := app.models.Payments.GetAllForOrder(order.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	var captured *data.PaymentIntent
+	for _, intent := range intents {
+		if intent.Status == data.PaymentCaptured {
+			captured = intent
+		}
+	}
+	if captured == nil {
+		app.conflictResponse(w, r, "the order has no captured payment to refund")
+		return
+	}
+
+	_, err = app.payments.Refund(r.Context(), captured.ProviderRef)
+	if err != nil {
+		switch {
+		case errors.Is(err, payments.ErrInvalidState):
+			app.conflictResponse(w, r, "the payment can't be refunded in its current state")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"payment": captured, "message": "refund requested"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+
+	"greenlight.alexedwards.net/internal/data"
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+// The listPriceHistoryHandler() lists the price changes of a watch, oldest first so
+// that they can be charted directly, along with its current price.
+func (app *application) listPriceHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+	filters := data.Filters{
+		Page:         app.readInt(qs, "page", 1, v),
+		PageSize:     app.readInt(qs, "page_size", 100, v),
+		Sort:         app.readString(qs, "sort", "changed_at"),
+		SortSafelist: []string{"changed_at", "-changed_at"},
+	}
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	watch := app.getVisibleWatch(w, r)
+	if watch == nil {
+		return
+	}
+
+	changes, metadata, err := app.models.Prices.GetAll(watch.ID, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"price": watch.Price, "prices": changes, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
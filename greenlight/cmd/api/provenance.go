@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"greenlight.alexedwards.net/internal/data"
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+// The listProvenanceHandler() returns the ownership timeline of a watch, in the order
+// the entries were recorded or, with "sort=acquired_on", by acquisition date. Owner
+// names, documents and notes are only included for users with the
+// provenance:read_private permission.
+func (app *application) listProvenanceHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	order := app.readString(r.URL.Query(), "sort", "sequence")
+	v.Check(validator.In(order, "sequence", "acquired_on"), "sort", "must be sequence or acquired_on")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	watch := app.getVisibleWatch(w, r)
+	if watch == nil {
+		return
+	}
+	entries, err := app.models.Provenance.GetAll(watch.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	private, err := app.hasPermission(r, "provenance:read_private")
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !private {
+		for _, entry := range entries {
+			entry.Redact()
+		}
+	}
+	// Entries without an acquisition date go after the dated ones, in sequence order.
+	if order == "acquired_on" {
+		sort.SliceStable(entries, func(i, j int) bool {
+			a, b := entries[i].AcquiredOn, entries[j].AcquiredOn
+			return a != nil && (b == nil || a.Before(*b))
+		})
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"provenance": entries}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The createProvenanceHandler() appends an entry to a watch's ownership chain.
+// Entries can't be changed or removed afterwards; a correction is a new entry.
+func (app *application) createProvenanceHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		OwnerType  string   `json:"owner_type"`
+		OwnerName  string   `json:"owner_name"`
+		AcquiredOn string   `json:"acquired_on"`
+		Source     string   `json:"source"`
+		Documents  []string `json:"documents"`
+		Notes      string   `json:"notes"`
+	}
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	userID := app.contextGetUser(r).ID
+	entry := &data.ProvenanceEntry{
+		WatchID:   id,
+		OwnerType: input.OwnerType,
+		OwnerName: input.OwnerName,
+		Source:    input.Source,
+		Documents: input.Documents,
+		Notes:     input.Notes,
+		CreatedBy: &userID,
+	}
+	v := validator.New()
+	if input.AcquiredOn != "" {
+		acquiredOn, err := time.Parse("2006-01-02", input.AcquiredOn)
+		v.Check(err == nil, "acquired_on", "must be a date (2006-01-02)")
+		if err == nil {
+			entry.AcquiredOn = &acquiredOn
+		}
+	}
+	if data.ValidateProvenanceEntry(v, entry); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Provenance.Insert(entry)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/watches/%d/provenance", id))
+	err = app.writeJSON(w, http.StatusCreated, envelope{"provenance_entry": entry}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The verifyProvenanceHandler() recomputes a watch's provenance chain and reports any
+// entries that have been altered, removed or reordered since they were recorded.
+func (app *application) verifyProvenanceHandler(w http.ResponseWriter, r *http.Request) {
+	watch := app.getVisibleWatch(w, r)
+	if watch == nil {
+		return
+	}
+	entries, head, err := app.models.Provenance.GetChain(watch.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	breaks := data.VerifyProvenanceChain(entries, head)
+	env := envelope{"valid": len(breaks) == 0, "entries": len(entries), "broken_links": breaks}
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,430 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"greenlight.alexedwards.net/internal/data"
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+// readBrand resolves a brand name or alias to the canonical brand. Unknown brands are
+// recorded as a validation error and return nil; brands are never created here.
+func (app *application) readBrand(name string, v *validator.Validator) (*data.CatalogEntity, error) {
+	if name == "" {
+		v.AddError("brand", "must be provided")
+		return nil, nil
+	}
+	brands, _, err := app.models.Brands.Resolve([]string{name})
+	if err != nil {
+		return nil, err
+	}
+	if len(brands) == 0 {
+		v.AddError("brand", fmt.Sprintf("unknown brand: %s", name))
+		return nil, nil
+	}
+	return brands[0], nil
+}
+
+func (app *application) readSerialIDParam(r *http.Request) (int64, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+	id, err := strconv.ParseInt(params.ByName("serial_id"), 10, 64)
+	if err != nil || id < 1 {
+		return 0, errors.New("invalid serial_id parameter")
+	}
+	return id, nil
+}
+
+func (app *application) listWatchSerialsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	_, err = app.models.Watches.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	serials, err := app.models.Serials.GetForWatch(id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"serials": serials}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The createWatchSerialHandler() registers the serial of one piece of a watch. The
+// brand must be one of the watch's brands, and can be left out when it has only one.
+func (app *application) createWatchSerialHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Brand  string `json:"brand"`
+		Serial string `json:"serial"`
+	}
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	watch, err := app.models.Watches.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	v := validator.New()
+	serial := &data.Serial{WatchID: watch.ID, Serial: data.NormalizeSerial(input.Serial)}
+	if input.Brand == "" && len(watch.BrandIDs) == 1 {
+		serial.BrandID = watch.BrandIDs[0]
+	} else {
+		brand, err := app.readBrand(input.Brand, v)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if brand != nil {
+			serial.BrandID = brand.ID
+			found := false
+			for _, id := range watch.BrandIDs {
+				found = found || id == brand.ID
+			}
+			v.Check(found, "brand", "must be one of the watch's brands")
+		}
+	}
+	if data.ValidateSerial(v, serial); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Serials.Insert(serial)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateSerial):
+			v.AddError("serial", "is already registered for this brand")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/watches/%d/serials", watch.ID))
+	err = app.writeJSON(w, http.StatusCreated, envelope{"serial": serial}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteWatchSerialHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	serialID, err := app.readSerialIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Serials.Delete(id, serialID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "serial successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The checkRegistryHandler() tells a dealer whether a serial is registered and whether
+// it has been reported stolen or lost. It deliberately leaves out which watch the
+// serial belongs to and who reported it.
+func (app *application) checkRegistryHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+	serial := data.NormalizeSerial(app.readString(qs, "serial", ""))
+	brand, err := app.readBrand(app.readString(qs, "brand", ""), v)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if data.ValidateSerialNumber(v, serial); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	check, err := app.models.Serials.Check(brand, serial)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"check": check}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The createRegistryReportHandler() files a stolen or lost report, which goes to staff
+// for review. Registry checks show a report straight away, marked as pending, and stop
+// showing it if it is dismissed. The serial doesn't need to be registered.
+func (app *application) createRegistryReportHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Brand           string `json:"brand"`
+		Serial          string `json:"serial"`
+		Kind            string `json:"kind"`
+		OccurredOn      string `json:"occurred_on"`
+		Description     string `json:"description"`
+		PoliceReference string `json:"police_reference"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	report := &data.RegistryReport{
+		Serial:          data.NormalizeSerial(input.Serial),
+		Kind:            input.Kind,
+		Description:     input.Description,
+		PoliceReference: input.PoliceReference,
+		UserID:          app.contextGetUser(r).ID,
+	}
+	brand, err := app.readBrand(input.Brand, v)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if brand != nil {
+		report.BrandID = brand.ID
+	}
+	if input.OccurredOn != "" {
+		occurredOn, err := time.Parse("2006-01-02", input.OccurredOn)
+		v.Check(err == nil, "occurred_on", "must be a date (2006-01-02)")
+		if err == nil {
+			report.OccurredOn = &occurredOn
+		}
+	}
+	if data.ValidateRegistryReport(v, report); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Reports.Insert(report)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateReport):
+			app.conflictResponse(w, r, "you already have a pending report for this serial")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/registry/reports/%d", report.ID))
+	err = app.writeJSON(w, http.StatusCreated, envelope{"report": report}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) readRegistryReportFilters(r *http.Request, v *validator.Validator) data.Filters {
+	qs := r.URL.Query()
+	filters := data.Filters{
+		Page:         app.readInt(qs, "page", 1, v),
+		PageSize:     app.readInt(qs, "page_size", 20, v),
+		Sort:         app.readString(qs, "sort", "created_at"),
+		SortSafelist: []string{"id", "created_at", "-id", "-created_at"},
+	}
+	data.ValidateFilters(v, filters)
+	return filters
+}
+
+// The listRegistryReportsHandler() is the review queue. It lists pending reports,
+// oldest first, unless another status is asked for.
+func (app *application) listRegistryReportsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+	status := app.readString(qs, "status", data.ReviewPending)
+	v.Check(validator.In(status, data.ReportStatuses...), "status", "must be pending, verified or dismissed")
+	serial := data.NormalizeSerial(app.readString(qs, "serial", ""))
+	filters := app.readRegistryReportFilters(r, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	reports, metadata, err := app.models.Reports.GetAll(0, status, serial, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"reports": reports, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The listMyRegistryReportsHandler() lets users follow the reports they've filed.
+func (app *application) listMyRegistryReportsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	filters := app.readRegistryReportFilters(r, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	reports, metadata, err := app.models.Reports.GetAll(app.contextGetUser(r).ID, "", "", filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"reports": reports, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// getRegistryReport fetches the report named in the URL, writing the error response
+// and returning nil if it can't be found.
+func (app *application) getRegistryReport(w http.ResponseWriter, r *http.Request) *data.RegistryReport {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil
+	}
+	report, err := app.models.Reports.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil
+	}
+	return report
+}
+
+func (app *application) showRegistryReportHandler(w http.ResponseWriter, r *http.Request) {
+	report := app.getRegistryReport(w, r)
+	if report == nil {
+		return
+	}
+	err := app.writeJSON(w, http.StatusOK, envelope{"report": report}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) verifyRegistryReportHandler(w http.ResponseWriter, r *http.Request) {
+	app.reviewRegistryReport(w, r, data.ReportVerified)
+}
+
+func (app *application) dismissRegistryReportHandler(w http.ResponseWriter, r *http.Request) {
+	app.reviewRegistryReport(w, r, data.ReportDismissed)
+}
+
+// reviewRegistryReport records a decision on a pending report, taking an optional
+// note from the request body, and emails the outcome to the reporter. Staff can't
+// review reports they filed themselves.
+func (app *application) reviewRegistryReport(w http.ResponseWriter, r *http.Request, status string) {
+	var input struct {
+		Note string `json:"note"`
+	}
+	if r.ContentLength != 0 {
+		err := app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+	}
+
+	report := app.getRegistryReport(w, r)
+	if report == nil {
+		return
+	}
+	if report.Status != data.ReviewPending {
+		app.conflictResponse(w, r, fmt.Sprintf("the report has already been %s", report.Status))
+		return
+	}
+	reviewer := app.contextGetUser(r).ID
+	if report.UserID == reviewer {
+		app.errorResponse(w, r, http.StatusForbidden, "reports must be reviewed by someone else")
+		return
+	}
+
+	now := time.Now()
+	report.Status = status
+	report.ReviewedBy = &reviewer
+	report.ReviewedAt = &now
+	report.ReviewNote = input.Note
+
+	v := validator.New()
+	if data.ValidateRegistryReportReview(v, report); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err := app.models.Reports.Update(report)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.background(func() {
+		data := map[string]interface{}{
+			"name":   report.ReporterName,
+			"report": report,
+		}
+		err := app.mailer.Send(report.ReporterEmail, "registry_report_reviewed.tmpl", data)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"registry_report_id": fmt.Sprint(report.ID)})
+		}
+	})
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"report": report}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
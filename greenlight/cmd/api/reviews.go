@@ -0,0 +1,277 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"greenlight.alexedwards.net/internal/data"
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+// readReviewFilters reads the paging and sorting parameters for a reviews list.
+func (app *application) readReviewFilters(r *http.Request, v *validator.Validator, defaultSort string) data.Filters {
+	qs := r.URL.Query()
+	filters := data.Filters{
+		Page:         app.readInt(qs, "page", 1, v),
+		PageSize:     app.readInt(qs, "page_size", 20, v),
+		Sort:         app.readString(qs, "sort", defaultSort),
+		SortSafelist: []string{"id", "created_at", "rating", "-id", "-created_at", "-rating"},
+	}
+	data.ValidateFilters(v, filters)
+	return filters
+}
+
+// The listWatchReviewsHandler() lists the approved reviews of a watch.
+func (app *application) listWatchReviewsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	filters := app.readReviewFilters(r, v, "-created_at")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	watch := app.getVisibleWatch(w, r)
+	if watch == nil {
+		return
+	}
+	reviews, metadata, err := app.models.Reviews.GetAll(watch.ID, 0, data.ReviewApproved, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"reviews": reviews, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The createReviewHandler() lets a user review a watch they have bought. The review
+// is only published once a moderator approves it.
+func (app *application) createReviewHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Rating int    `json:"rating"`
+		Text   string `json:"text"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	watch := app.getVisibleWatch(w, r)
+	if watch == nil {
+		return
+	}
+	user := app.contextGetUser(r)
+	review := &data.Review{
+		WatchID: watch.ID,
+		UserID:  user.ID,
+		Author:  user.Name,
+		Rating:  input.Rating,
+		Text:    input.Text,
+	}
+	v := validator.New()
+	if data.ValidateReview(v, review); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Reviews.Insert(review)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNotOwner):
+			app.errorResponse(w, r, http.StatusForbidden, "you can only review watches you have bought")
+		case errors.Is(err, data.ErrDuplicateReview):
+			app.conflictResponse(w, r, "you have already reviewed this watch")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/users/me/reviews/%d", review.ID))
+	err = app.writeJSON(w, http.StatusCreated, envelope{"review": review}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// getReview fetches the review named in the URL, writing the error response and
+// returning nil if it can't be found. When own is true, reviews written by other users
+// are reported as not found.
+func (app *application) getReview(w http.ResponseWriter, r *http.Request, own bool) *data.Review {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil
+	}
+	review, err := app.models.Reviews.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil
+	}
+	if own && review.UserID != app.contextGetUser(r).ID {
+		app.notFoundResponse(w, r)
+		return nil
+	}
+	return review
+}
+
+func (app *application) listMyReviewsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	filters := app.readReviewFilters(r, v, "-created_at")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	reviews, metadata, err := app.models.Reviews.GetAll(0, app.contextGetUser(r).ID, "", filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"reviews": reviews, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The updateMyReviewHandler() lets a user edit their review. The edited review goes
+// back into the moderation queue.
+func (app *application) updateMyReviewHandler(w http.ResponseWriter, r *http.Request) {
+	review := app.getReview(w, r, true)
+	if review == nil {
+		return
+	}
+
+	var input struct {
+		Rating *int    `json:"rating"`
+		Text   *string `json:"text"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if input.Rating != nil {
+		review.Rating = *input.Rating
+	}
+	if input.Text != nil {
+		review.Text = *input.Text
+	}
+	review.Status = data.ReviewPending
+	review.ModeratedBy = nil
+	review.ModeratedAt = nil
+	review.ModerationNote = ""
+
+	v := validator.New()
+	if data.ValidateReview(v, review); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	app.updateReview(w, r, review)
+}
+
+func (app *application) deleteMyReviewHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	err = app.models.Reviews.Delete(id, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "review successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The listReviewsHandler() is the moderation queue. It lists pending reviews, oldest
+// first, unless another status is asked for.
+func (app *application) listReviewsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+	watchID := app.readInt(qs, "watch_id", 0, v)
+	status := app.readString(qs, "status", data.ReviewPending)
+	v.Check(validator.In(status, data.ReviewStatuses...), "status", "must be pending, approved or rejected")
+	filters := app.readReviewFilters(r, v, "created_at")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	reviews, metadata, err := app.models.Reviews.GetAll(int64(watchID), 0, status, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"reviews": reviews, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The moderateReviewHandler() approves or rejects a review.
+func (app *application) moderateReviewHandler(w http.ResponseWriter, r *http.Request) {
+	review := app.getReview(w, r, false)
+	if review == nil {
+		return
+	}
+
+	var input struct {
+		Status string `json:"status"`
+		Note   string `json:"note"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	moderator := app.contextGetUser(r).ID
+	now := time.Now()
+	review.Status = input.Status
+	review.ModeratedBy = &moderator
+	review.ModeratedAt = &now
+	review.ModerationNote = input.Note
+
+	v := validator.New()
+	if data.ValidateReviewModeration(v, review); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	app.updateReview(w, r, review)
+}
+
+func (app *application) updateReview(w http.ResponseWriter, r *http.Request, review *data.Review) {
+	err := app.models.Reviews.Update(review)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"review": review}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -15,15 +15,163 @@ func (app *application) routes() http.Handler {
 
 	// Use the requirePermission() middleware on each of the /v1/movies** endpoints,
 	// passing in the required permission code as the first parameter.
-	router.HandlerFunc(http.MethodGet, "/v1/movies", app.requirePermission("movies:read", app.listWatchesHandler))
-	router.HandlerFunc(http.MethodPost, "/v1/movies", app.requirePermission("movies:write", app.createWatchesHandler))
-	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.requirePermission("movies:read", app.showWatchesHandler))
-	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.requirePermission("movies:write", app.updateWatchesHandler))
-	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.requirePermission("movies:write", app.deleteWatchesHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/movies", app.requirePermission("watches:read", app.listWatchesHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies", app.requirePermission("watches:write", app.createWatchesHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.requirePermission("watches:read", app.showWatchesHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.requirePermission("watches:write", app.updateWatchesHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.requirePermission("watches:write", app.deleteWatchesHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/publish", app.requirePermission("watches:write", app.publishWatchHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/unpublish", app.requirePermission("watches:write", app.unpublishWatchHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/archive", app.requirePermission("watches:write", app.archiveWatchHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/change-requests", app.requirePermission("watches:approve", app.listChangeRequestsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/change-requests/:id", app.requirePermission("watches:approve", app.showChangeRequestHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/change-requests/:id/approve", app.requirePermission("watches:approve", app.approveChangeRequestHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/change-requests/:id/reject", app.requirePermission("watches:approve", app.rejectChangeRequestHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/watches/:id/images", app.requirePermission("watches:read", app.listWatchImagesHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/watches/:id/images", app.requirePermission("watches:write", app.uploadWatchImageHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/watches/:id/images/:image_id", app.requirePermission("watches:write", app.updateWatchImageHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/watches/:id/images/:image_id", app.requirePermission("watches:write", app.deleteWatchImageHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/static/*filepath", app.serveStaticHandler)
+
+	// httprouter doesn't allow a static segment such as /v1/watches/compare alongside
+	// the /v1/watches/:id wildcard (it panics when the routes are registered), so the
+	// comparison is registered on the wildcard and picked out by the segment.
+	router.HandlerFunc(http.MethodGet, "/v1/watches/:id", app.watchSegmentHandler(map[string]http.HandlerFunc{
+		"compare": app.requirePermission("watches:read", app.compareWatchesHandler),
+	}))
+	router.HandlerFunc(http.MethodGet, "/v1/watches/:id/similar", app.requirePermission("watches:read", app.similarWatchesHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/watches/:id/reviews", app.requirePermission("watches:read", app.listWatchReviewsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/watches/:id/reviews", app.requireActivatedUser(app.createReviewHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/watches/:id/prices", app.requirePermission("watches:read", app.listPriceHistoryHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/watches/:id/provenance", app.requirePermission("watches:read", app.listProvenanceHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/watches/:id/provenance", app.requirePermission("provenance:write", app.createProvenanceHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/watches/:id/provenance/verify", app.requirePermission("watches:read", app.verifyProvenanceHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/watches/:id/serials", app.requirePermission("watches:write", app.listWatchSerialsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/watches/:id/serials", app.requirePermission("watches:write", app.createWatchSerialHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/watches/:id/serials/:serial_id", app.requirePermission("watches:write", app.deleteWatchSerialHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/watches/:id/service-records", app.requirePermission("watches:read", app.listServiceRecordsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/watches/:id/service-records", app.requirePermission("service:write", app.createServiceRecordHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/service-records/:id", app.requirePermission("watches:read", app.showServiceRecordHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/service-records/:id", app.requirePermission("service:write", app.updateServiceRecordHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/service-records/:id", app.requirePermission("service:write", app.deleteServiceRecordHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/service/due", app.requirePermission("service:write", app.serviceDueHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/registry/check", app.requirePermission("watches:read", app.checkRegistryHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/registry/reports", app.requireActivatedUser(app.limitReports(app.createRegistryReportHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/registry/reports", app.requirePermission("registry:review", app.listRegistryReportsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/registry/reports/:id", app.requirePermission("registry:review", app.showRegistryReportHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/registry/reports/:id/verify", app.requirePermission("registry:review", app.verifyRegistryReportHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/registry/reports/:id/dismiss", app.requirePermission("registry:review", app.dismissRegistryReportHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/reviews", app.requirePermission("reviews:moderate", app.listReviewsHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/reviews/:id", app.requirePermission("reviews:moderate", app.moderateReviewHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/watches/:id/stock", app.requirePermission("inventory:read", app.showWatchStockHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/watches/:id/stock/movements", app.requirePermission("inventory:read", app.listStockMovementsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/watches/:id/stock/movements", app.requirePermission("inventory:write", app.createStockMovementHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/analytics/prices", app.requirePermission("analytics:read", app.priceStatsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/analytics/prices/histogram", app.requirePermission("analytics:read", app.priceHistogramHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/analytics/prices/trend", app.requirePermission("analytics:read", app.priceTrendHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/analytics/years", app.requirePermission("analytics:read", app.yearCountsHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/holds", app.requirePermission("holds:read", app.listHoldsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/holds", app.requirePermission("holds:write", app.createHoldHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/holds/:id", app.requirePermission("holds:read", app.showHoldHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/holds/:id/extend", app.requirePermission("holds:write", app.extendHoldHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/holds/:id/release", app.requirePermission("holds:write", app.releaseHoldHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/orders", app.requirePermission("orders:manage", app.listOrdersHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/orders/:id", app.requirePermission("orders:manage", app.showOrderHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/orders/:id", app.requirePermission("orders:manage", app.updateOrderStatusHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/orders/:id/payments", app.requirePermission("orders:manage", app.listOrderPaymentsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/orders/:id/refund", app.requirePermission("orders:manage", app.refundOrderHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/payments/webhook", app.paymentWebhookHandler)
+
+	router.HandlerFunc(http.MethodGet, "/v1/auctions", app.requirePermission("watches:read", app.listAuctionsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/auctions", app.requirePermission("auctions:write", app.createAuctionHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/auctions/:id", app.requirePermission("watches:read", app.showAuctionHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/auctions/:id/cancel", app.requirePermission("auctions:write", app.cancelAuctionHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/auctions/:id/bids", app.requirePermission("watches:read", app.listBidsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/auctions/:id/bids", app.requirePermission("auctions:bid", app.createBidHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/locations", app.requirePermission("inventory:read", app.listLocationsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/locations", app.requirePermission("inventory:write", app.createLocationHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/locations/:id", app.requirePermission("inventory:read", app.showLocationHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/locations/:id", app.requirePermission("inventory:write", app.updateLocationHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/locations/:id/stock", app.requirePermission("inventory:read", app.showLocationStockHandler))
+
+	for _, kind := range []catalogEntityKind{app.brandKind(), app.materialKind()} {
+		router.HandlerFunc(http.MethodGet, "/v1/"+kind.plural, app.requirePermission("watches:read", app.listCatalogEntitiesHandler(kind)))
+		router.HandlerFunc(http.MethodPost, "/v1/"+kind.plural, app.requirePermission("watches:write", app.createCatalogEntityHandler(kind)))
+		router.HandlerFunc(http.MethodGet, "/v1/"+kind.plural+"/:id", app.requirePermission("watches:read", app.showCatalogEntityHandler(kind)))
+		router.HandlerFunc(http.MethodPatch, "/v1/"+kind.plural+"/:id", app.requirePermission("watches:write", app.updateCatalogEntityHandler(kind)))
+		router.HandlerFunc(http.MethodDelete, "/v1/"+kind.plural+"/:id", app.requirePermission("watches:write", app.deleteCatalogEntityHandler(kind)))
+	}
+
+	router.HandlerFunc(http.MethodGet, "/v1/categories", app.requirePermission("watches:read", app.listCategoriesHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/categories", app.requirePermission("watches:write", app.createCategoryHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/categories/:id", app.requirePermission("watches:read", app.showCategoryHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/categories/:id", app.requirePermission("watches:write", app.updateCategoryHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/categories/:id", app.requirePermission("watches:write", app.deleteCategoryHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/watches/:id/categories", app.requirePermission("watches:write", app.setWatchCategoriesHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/collections", app.requirePermission("watches:read", app.listCollectionsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/collections", app.requirePermission("watches:write", app.createCollectionHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/collections/:id", app.requirePermission("watches:read", app.showCollectionHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/collections/:id", app.requirePermission("watches:write", app.updateCollectionHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/collections/:id", app.requirePermission("watches:write", app.deleteCollectionHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/collections/:id/watches", app.requirePermission("watches:read", app.listCollectionWatchesHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/collections/:id/watches", app.requirePermission("watches:write", app.setCollectionWatchesHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/tags", app.requirePermission("watches:read", app.listTagsHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/tags/:id", app.requirePermission("tags:manage", app.renameTagHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/tags/:id", app.requirePermission("tags:manage", app.deleteTagHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/tags/:id/merge", app.requirePermission("tags:manage", app.mergeTagHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/watches/:id/tags", app.requirePermission("watches:write", app.addWatchTagsHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/watches/:id/tags/:tag", app.requirePermission("watches:write", app.removeWatchTagHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/spec-attributes", app.requirePermission("watches:read", app.listSpecAttributesHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/spec-attributes", app.requirePermission("watches:write", app.createSpecAttributeHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/spec-attributes/:id", app.requirePermission("watches:read", app.showSpecAttributeHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/spec-attributes/:id", app.requirePermission("watches:write", app.updateSpecAttributeHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/spec-attributes/:id", app.requirePermission("watches:write", app.deleteSpecAttributeHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/exchange-rates", app.requirePermission("watches:read", app.listExchangeRatesHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/exchange-rates", app.requirePermission("exchange_rates:write", app.createExchangeRatesHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/exchange-rates/import", app.requirePermission("exchange_rates:write", app.importExchangeRatesHandler))
 
 	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
 	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
 
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/wishlist", app.requireActivatedUser(app.listWishlistHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/wishlist", app.requireActivatedUser(app.addWishlistItemHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/wishlist/:id", app.requireActivatedUser(app.removeWishlistItemHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/alerts", app.requireActivatedUser(app.listAlertsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/alerts", app.requireActivatedUser(app.createAlertHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/alerts/:id", app.requireActivatedUser(app.deleteAlertHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/alerts/:id/unsubscribe", app.unsubscribeAlertHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/reviews", app.requireActivatedUser(app.listMyReviewsHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/users/me/reviews/:id", app.requireActivatedUser(app.updateMyReviewHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/reviews/:id", app.requireActivatedUser(app.deleteMyReviewHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/change-requests", app.requirePermission("watches:write", app.listMyChangeRequestsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/registry-reports", app.requireActivatedUser(app.listMyRegistryReportsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/searches", app.requireActivatedUser(app.listSavedSearchesHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/searches", app.requireActivatedUser(app.createSavedSearchHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/searches/:id", app.requireActivatedUser(app.showSavedSearchHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/users/me/searches/:id", app.requireActivatedUser(app.updateSavedSearchHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/searches/:id", app.requireActivatedUser(app.deleteSavedSearchHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/cart", app.requirePermission("orders:create", app.showCartHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/cart", app.requirePermission("orders:create", app.addCartItemHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/cart/:id", app.requirePermission("orders:create", app.removeCartItemHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/checkout", app.requirePermission("orders:create", app.checkoutHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/orders", app.requirePermission("orders:create", app.listMyOrdersHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/orders", app.requirePermission("orders:create", app.createOrderHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/orders/:id", app.requirePermission("orders:create", app.showMyOrderHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/orders/:id/cancel", app.requirePermission("orders:create", app.cancelMyOrderHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/orders/:id/payments", app.requirePermission("orders:create", app.listMyOrderPaymentsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/orders/:id/payments", app.requirePermission("orders:create", app.createPaymentHandler))
+
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
 
 	return app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(router))))
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"greenlight.alexedwards.net/internal/data"
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+// savedSearchQuery parses the query string of a saved search with the same rules as
+// the watch list, and normalizes it. Paging, currency and status parameters aren't part
+// of a saved search and are dropped, and the sort is kept in its own field.
+func (app *application) savedSearchQuery(search *data.SavedSearch, v *validator.Validator) (data.WatchesQuery, data.Filters, error) {
+	qs, err := url.ParseQuery(search.Query)
+	if err != nil {
+		v.AddError("query", "must be a valid query string")
+		return data.WatchesQuery{}, data.Filters{}, nil
+	}
+	for _, key := range []string{"page", "page_size", "sort", "currency", "status"} {
+		qs.Del(key)
+	}
+	search.Query = qs.Encode()
+
+	qs.Set("sort", search.Sort)
+	return app.readWatchesQuery(qs, v)
+}
+
+func (app *application) listSavedSearchesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+	filters := data.Filters{
+		Page:         app.readInt(qs, "page", 1, v),
+		PageSize:     app.readInt(qs, "page_size", 20, v),
+		Sort:         app.readString(qs, "sort", "name"),
+		SortSafelist: []string{"id", "name", "created_at", "-id", "-name", "-created_at"},
+	}
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	searches, metadata, err := app.models.Searches.GetAll(app.contextGetUser(r).ID, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"searches": searches, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createSavedSearchHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name      string `json:"name"`
+		Query     string `json:"query"`
+		Sort      string `json:"sort"`
+		Frequency string `json:"frequency"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	search := &data.SavedSearch{
+		UserID:    app.contextGetUser(r).ID,
+		Name:      input.Name,
+		Query:     input.Query,
+		Sort:      input.Sort,
+		Frequency: input.Frequency,
+	}
+	if search.Sort == "" {
+		search.Sort = "id"
+	}
+	if search.Frequency == "" {
+		search.Frequency = data.DigestDaily
+	}
+	app.saveSearch(w, r, search, http.StatusCreated)
+}
+
+// getSavedSearch fetches the user's saved search named in the URL, writing the error
+// response and returning nil if it can't be found.
+func (app *application) getSavedSearch(w http.ResponseWriter, r *http.Request) *data.SavedSearch {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil
+	}
+	search, err := app.models.Searches.Get(id, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil
+	}
+	return search
+}
+
+func (app *application) showSavedSearchHandler(w http.ResponseWriter, r *http.Request) {
+	search := app.getSavedSearch(w, r)
+	if search == nil {
+		return
+	}
+	err := app.writeJSON(w, http.StatusOK, envelope{"search": search}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateSavedSearchHandler(w http.ResponseWriter, r *http.Request) {
+	search := app.getSavedSearch(w, r)
+	if search == nil {
+		return
+	}
+
+	var input struct {
+		Name      *string `json:"name"`
+		Query     *string `json:"query"`
+		Sort      *string `json:"sort"`
+		Frequency *string `json:"frequency"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if input.Name != nil {
+		search.Name = *input.Name
+	}
+	if input.Query != nil {
+		search.Query = *input.Query
+	}
+	if input.Sort != nil {
+		search.Sort = *input.Sort
+	}
+	if input.Frequency != nil {
+		search.Frequency = *input.Frequency
+	}
+	app.saveSearch(w, r, search, http.StatusOK)
+}
+
+// saveSearch validates a new or changed saved search, including its query, and saves
+// it.
+func (app *application) saveSearch(w http.ResponseWriter, r *http.Request, search *data.SavedSearch, status int) {
+	v := validator.New()
+	_, _, err := app.savedSearchQuery(search, v)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if data.ValidateSavedSearch(v, search); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	headers := make(http.Header)
+	if search.ID == 0 {
+		err = app.models.Searches.Insert(search)
+		headers.Set("Location", fmt.Sprintf("/v1/users/me/searches/%d", search.ID))
+	} else {
+		err = app.models.Searches.Update(search)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateSavedSearch):
+			v.AddError("name", "you already have a saved search with this name")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, status, envelope{"search": search}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteSavedSearchHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	err = app.models.Searches.Delete(id, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "saved search successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// sendSearchDigests is run periodically to email the digests that are due. It stops
+// between searches when the server is shutting down; the remaining digests are still
+// due when it next starts.
+func (app *application) sendSearchDigests() {
+	due, err := app.models.Searches.GetDue()
+	if err != nil {
+		app.logger.PrintError(err, map[string]string{"job": "saved search digests"})
+		return
+	}
+	for _, d := range due {
+		select {
+		case <-app.shutdown:
+			return
+		default:
+			app.sendSearchDigest(d)
+		}
+	}
+}
+
+// sendSearchDigest emails the watches added since a search's watermark, if there are
+// any, and then moves the watermark forward. If the email can't be sent the watermark
+// stays put, so the digest is retried on the next run.
+func (app *application) sendSearchDigest(d *data.DueSearch) {
+	properties := map[string]string{"job": "saved search digests", "search_id": fmt.Sprint(d.Search.ID)}
+	watermark := time.Now()
+
+	v := validator.New()
+	query, filters, err := app.savedSearchQuery(d.Search, v)
+	if err != nil {
+		app.logger.PrintError(err, properties)
+		return
+	}
+
+	// A search can stop being valid, for example when a spec attribute it filters on
+	// is deleted. Its digests are skipped until the user fixes it.
+	if v.Valid() {
+		query.AddedAfter = d.Search.LastNotifiedAt
+		query.AddedBefore = watermark
+		filters.PageSize = app.config.searches.digestSize
+
+		watches, metadata, err := app.models.Watches.GetAll(query, filters)
+		if err != nil {
+			app.logger.PrintError(err, properties)
+			return
+		}
+		if len(watches) > 0 {
+			data := map[string]interface{}{
+				"name":       d.Name,
+				"searchName": d.Search.Name,
+				"frequency":  d.Search.Frequency,
+				"watches":    watches,
+				"total":      metadata.TotalRecords,
+			}
+			err = app.mailer.Send(d.Email, "saved_search_digest.tmpl", data)
+			if err != nil {
+				app.logger.PrintError(err, properties)
+				return
+			}
+		}
+	} else {
+		app.logger.PrintInfo("skipping invalid saved search", properties)
+	}
+
+	err = app.models.Searches.Advance(d.Search, watermark)
+	if err != nil {
+		app.logger.PrintError(err, properties)
+	}
+}
@@ -36,6 +36,7 @@ func (app *application) serve() error {
 		app.logger.PrintInfo("completing background tasks", map[string]string{
 			"addr": srv.Addr,
 		})
+		close(app.shutdown)
 		app.wg.Wait()
 		shutdownError <- nil
 	}()
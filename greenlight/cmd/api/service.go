@@ -0,0 +1,323 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"greenlight.alexedwards.net/internal/data"
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+// parseServiceDate parses a date such as "2024-03-01" from a service record body. An
+// empty string is no date.
+func parseServiceDate(value, key string, v *validator.Validator) *time.Time {
+	if value == "" {
+		return nil
+	}
+	date, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		v.AddError(key, "must be a date (2006-01-02)")
+		return nil
+	}
+	return &date
+}
+
+// The listServiceRecordsHandler() returns the service history of a watch, latest
+// service first.
+func (app *application) listServiceRecordsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+	filters := data.Filters{
+		Page:         app.readInt(qs, "page", 1, v),
+		PageSize:     app.readInt(qs, "page_size", 20, v),
+		Sort:         app.readString(qs, "sort", "-serviced_on"),
+		SortSafelist: []string{"serviced_on", "-serviced_on"},
+	}
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	watch := app.getVisibleWatch(w, r)
+	if watch == nil {
+		return
+	}
+	records, metadata, err := app.models.Service.GetAll(watch.ID, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"service_records": records, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createServiceRecordHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		OwnerID    *int64      `json:"owner_id"`
+		ServicedOn string      `json:"serviced_on"`
+		Workshop   string      `json:"workshop"`
+		WorkDone   string      `json:"work_done"`
+		Parts      []string    `json:"parts"`
+		Cost       *data.Money `json:"cost"`
+		NextDueOn  string      `json:"next_due_on"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	watch := app.getVisibleWatch(w, r)
+	if watch == nil {
+		return
+	}
+	v := validator.New()
+	user := app.contextGetUser(r)
+	record := &data.ServiceRecord{
+		WatchID:   watch.ID,
+		UserID:    &user.ID,
+		OwnerID:   input.OwnerID,
+		Workshop:  input.Workshop,
+		WorkDone:  input.WorkDone,
+		Parts:     input.Parts,
+		Cost:      input.Cost,
+		NextDueOn: parseServiceDate(input.NextDueOn, "next_due_on", v),
+	}
+	if servicedOn := parseServiceDate(input.ServicedOn, "serviced_on", v); servicedOn != nil {
+		record.ServicedOn = *servicedOn
+	}
+	if record.Parts == nil {
+		record.Parts = []string{}
+	}
+	if data.ValidateServiceRecord(v, record); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Service.Insert(record)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrUnknownOwner):
+			v.AddError("owner_id", "user does not exist")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/service-records/%d", record.ID))
+	err = app.writeJSON(w, http.StatusCreated, envelope{"service_record": record}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// getServiceRecord fetches the service record named in the URL, writing the error
+// response and returning nil if it can't be found or the user may not see its watch.
+func (app *application) getServiceRecord(w http.ResponseWriter, r *http.Request) *data.ServiceRecord {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil
+	}
+	record, err := app.models.Service.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil
+	}
+	_, err = app.findVisibleWatch(r, record.WatchID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil
+	}
+	return record
+}
+
+// The showServiceRecordHandler() returns a single record.
+func (app *application) showServiceRecordHandler(w http.ResponseWriter, r *http.Request) {
+	record := app.getServiceRecord(w, r)
+	if record == nil {
+		return
+	}
+	err := app.writeJSON(w, http.StatusOK, envelope{"service_record": record}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The updateServiceRecordHandler() makes a partial update. Sending an empty
+// next_due_on clears it.
+func (app *application) updateServiceRecordHandler(w http.ResponseWriter, r *http.Request) {
+	record := app.getServiceRecord(w, r)
+	if record == nil {
+		return
+	}
+
+	var input struct {
+		OwnerID    *int64      `json:"owner_id"`
+		ServicedOn *string     `json:"serviced_on"`
+		Workshop   *string     `json:"workshop"`
+		WorkDone   *string     `json:"work_done"`
+		Parts      []string    `json:"parts"`
+		Cost       *data.Money `json:"cost"`
+		NextDueOn  *string     `json:"next_due_on"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if input.OwnerID != nil {
+		record.OwnerID = input.OwnerID
+	}
+	if input.ServicedOn != nil {
+		record.ServicedOn = time.Time{}
+		if servicedOn := parseServiceDate(*input.ServicedOn, "serviced_on", v); servicedOn != nil {
+			record.ServicedOn = *servicedOn
+		}
+	}
+	if input.Workshop != nil {
+		record.Workshop = *input.Workshop
+	}
+	if input.WorkDone != nil {
+		record.WorkDone = *input.WorkDone
+	}
+	if input.Parts != nil {
+		record.Parts = input.Parts
+	}
+	if input.Cost != nil {
+		record.Cost = input.Cost
+	}
+	if input.NextDueOn != nil {
+		record.NextDueOn = parseServiceDate(*input.NextDueOn, "next_due_on", v)
+	}
+	if data.ValidateServiceRecord(v, record); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Service.Update(record)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrUnknownOwner):
+			v.AddError("owner_id", "user does not exist")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"service_record": record}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteServiceRecordHandler(w http.ResponseWriter, r *http.Request) {
+	record := app.getServiceRecord(w, r)
+	if record == nil {
+		return
+	}
+	err := app.models.Service.Delete(record.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "service record successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The serviceDueHandler() reports the watches that are due for service within the
+// next "within" days (30 by default), overdue ones included, soonest first.
+func (app *application) serviceDueHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+	within := app.readInt(qs, "within", 30, v)
+	v.Check(within >= 0, "within", "must not be negative")
+	v.Check(within <= 366, "within", "must not be more than 366 days")
+	filters := data.Filters{
+		Page:         app.readInt(qs, "page", 1, v),
+		PageSize:     app.readInt(qs, "page_size", 20, v),
+		Sort:         "next_due_on",
+		SortSafelist: []string{"next_due_on"},
+	}
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	before := time.Now().AddDate(0, 0, within)
+	due, metadata, err := app.models.Service.GetDue(before, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"due": due, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// sendServiceReminders is run periodically to email the owner of a watch, or whoever
+// logged its service, when the next one is coming up. A record is only marked as
+// reminded once its email has gone, so a failed email is retried on the next run.
+func (app *application) sendServiceReminders() {
+	reminders, err := app.models.Service.GetReminders(app.config.service.reminderDays)
+	if err != nil {
+		app.logger.PrintError(err, map[string]string{"job": "service reminders"})
+		return
+	}
+	for _, reminder := range reminders {
+		select {
+		case <-app.shutdown:
+			return
+		default:
+		}
+
+		properties := map[string]string{"job": "service reminders", "service_record_id": fmt.Sprint(reminder.Record.ID)}
+		data := map[string]interface{}{
+			"name":       reminder.Name,
+			"watchTitle": reminder.WatchTitle,
+			"record":     reminder.Record,
+			"dueOn":      reminder.Record.NextDueOn.Format("2 January 2006"),
+		}
+		err := app.mailer.Send(reminder.Email, "service_reminder.tmpl", data)
+		if err != nil {
+			app.logger.PrintError(err, properties)
+			continue
+		}
+		err = app.models.Service.MarkReminded(reminder.Record)
+		if err != nil {
+			app.logger.PrintError(err, properties)
+		}
+	}
+}
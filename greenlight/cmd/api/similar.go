@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"greenlight.alexedwards.net/internal/data"
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+// The similarWatchesHandler() recommends watches similar to the one in the URL, using
+// the similarity weights from the command-line flags.
+func (app *application) similarWatchesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+	// Recommendations are always ordered by score.
+	filters := data.Filters{
+		Page:         app.readInt(qs, "page", 1, v),
+		PageSize:     app.readInt(qs, "page_size", 10, v),
+		Sort:         "-score",
+		SortSafelist: []string{"-score"},
+	}
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	watch := app.getVisibleWatch(w, r)
+	if watch == nil {
+		return
+	}
+	similar, metadata, err := app.models.Watches.GetSimilar(watch.ID, app.config.similar, filters)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	watches := make([]*data.Watches, len(similar))
+	for i, s := range similar {
+		watches[i] = s.Watch
+	}
+	err = app.attachWatchDetails(watches...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"similar": similar, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"greenlight.alexedwards.net/internal/data"
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+func (app *application) createSpecAttributeHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name          string   `json:"name"`
+		Label         string   `json:"label"`
+		Type          string   `json:"type"`
+		Unit          string   `json:"unit"`
+		AllowedValues []string `json:"allowed_values"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	attribute := &data.SpecAttribute{
+		Name:          input.Name,
+		Label:         input.Label,
+		Type:          input.Type,
+		Unit:          input.Unit,
+		AllowedValues: input.AllowedValues,
+	}
+	if attribute.AllowedValues == nil {
+		attribute.AllowedValues = []string{}
+	}
+	v := validator.New()
+	if data.ValidateSpecAttribute(v, attribute); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Specs.Insert(attribute)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateSpecAttribute):
+			v.AddError("name", "a spec attribute with this name already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/spec-attributes/%d", attribute.ID))
+	err = app.writeJSON(w, http.StatusCreated, envelope{"spec_attribute": attribute}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showSpecAttributeHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	attribute, err := app.models.Specs.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"spec_attribute": attribute}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateSpecAttributeHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	attribute, err := app.models.Specs.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// The name isn't accepted here because it is the key stored in every watch's specs.
+	var input struct {
+		Label         *string   `json:"label"`
+		Type          *string   `json:"type"`
+		Unit          *string   `json:"unit"`
+		AllowedValues *[]string `json:"allowed_values"`
+	}
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if input.Label != nil {
+		attribute.Label = *input.Label
+	}
+	if input.Type != nil {
+		attribute.Type = *input.Type
+	}
+	if input.Unit != nil {
+		attribute.Unit = *input.Unit
+	}
+	if input.AllowedValues != nil {
+		attribute.AllowedValues = *input.AllowedValues
+	}
+
+	v := validator.New()
+	if data.ValidateSpecAttribute(v, attribute); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.Specs.Update(attribute)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrSpecValuesInvalid):
+			app.conflictResponse(w, r, "some watches have values for this attribute that the new definition doesn't allow")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"spec_attribute": attribute}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteSpecAttributeHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	err = app.models.Specs.Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "spec attribute successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listSpecAttributesHandler(w http.ResponseWriter, r *http.Request) {
+	schema, err := app.models.Specs.GetSchema()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"spec_attributes": schema.Attributes()}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The validateWatchSpecs() helper checks the specs on a watch against the current spec
+// schema, recording any problems in the validator.
+func (app *application) validateWatchSpecs(watch *data.Watches, v *validator.Validator) error {
+	if len(watch.Specs) == 0 {
+		return nil
+	}
+	schema, err := app.models.Specs.GetSchema()
+	if err != nil {
+		return err
+	}
+	data.ValidateSpecs(v, watch.Specs, schema)
+	return nil
+}
+
+// The readSpecFilters() helper collects the "specs.*" query string parameters into
+// filters, checking them against the spec schema. The schema is only loaded when at
+// least one such parameter is present.
+func (app *application) readSpecFilters(qs url.Values, v *validator.Validator) ([]*data.SpecFilter, error) {
+	var schema data.SpecSchema
+	filters := []*data.SpecFilter{}
+	for key, values := range qs {
+		name, found := strings.CutPrefix(key, "specs.")
+		if !found {
+			continue
+		}
+		if schema == nil {
+			var err error
+			schema, err = app.models.Specs.GetSchema()
+			if err != nil {
+				return nil, err
+			}
+		}
+		if filter := data.ParseSpecFilter(v, schema, name, values[0]); filter != nil {
+			filters = append(filters, filter)
+		}
+	}
+	return filters, nil
+}
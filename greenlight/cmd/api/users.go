@@ -51,8 +51,8 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	// Add the "movies:read" permission for the new user.
-	err = app.models.Permissions.AddForUser(user.ID, "movies:read")
+	// Add the "watches:read" permission for the new user, and let them place orders.
+	err = app.models.Permissions.AddForUser(user.ID, "watches:read", "orders:create")
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
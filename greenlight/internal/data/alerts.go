@@ -0,0 +1,323 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+// The kinds of alert a user can subscribe to.
+const (
+	AlertPriceDrop   = "price_drop"
+	AlertBackInStock = "back_in_stock"
+)
+
+// Alert subscribes a user to news about either a single watch, or every watch that
+// matches Filter. A price-drop alert with a TargetPrice fires when the price falls to
+// or below the target; without one it fires on any reduction.
+type Alert struct {
+	ID          int64        `json:"id"`
+	CreatedAt   time.Time    `json:"created_at"`
+	UserID      int64        `json:"-"`
+	Type        string       `json:"type"`
+	WatchID     *int64       `json:"watch_id,omitempty"`
+	Filter      *AlertFilter `json:"filter,omitempty"`
+	TargetPrice *Money       `json:"target_price,omitempty"`
+}
+
+// AlertFilter matches watches in the same way as the title, brand and material
+// parameters of the watch list.
+type AlertFilter struct {
+	Title    string `json:"title,omitempty"`
+	Brand    string `json:"brand,omitempty"`
+	Material string `json:"material,omitempty"`
+}
+
+// AlertNotification is a queued message about an alert that has fired.
+type AlertNotification struct {
+	ID         int64
+	CreatedAt  time.Time
+	AlertID    int64
+	WatchID    int64
+	WatchTitle string
+	Type       string
+	Price      Money
+}
+
+// AlertDigest is the set of queued notifications to send to one user in one email.
+type AlertDigest struct {
+	UserID        int64
+	Name          string
+	Email         string
+	Notifications []*AlertNotification
+}
+
+func ValidateAlert(v *validator.Validator, alert *Alert) {
+	v.Check(validator.In(alert.Type, AlertPriceDrop, AlertBackInStock), "type", "must be price_drop or back_in_stock")
+
+	hasFilter := alert.Filter != nil && *alert.Filter != AlertFilter{}
+	v.Check(alert.WatchID != nil || hasFilter, "watch_id", "either watch_id or filter must be provided")
+	v.Check(alert.WatchID == nil || !hasFilter, "watch_id", "must not be provided together with filter")
+	if alert.WatchID != nil {
+		v.Check(*alert.WatchID > 0, "watch_id", "must be a positive integer")
+	}
+	if hasFilter {
+		v.Check(len(alert.Filter.Title) <= 500, "filter.title", "must not be more than 500 bytes long")
+	}
+
+	if alert.TargetPrice != nil {
+		v.Check(alert.Type == AlertPriceDrop, "target_price", "can only be set on price_drop alerts")
+		ValidateMoney(v, "target_price", *alert.TargetPrice)
+	}
+}
+
+type AlertModel struct {
+	DB *sql.DB
+}
+
+// Insert saves an alert. Brand and material filters are stored as slugs, to match the
+// way the watch list filters them. It returns ErrRecordNotFound if the watch doesn't
+// exist.
+func (m AlertModel) Insert(alert *Alert) error {
+	query := `
+INSERT INTO alerts (user_id, type, watch_id, filter_title, filter_brand, filter_material, target_amount, target_currency)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+RETURNING id, created_at`
+
+	var filter AlertFilter
+	if alert.Filter != nil {
+		filter = *alert.Filter
+		filter.Brand = Slugify(filter.Brand)
+		filter.Material = Slugify(filter.Material)
+	}
+	var targetAmount *int64
+	var targetCurrency *string
+	if alert.TargetPrice != nil {
+		targetAmount = &alert.TargetPrice.Amount
+		targetCurrency = &alert.TargetPrice.Currency
+	}
+	args := []interface{}{alert.UserID, alert.Type, alert.WatchID, filter.Title, filter.Brand, filter.Material, targetAmount, targetCurrency}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&alert.ID, &alert.CreatedAt)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23503" {
+			return ErrRecordNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// GetAll lists a user's alerts.
+func (m AlertModel) GetAll(userID int64, filters Filters) ([]*Alert, Metadata, error) {
+	query := fmt.Sprintf(`
+SELECT count(*) OVER(), id, created_at, user_id, type, watch_id, filter_title, filter_brand, filter_material, target_amount, target_currency
+FROM alerts
+WHERE user_id = $1
+ORDER BY %s %s, id ASC
+LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	alerts := []*Alert{}
+	for rows.Next() {
+		var alert Alert
+		var filter AlertFilter
+		var targetAmount sql.NullInt64
+		var targetCurrency sql.NullString
+		err := rows.Scan(&totalRecords, &alert.ID, &alert.CreatedAt, &alert.UserID, &alert.Type, &alert.WatchID,
+			&filter.Title, &filter.Brand, &filter.Material, &targetAmount, &targetCurrency)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		if filter != (AlertFilter{}) {
+			alert.Filter = &filter
+		}
+		if targetAmount.Valid {
+			alert.TargetPrice = &Money{Amount: targetAmount.Int64, Currency: targetCurrency.String}
+		}
+		alerts = append(alerts, &alert)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return alerts, metadata, nil
+}
+
+// Delete removes an alert. When userID is 0 the owner isn't checked, which is used for
+// the signed unsubscribe links in alert emails.
+func (m AlertModel) Delete(id, userID int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+	query := `
+DELETE FROM alerts
+WHERE id = $1 AND (user_id = $2 OR $2 = 0)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// GetDigests returns the queued notifications, grouped by user, for every user who
+// hasn't been sent an alert email within the throttle period.
+func (m AlertModel) GetDigests(throttle time.Duration) ([]*AlertDigest, error) {
+	query := `
+SELECT n.id, n.created_at, n.alert_id, n.watch_id, watches.title, n.type, n.price_amount, n.price_currency,
+	users.id, users.name, users.email
+FROM alert_notifications n
+INNER JOIN users ON users.id = n.user_id
+INNER JOIN watches ON watches.id = n.watch_id
+WHERE n.sent_at IS NULL
+AND NOT EXISTS (
+	SELECT 1 FROM alert_notifications sent
+	WHERE sent.user_id = n.user_id AND sent.sent_at > NOW() - $1 * interval '1 second'
+)
+ORDER BY users.id, n.created_at, n.id
+LIMIT 1000`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, int64(throttle.Seconds()))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	digests := []*AlertDigest{}
+	var digest *AlertDigest
+	for rows.Next() {
+		var n AlertNotification
+		var user AlertDigest
+		err := rows.Scan(&n.ID, &n.CreatedAt, &n.AlertID, &n.WatchID, &n.WatchTitle, &n.Type, &n.Price.Amount, &n.Price.Currency,
+			&user.UserID, &user.Name, &user.Email)
+		if err != nil {
+			return nil, err
+		}
+		if digest == nil || digest.UserID != user.UserID {
+			digest = &user
+			digests = append(digests, digest)
+		}
+		digest.Notifications = append(digest.Notifications, &n)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return digests, nil
+}
+
+// MarkSent records that notifications have been emailed.
+func (m AlertModel) MarkSent(notifications ...*AlertNotification) error {
+	ids := make([]int64, len(notifications))
+	for i, n := range notifications {
+		ids[i] = n.ID
+	}
+	query := `
+UPDATE alert_notifications
+SET sent_at = NOW()
+WHERE id = ANY($1)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, pq.Array(ids))
+	return err
+}
+
+// watchState is the part of a watch that alerts fire on. A watch that isn't
+// published can't be bought, so publishing one can bring it back in stock.
+type watchState struct {
+	Price     Money
+	Published bool
+	Stock     int
+	Reserved  int
+}
+
+func (s watchState) available() bool {
+	return s.Published && s.Stock > s.Reserved
+}
+
+func readWatchState(ctx context.Context, tx *sql.Tx, watchID int64) (watchState, error) {
+	query := fmt.Sprintf(`
+SELECT price_amount, price_currency, status = 'published', %s, %s
+FROM watches
+WHERE id = $1`, watchStockSQL, watchReservedSQL)
+
+	var state watchState
+	err := tx.QueryRowContext(ctx, query, watchID).Scan(&state.Price.Amount, &state.Price.Currency, &state.Published, &state.Stock, &state.Reserved)
+	return state, err
+}
+
+// alertMatchSQL matches the alerts that apply to the watch in the query, either
+// directly or through their filter.
+var alertMatchSQL = fmt.Sprintf(`(alerts.watch_id = watches.id OR (alerts.watch_id IS NULL
+	AND (alerts.filter_title = '' OR to_tsvector('simple', watches.title) @@ plainto_tsquery('simple', alerts.filter_title))
+	AND (alerts.filter_brand = '' OR %s)
+	AND (alerts.filter_material = '' OR %s)))`,
+	brandsTable.watchFilterSQL("alerts.filter_brand"), materialsTable.watchFilterSQL("alerts.filter_material"))
+
+// queueAlerts compares a watch with its state before a change made in tx, and queues
+// notifications for the price-drop and back-in-stock alerts that the change fires.
+// It is called by every method that can change a watch's price or availability.
+func queueAlerts(ctx context.Context, tx *sql.Tx, watchID int64, before watchState) error {
+	after, err := readWatchState(ctx, tx, watchID)
+	if err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf(`
+INSERT INTO alert_notifications (alert_id, user_id, watch_id, type, price_amount, price_currency)
+SELECT alerts.id, alerts.user_id, watches.id, alerts.type, watches.price_amount, watches.price_currency
+FROM alerts
+INNER JOIN watches ON watches.id = $1
+WHERE alerts.type = $2 AND watches.status = 'published' AND %s`, alertMatchSQL)
+
+	if after.Price.Currency == before.Price.Currency && after.Price.Amount < before.Price.Amount {
+		priceDrop := query + `
+AND (alerts.target_amount IS NULL
+	OR (alerts.target_currency = watches.price_currency AND watches.price_amount <= alerts.target_amount AND $3 > alerts.target_amount))`
+		_, err = tx.ExecContext(ctx, priceDrop, watchID, AlertPriceDrop, before.Price.Amount)
+		if err != nil {
+			return err
+		}
+	}
+	if !before.available() && after.available() {
+		_, err = tx.ExecContext(ctx, query, watchID, AlertBackInStock)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
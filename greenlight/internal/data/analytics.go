@@ -0,0 +1,277 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+// The groupings and periods that the analytics queries accept. They are interpolated
+// into the SQL, so they are safelisted here rather than taken from the request.
+var (
+	AnalyticsGroups    = []string{"brand", "material", "year"}
+	AnalyticsIntervals = []string{"day", "week", "month", "quarter", "year"}
+)
+
+// analyticsIntervalSteps is the length of each analytics interval as a Postgres
+// interval, which has no quarters.
+var analyticsIntervalSteps = map[string]string{
+	"day": "1 day", "week": "1 week", "month": "1 month", "quarter": "3 months", "year": "1 year",
+}
+
+// PriceStats summarizes the prices of a group of watches. Like all the prices in the
+// analytics, they are in major units of BaseCurrency, converted with the latest
+// exchange rates; watches priced in a currency with no known rate are left out.
+type PriceStats struct {
+	Group  string  `json:"group"`
+	Count  int     `json:"count"`
+	Min    float64 `json:"min"`
+	Max    float64 `json:"max"`
+	Avg    float64 `json:"avg"`
+	Median float64 `json:"median"`
+}
+
+// PriceBucket is one bar of a price histogram, counting the watches priced from From
+// up to To. The last bucket includes its upper bound.
+type PriceBucket struct {
+	From  float64 `json:"from"`
+	To    float64 `json:"to"`
+	Count int     `json:"count"`
+}
+
+type YearCount struct {
+	Year  int32 `json:"year"`
+	Count int   `json:"count"`
+}
+
+// TrendPoint summarizes the prices that the watches in the catalog had at the end of
+// one period.
+type TrendPoint struct {
+	Period time.Time `json:"period"`
+	Count  int       `json:"count"`
+	Avg    float64   `json:"avg"`
+	Median float64   `json:"median"`
+}
+
+type AnalyticsModel struct {
+	DB *sql.DB
+}
+
+// analyticsGroupSQL returns the joins and the grouping expression for a grouping. A
+// watch with several brands or materials is counted in each of them.
+func analyticsGroupSQL(group string) (joins, expression string) {
+	switch group {
+	case "brand":
+		return `INNER JOIN watches_brands ON watches_brands.watch_id = watches.id
+INNER JOIN brands ON brands.id = watches_brands.brand_id`, "brands.name"
+	case "material":
+		return `INNER JOIN watches_materials ON watches_materials.watch_id = watches.id
+INNER JOIN materials ON materials.id = watches_materials.material_id`, "materials.name"
+	case "year":
+		return "", "watches.year::text"
+	}
+	return "", "'all'"
+}
+
+// PriceStats returns the count, minimum, maximum, average and median price of the
+// watches matching q, either overall (group "") or per brand, material or year.
+func (m AnalyticsModel) PriceStats(q WatchesQuery, group string) ([]*PriceStats, error) {
+	args := []interface{}{}
+	where := q.where(&args)
+	joins, expression := analyticsGroupSQL(group)
+	price := priceInBaseCurrencySQL()
+	query := fmt.Sprintf(`
+SELECT %[1]s AS analytics_group, count(*), min(%[2]s)::float8, max(%[2]s)::float8, avg(%[2]s)::float8,
+	percentile_cont(0.5) WITHIN GROUP (ORDER BY %[2]s)
+FROM watches %[3]s
+%[4]s
+WHERE %[5]s AND %[2]s IS NOT NULL
+GROUP BY analytics_group
+ORDER BY analytics_group`, expression, price, latestRateJoinSQL, joins, where)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := []*PriceStats{}
+	for rows.Next() {
+		var s PriceStats
+		err := rows.Scan(&s.Group, &s.Count, &s.Min, &s.Max, &s.Avg, &s.Median)
+		if err != nil {
+			return nil, err
+		}
+		stats = append(stats, &s)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// PriceHistogram splits the price range of the watches matching q into equal buckets
+// and counts the watches in each. Empty buckets are included, so the result always
+// has the requested number of buckets unless no watches match.
+func (m AnalyticsModel) PriceHistogram(q WatchesQuery, buckets int) ([]*PriceBucket, error) {
+	args := []interface{}{}
+	where := q.where(&args)
+	args = append(args, buckets)
+	// width_bucket() needs a non-empty range, so when every price is the same the
+	// range is widened to one unit.
+	query := fmt.Sprintf(`
+WITH prices AS (
+	SELECT %[1]s AS price
+	FROM watches %[2]s
+	WHERE %[3]s AND %[1]s IS NOT NULL
+), bounds AS (
+	SELECT min(price) AS lo, CASE WHEN max(price) = min(price) THEN max(price) + 1 ELSE max(price) END AS hi
+	FROM prices
+)
+SELECT (bounds.lo + (bounds.hi - bounds.lo) * (bucket - 1) / $%[4]d)::float8,
+	(bounds.lo + (bounds.hi - bounds.lo) * bucket / $%[4]d)::float8,
+	count(prices.price)
+FROM bounds
+CROSS JOIN generate_series(1, $%[4]d) AS bucket
+LEFT JOIN prices ON LEAST(width_bucket(prices.price, bounds.lo, bounds.hi, $%[4]d), $%[4]d) = bucket
+WHERE bounds.lo IS NOT NULL
+GROUP BY bucket, bounds.lo, bounds.hi
+ORDER BY bucket`, priceInBaseCurrencySQL(), latestRateJoinSQL, where, len(args))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	histogram := []*PriceBucket{}
+	for rows.Next() {
+		var b PriceBucket
+		err := rows.Scan(&b.From, &b.To, &b.Count)
+		if err != nil {
+			return nil, err
+		}
+		histogram = append(histogram, &b)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return histogram, nil
+}
+
+// CountsByYear counts the watches matching q by model year.
+func (m AnalyticsModel) CountsByYear(q WatchesQuery) ([]*YearCount, error) {
+	args := []interface{}{}
+	where := q.where(&args)
+	query := fmt.Sprintf(`
+SELECT year, count(*)
+FROM watches
+WHERE %s
+GROUP BY year
+ORDER BY year`, where)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := []*YearCount{}
+	for rows.Next() {
+		var c YearCount
+		err := rows.Scan(&c.Year, &c.Count)
+		if err != nil {
+			return nil, err
+		}
+		counts = append(counts, &c)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
+
+// PriceTrend returns the average and median price of the watches matching q at the end
+// of each day, week, month, quarter or year since the first of them was added. The
+// price a watch had at the end of a period comes from its price history: the new
+// price of the last change before then or, failing that, the old price of the first
+// change after. A watch with no changes has always had its current price.
+func (m AnalyticsModel) PriceTrend(q WatchesQuery, interval string) ([]*TrendPoint, error) {
+	if !validator.In(interval, AnalyticsIntervals...) {
+		panic("unsafe analytics interval: " + interval)
+	}
+	args := []interface{}{}
+	where := q.where(&args)
+	price := priceInBaseCurrencySQL()
+	// The prices at each period end are selected as "watches" so that the currency
+	// conversion in priceInBaseCurrencySQL() and latestRateJoinSQL applies to them.
+	query := fmt.Sprintf(`
+WITH matching AS (
+	SELECT watches.id, watches.created_at, watches.price_amount, watches.price_currency
+	FROM watches
+	WHERE %[4]s
+), periods AS (
+	SELECT generate_series(date_trunc('%[1]s', min(created_at)), date_trunc('%[1]s', NOW()), interval '%[5]s') AS period
+	FROM matching
+)
+SELECT period, count(*), avg(%[2]s)::float8, percentile_cont(0.5) WITHIN GROUP (ORDER BY %[2]s)
+FROM (
+	SELECT periods.period,
+		COALESCE(earlier.amount, later.amount, matching.price_amount) AS price_amount,
+		COALESCE(earlier.currency, later.currency, matching.price_currency) AS price_currency
+	FROM periods
+	INNER JOIN matching ON matching.created_at < periods.period + interval '%[5]s'
+	LEFT JOIN LATERAL (
+		SELECT new_amount AS amount, new_currency AS currency
+		FROM price_history
+		WHERE price_history.watch_id = matching.id AND price_history.changed_at < periods.period + interval '%[5]s'
+		ORDER BY price_history.changed_at DESC, price_history.id DESC
+		LIMIT 1
+	) earlier ON true
+	LEFT JOIN LATERAL (
+		SELECT old_amount AS amount, old_currency AS currency
+		FROM price_history
+		WHERE price_history.watch_id = matching.id AND price_history.changed_at >= periods.period + interval '%[5]s'
+		ORDER BY price_history.changed_at, price_history.id
+		LIMIT 1
+	) later ON true
+) watches %[3]s
+WHERE %[2]s IS NOT NULL
+GROUP BY period
+ORDER BY period`, interval, price, latestRateJoinSQL, where, analyticsIntervalSteps[interval])
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	trend := []*TrendPoint{}
+	for rows.Next() {
+		var p TrendPoint
+		err := rows.Scan(&p.Period, &p.Count, &p.Avg, &p.Median)
+		if err != nil {
+			return nil, err
+		}
+		trend = append(trend, &p)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return trend, nil
+}
@@ -0,0 +1,580 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+var (
+	ErrAuctionNotOpen = errors.New("auction is not open for bidding")
+	ErrBidTooLow      = errors.New("bid is too low")
+	ErrDuplicateBid   = errors.New("a bid has already been placed at this amount")
+)
+
+// The states that an auction can be in. Only open, closed and cancelled are stored;
+// an open auction is reported as scheduled before it starts, and as ended after it
+// ends but before the closing job has picked a winner.
+const (
+	AuctionScheduled = "scheduled"
+	AuctionOpen      = "open"
+	AuctionEnded     = "ended"
+	AuctionClosed    = "closed"
+	AuctionCancelled = "cancelled"
+)
+
+var AuctionStatuses = []string{AuctionScheduled, AuctionOpen, AuctionEnded, AuctionClosed, AuctionCancelled}
+
+// auctionStatusSQL works out the reported state of an auction.
+const auctionStatusSQL = `CASE
+	WHEN auctions.status = 'open' AND NOW() < auctions.starts_at THEN 'scheduled'
+	WHEN auctions.status = 'open' AND NOW() >= auctions.ends_at THEN 'ended'
+	ELSE auctions.status END`
+
+// Auction is a lot selling a single watch. A bid placed less than ExtensionWindow
+// seconds before the end pushes the end back to Extension seconds after the bid, so
+// that other bidders have a chance to respond. The reserve price isn't shown to
+// bidders; ReserveMet reports whether the current bid has reached it.
+type Auction struct {
+	ID              int64     `json:"id"`
+	CreatedAt       time.Time `json:"created_at"`
+	WatchID         int64     `json:"watch_id"`
+	StartsAt        time.Time `json:"starts_at"`
+	EndsAt          time.Time `json:"ends_at"`
+	StartingPrice   Money     `json:"starting_price"`
+	ReservePrice    Money     `json:"-"`
+	MinIncrement    Money     `json:"min_increment"`
+	ExtensionWindow int32     `json:"extension_window_seconds"`
+	Extension       int32     `json:"extension_seconds"`
+	Status          string    `json:"status"`
+	CurrentBid      *Money    `json:"current_bid,omitempty"`
+	BidCount        int       `json:"bid_count"`
+	ReserveMet      bool      `json:"reserve_met"`
+	WinningBidID    *int64    `json:"winning_bid_id,omitempty"`
+	Version         int32     `json:"version"`
+}
+
+// MinimumBid returns the lowest amount that the next bid may be.
+func (a *Auction) MinimumBid() Money {
+	if a.CurrentBid == nil {
+		return a.StartingPrice
+	}
+	return Money{Amount: a.CurrentBid.Amount + a.MinIncrement.Amount, Currency: a.CurrentBid.Currency}
+}
+
+type Bid struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	AuctionID int64     `json:"auction_id"`
+	UserID    int64     `json:"-"`
+	Amount    Money     `json:"amount"`
+}
+
+// Bidder identifies the user behind a bid, for sending them notifications.
+type Bidder struct {
+	UserID int64
+	Name   string
+	Email  string
+	Amount Money
+}
+
+// AuctionResult is the outcome of an auction closed by CloseDue(). Winner is nil if
+// there were no bids, or the reserve price wasn't met; otherwise Order is the pending
+// order placed for the winner.
+type AuctionResult struct {
+	AuctionID int64
+	WatchID   int64
+	Winner    *Bidder
+	Order     *Order
+}
+
+func ValidateAuction(v *validator.Validator, auction *Auction) {
+	v.Check(auction.WatchID > 0, "watch_id", "must be provided")
+	v.Check(!auction.StartsAt.IsZero(), "starts_at", "must be provided")
+	v.Check(!auction.EndsAt.IsZero(), "ends_at", "must be provided")
+	v.Check(auction.EndsAt.After(auction.StartsAt), "ends_at", "must be after starts_at")
+	v.Check(auction.EndsAt.After(time.Now()), "ends_at", "must be in the future")
+
+	ValidateMoney(v, "starting_price", auction.StartingPrice)
+	ValidateMoney(v, "min_increment", auction.MinIncrement)
+	v.Check(auction.MinIncrement.Amount > 0, "min_increment", "must be greater than zero")
+	v.Check(auction.MinIncrement.Currency == auction.StartingPrice.Currency, "min_increment", "must be in the same currency as starting_price")
+	if auction.ReservePrice.Currency != "" {
+		ValidateMoney(v, "reserve_price", auction.ReservePrice)
+		v.Check(auction.ReservePrice.Currency == auction.StartingPrice.Currency, "reserve_price", "must be in the same currency as starting_price")
+	}
+
+	v.Check(auction.ExtensionWindow >= 0, "extension_window_seconds", "must not be negative")
+	v.Check(auction.ExtensionWindow <= 3600, "extension_window_seconds", "must not be more than an hour")
+	v.Check(auction.Extension >= 0, "extension_seconds", "must not be negative")
+	v.Check(auction.Extension <= 3600, "extension_seconds", "must not be more than an hour")
+}
+
+func ValidateBid(v *validator.Validator, bid *Bid) {
+	ValidateMoney(v, "amount", bid.Amount)
+	v.Check(bid.Amount.Amount > 0, "amount", "must be greater than zero")
+}
+
+type AuctionModel struct {
+	DB *sql.DB
+}
+
+// Insert creates an open auction, which reserves a unit of the watch until it is
+// closed or cancelled. It returns ErrRecordNotFound if the watch doesn't exist and
+// ErrNoUnitsAvailable if it isn't published or has no unit to spare. As in
+// HoldModel.Insert, the watch row is locked while the units are counted.
+func (m AuctionModel) Insert(auction *Auction) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var available bool
+	query := fmt.Sprintf(`SELECT %s FROM watches WHERE id = $1 FOR UPDATE`, watchAvailableSQL)
+	err = tx.QueryRowContext(ctx, query, auction.WatchID).Scan(&available)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	if !available {
+		return ErrNoUnitsAvailable
+	}
+	before, err := readWatchState(ctx, tx, auction.WatchID)
+	if err != nil {
+		return err
+	}
+
+	query = `
+INSERT INTO auctions (watch_id, starts_at, ends_at, starting_price, reserve_price, currency, min_increment, extension_window, extension)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+RETURNING id, created_at, version`
+
+	args := []interface{}{
+		auction.WatchID,
+		auction.StartsAt,
+		auction.EndsAt,
+		auction.StartingPrice.Amount,
+		auction.ReservePrice.Amount,
+		auction.StartingPrice.Currency,
+		auction.MinIncrement.Amount,
+		auction.ExtensionWindow,
+		auction.Extension,
+	}
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&auction.ID, &auction.CreatedAt, &auction.Version)
+	if err != nil {
+		return err
+	}
+	err = queueAlerts(ctx, tx, auction.WatchID, before)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	auction.Status = AuctionOpen
+	if time.Now().Before(auction.StartsAt) {
+		auction.Status = AuctionScheduled
+	}
+	return nil
+}
+
+var auctionColumnsSQL = fmt.Sprintf(`auctions.id, auctions.created_at, auctions.watch_id, auctions.starts_at,
+	auctions.ends_at, auctions.starting_price, auctions.reserve_price, auctions.currency, auctions.min_increment,
+	auctions.extension_window, auctions.extension, %s,
+	(SELECT max(bids.amount) FROM bids WHERE bids.auction_id = auctions.id),
+	(SELECT count(*) FROM bids WHERE bids.auction_id = auctions.id),
+	auctions.winning_bid_id, auctions.version`, auctionStatusSQL)
+
+// auctionRow holds the destinations for scanning an auction, including the columns
+// that need converting afterwards.
+type auctionRow struct {
+	Auction
+	currency   string
+	currentBid sql.NullInt64
+}
+
+func (r *auctionRow) scanFields() []interface{} {
+	a := &r.Auction
+	return []interface{}{
+		&a.ID, &a.CreatedAt, &a.WatchID, &a.StartsAt, &a.EndsAt,
+		&a.StartingPrice.Amount, &a.ReservePrice.Amount, &r.currency, &a.MinIncrement.Amount,
+		&a.ExtensionWindow, &a.Extension, &a.Status, &r.currentBid, &a.BidCount, &a.WinningBidID, &a.Version,
+	}
+}
+
+func (r *auctionRow) auction() *Auction {
+	a := r.Auction
+	a.StartingPrice.Currency = r.currency
+	a.ReservePrice.Currency = r.currency
+	a.MinIncrement.Currency = r.currency
+	if r.currentBid.Valid {
+		a.CurrentBid = &Money{Amount: r.currentBid.Int64, Currency: r.currency}
+		a.ReserveMet = r.currentBid.Int64 >= a.ReservePrice.Amount
+	}
+	return &a
+}
+
+func (m AuctionModel) Get(id int64) (*Auction, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := fmt.Sprintf(`
+SELECT %s
+FROM auctions
+WHERE auctions.id = $1`, auctionColumnsSQL)
+
+	var row auctionRow
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(row.scanFields()...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return row.auction(), nil
+}
+
+// GetAll lists the auctions of published watches, optionally for a single watch
+// (watchID 0 means any) and in a single state.
+func (m AuctionModel) GetAll(watchID int64, status string, filters Filters) ([]*Auction, Metadata, error) {
+	query := fmt.Sprintf(`
+SELECT count(*) OVER(), %s
+FROM auctions
+WHERE (auctions.watch_id = $1 OR $1 = 0)
+AND (%s = $2 OR $2 = '')
+AND EXISTS (SELECT 1 FROM watches WHERE watches.id = auctions.watch_id AND watches.status = 'published')
+ORDER BY auctions.%s %s, auctions.id ASC
+LIMIT $3 OFFSET $4`, auctionColumnsSQL, auctionStatusSQL, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, watchID, status, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	auctions := []*Auction{}
+	for rows.Next() {
+		var row auctionRow
+		err := rows.Scan(append([]interface{}{&totalRecords}, row.scanFields()...)...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		auctions = append(auctions, row.auction())
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return auctions, metadata, nil
+}
+
+// Cancel withdraws an auction that hasn't ended, using the version number to detect
+// concurrent changes such as a new bid. An auction that has ended is left for
+// CloseDue() to settle. Cancelling releases the unit that the auction reserved.
+func (m AuctionModel) Cancel(auction *Auction) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	before, err := readWatchState(ctx, tx, auction.WatchID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+UPDATE auctions
+SET status = 'cancelled', version = version + 1
+WHERE id = $1 AND version = $2 AND status = 'open' AND NOW() < ends_at
+RETURNING version`
+
+	err = tx.QueryRowContext(ctx, query, auction.ID, auction.Version).Scan(&auction.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	err = queueAlerts(ctx, tx, auction.WatchID, before)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	auction.Status = AuctionCancelled
+	return nil
+}
+
+// PlaceBid records a bid, and returns the auction as it stands afterwards along with
+// the bidder who has just been outbid, if any. Bids on the same auction are
+// serialized by locking the auction row, so every accepted bid is at least the
+// minimum increment above the one before it. It returns ErrAuctionNotOpen outside the
+// bidding period, and an error wrapping ErrBidTooLow that gives the minimum bid.
+func (m AuctionModel) PlaceBid(bid *Bid) (*Auction, *Bidder, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	query := fmt.Sprintf(`
+SELECT %s
+FROM auctions
+WHERE auctions.id = $1
+FOR UPDATE`, auctionColumnsSQL)
+
+	var row auctionRow
+	err = tx.QueryRowContext(ctx, query, bid.AuctionID).Scan(row.scanFields()...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, ErrRecordNotFound
+		default:
+			return nil, nil, err
+		}
+	}
+	auction := row.auction()
+	if auction.Status != AuctionOpen {
+		return nil, nil, ErrAuctionNotOpen
+	}
+	if bid.Amount.Currency != auction.StartingPrice.Currency {
+		return nil, nil, fmt.Errorf("%w: bids must be in %s", ErrBidTooLow, auction.StartingPrice.Currency)
+	}
+	if minimum := auction.MinimumBid(); bid.Amount.Amount < minimum.Amount {
+		return nil, nil, fmt.Errorf("%w: the minimum bid is %s", ErrBidTooLow, minimum)
+	}
+
+	var outbid *Bidder
+	if auction.CurrentBid != nil {
+		query = `
+SELECT users.id, users.name, users.email, bids.amount
+FROM bids
+INNER JOIN users ON users.id = bids.user_id
+WHERE bids.auction_id = $1
+ORDER BY bids.amount DESC
+LIMIT 1`
+		outbid = &Bidder{Amount: Money{Currency: auction.StartingPrice.Currency}}
+		err = tx.QueryRowContext(ctx, query, auction.ID).Scan(&outbid.UserID, &outbid.Name, &outbid.Email, &outbid.Amount.Amount)
+		if err != nil {
+			return nil, nil, err
+		}
+		if outbid.UserID == bid.UserID {
+			outbid = nil
+		}
+	}
+
+	query = `
+INSERT INTO bids (auction_id, user_id, amount)
+VALUES ($1, $2, $3)
+RETURNING id, created_at`
+
+	err = tx.QueryRowContext(ctx, query, bid.AuctionID, bid.UserID, bid.Amount.Amount).Scan(&bid.ID, &bid.CreatedAt)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return nil, nil, ErrDuplicateBid
+		}
+		return nil, nil, err
+	}
+
+	// Extend the auction if the bid came in during the anti-sniping window.
+	extendedEnd := bid.CreatedAt.Add(time.Duration(auction.Extension) * time.Second)
+	if auction.EndsAt.Sub(bid.CreatedAt) < time.Duration(auction.ExtensionWindow)*time.Second && extendedEnd.After(auction.EndsAt) {
+		auction.EndsAt = extendedEnd
+	}
+
+	query = `
+UPDATE auctions
+SET ends_at = $1, version = version + 1
+WHERE id = $2
+RETURNING version`
+
+	err = tx.QueryRowContext(ctx, query, auction.EndsAt, auction.ID).Scan(&auction.Version)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	auction.CurrentBid = &bid.Amount
+	auction.BidCount++
+	auction.ReserveMet = bid.Amount.Amount >= auction.ReservePrice.Amount
+	return auction, outbid, nil
+}
+
+// GetBids lists the bids on an auction.
+func (m AuctionModel) GetBids(auctionID int64, filters Filters) ([]*Bid, Metadata, error) {
+	query := fmt.Sprintf(`
+SELECT count(*) OVER(), bids.id, bids.created_at, bids.auction_id, bids.user_id, bids.amount, auctions.currency
+FROM bids
+INNER JOIN auctions ON auctions.id = bids.auction_id
+WHERE bids.auction_id = $1
+ORDER BY bids.%s %s, bids.id ASC
+LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, auctionID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	bids := []*Bid{}
+	for rows.Next() {
+		var bid Bid
+		err := rows.Scan(&totalRecords, &bid.ID, &bid.CreatedAt, &bid.AuctionID, &bid.UserID, &bid.Amount.Amount, &bid.Amount.Currency)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		bids = append(bids, &bid)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return bids, metadata, nil
+}
+
+// CloseDue closes the open auctions that have ended, recording the highest bid as the
+// winner if it meets the reserve price. The winner gets a pending order for the watch
+// at their bid, which takes over the unit that the auction reserved and must be paid
+// within paymentDuration. An auction without a winner releases its unit. Auctions
+// being bid on at the same moment are skipped and picked up on the next run.
+func (m AuctionModel) CloseDue(paymentDuration time.Duration) ([]*AuctionResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+SELECT id, watch_id, reserve_price, currency
+FROM auctions
+WHERE status = 'open' AND ends_at <= NOW()
+ORDER BY ends_at
+LIMIT 100
+FOR UPDATE SKIP LOCKED`
+
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	var due []*AuctionResult
+	reserves := make(map[int64]Money)
+	for rows.Next() {
+		var result AuctionResult
+		var reserve Money
+		err := rows.Scan(&result.AuctionID, &result.WatchID, &reserve.Amount, &reserve.Currency)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		due = append(due, &result)
+		reserves[result.AuctionID] = reserve
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, result := range due {
+		before, err := readWatchState(ctx, tx, result.WatchID)
+		if err != nil {
+			return nil, err
+		}
+
+		query = `
+SELECT bids.id, users.id, users.name, users.email, bids.amount
+FROM bids
+INNER JOIN users ON users.id = bids.user_id
+WHERE bids.auction_id = $1
+ORDER BY bids.amount DESC
+LIMIT 1`
+
+		var winningBidID *int64
+		var bidID int64
+		winner := &Bidder{Amount: Money{Currency: reserves[result.AuctionID].Currency}}
+		err = tx.QueryRowContext(ctx, query, result.AuctionID).Scan(&bidID, &winner.UserID, &winner.Name, &winner.Email, &winner.Amount.Amount)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+		case err != nil:
+			return nil, err
+		case winner.Amount.Amount >= reserves[result.AuctionID].Amount:
+			winningBidID = &bidID
+			result.Winner = winner
+		}
+
+		query = `
+UPDATE auctions
+SET status = 'closed', winning_bid_id = $1, version = version + 1
+WHERE id = $2`
+
+		_, err = tx.ExecContext(ctx, query, winningBidID, result.AuctionID)
+		if err != nil {
+			return nil, err
+		}
+
+		if result.Winner != nil {
+			expiresAt := time.Now().Add(paymentDuration)
+			result.Order = &Order{UserID: result.Winner.UserID, ExpiresAt: &expiresAt}
+			err = insertAuctionOrder(ctx, tx, result.Order, result.AuctionID, result.WatchID, result.Winner.Amount)
+			if err != nil {
+				return nil, err
+			}
+		}
+		err = queueAlerts(ctx, tx, result.WatchID, before)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return due, nil
+}
@@ -0,0 +1,94 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// CartModel stores the watches that each user has put in their cart, ready to be
+// ordered with OrderModel.InsertFromCart().
+type CartModel struct {
+	DB *sql.DB
+}
+
+// Add puts a watch in a user's cart. Adding a watch that is already there does
+// nothing. It returns ErrRecordNotFound if the watch doesn't exist.
+func (m CartModel) Add(userID, watchID int64) error {
+	query := `
+INSERT INTO cart_items (user_id, watch_id)
+VALUES ($1, $2)
+ON CONFLICT DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, watchID)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23503" {
+			return ErrRecordNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+func (m CartModel) Remove(userID, watchID int64) error {
+	query := `
+DELETE FROM cart_items
+WHERE user_id = $1 AND watch_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, watchID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// GetAll returns the watches in a user's cart, in the order they were added.
+func (m CartModel) GetAll(userID int64) ([]*Watches, error) {
+	query := fmt.Sprintf(`
+SELECT %s
+FROM cart_items
+INNER JOIN watches ON watches.id = cart_items.watch_id
+WHERE cart_items.user_id = $1
+ORDER BY cart_items.created_at, watches.id`, watchesColumnsSQL())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	watches := []*Watches{}
+	for rows.Next() {
+		var watch Watches
+		err := rows.Scan(watch.scanFields()...)
+		if err != nil {
+			return nil, err
+		}
+		watches = append(watches, &watch)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return watches, nil
+}
@@ -0,0 +1,405 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+var (
+	ErrDuplicateSlug  = errors.New("duplicate slug")
+	ErrDuplicateAlias = errors.New("duplicate alias")
+	ErrEntityInUse    = errors.New("entity is still referenced by watches")
+)
+
+// Accented and other non-ASCII Latin letters are transliterated rather than dropped, so
+// that "A. Lange & Söhne" becomes "a-lange-sohne". Each letter in transliterateFrom is
+// replaced by the letter at the same position in transliterateTo; the brands and
+// materials migration passes the same strings to translate().
+const (
+	transliterateFrom = "àáâãäåāăąçćĉċčďđèéêëēĕėęěĝğġģĥħìíîïĩīĭįıĵķĺļľŀłñńņňòóôõöøōŏőŕŗřśŝşšţťŧùúûüũūŭůűųŵýÿŷźżžð"
+	transliterateTo   = "aaaaaaaaacccccddeeeeeeeeegggghhiiiiiiiiijklllllnnnnooooooooorrrsssstttuuuuuuuuuuwyyyzzzd"
+)
+
+var slugTransliterator = newSlugTransliterator()
+
+func newSlugTransliterator() *strings.Replacer {
+	pairs := []string{"ß", "ss", "æ", "ae", "œ", "oe", "þ", "th"}
+	to := []rune(transliterateTo)
+	for i, r := range []rune(transliterateFrom) {
+		pairs = append(pairs, string(r), string(to[i]))
+	}
+	return strings.NewReplacer(pairs...)
+}
+
+var (
+	slugSeparatorRX = regexp.MustCompile(`[^a-z0-9]+`)
+	// Company suffixes are dropped so that "Rolex" and "ROLEX SA" share a slug.
+	slugSuffixRX = regexp.MustCompile(`-(sa|ag|gmbh|ltd|inc|sarl)$`)
+	SlugRX       = regexp.MustCompile(`^[a-z0-9]+(?:-[a-z0-9]+)*$`)
+)
+
+// Slugify turns a brand or material name into its canonical lowercase, hyphenated
+// form. The brands and materials migration applies the same normalisation.
+func Slugify(name string) string {
+	slug := slugTransliterator.Replace(strings.ToLower(strings.TrimSpace(name)))
+	slug = slugSeparatorRX.ReplaceAllString(slug, "-")
+	slug = strings.Trim(slug, "-")
+	return slugSuffixRX.ReplaceAllString(slug, "")
+}
+
+// CatalogEntity is a canonical brand or material that watches link to.
+type CatalogEntity struct {
+	ID          int64     `json:"id"`
+	CreatedAt   time.Time `json:"-"`
+	Name        string    `json:"name"`
+	Slug        string    `json:"slug"`
+	Aliases     []string  `json:"aliases"`
+	Description string    `json:"description,omitempty"`
+	Version     int32     `json:"version"`
+}
+
+func ValidateCatalogEntity(v *validator.Validator, entity *CatalogEntity) {
+	v.Check(entity.Name != "", "name", "must be provided")
+	v.Check(len(entity.Name) <= 200, "name", "must not be more than 200 bytes long")
+	v.Check(entity.Slug != "", "slug", "must be provided")
+	v.Check(validator.Matches(entity.Slug, SlugRX), "slug", "must only contain lowercase letters, digits and hyphens")
+	v.Check(len(entity.Aliases) <= 20, "aliases", "must not contain more than 20 entries")
+	v.Check(validator.Unique(entity.Aliases), "aliases", "must not contain duplicate values")
+	for _, alias := range entity.Aliases {
+		v.Check(validator.Matches(alias, SlugRX), "aliases", "must only contain slugs")
+		v.Check(alias != entity.Slug, "aliases", "must not repeat the slug")
+	}
+	v.Check(len(entity.Description) <= 2000, "description", "must not be more than 2000 bytes long")
+}
+
+var (
+	brandsTable    = CatalogEntityModel{table: "brands", linkTable: "watches_brands", linkColumn: "brand_id"}
+	materialsTable = CatalogEntityModel{table: "materials", linkTable: "watches_materials", linkColumn: "material_id"}
+)
+
+// CatalogEntityModel works with one of the normalized catalog tables (brands or
+// materials) and the join table that links it to watches. The table and column names
+// come from the brandsTable and materialsTable definitions, never from user input.
+type CatalogEntityModel struct {
+	DB         *sql.DB
+	table      string
+	linkTable  string
+	linkColumn string
+}
+
+func (m CatalogEntityModel) withDB(db *sql.DB) CatalogEntityModel {
+	m.DB = db
+	return m
+}
+
+func (m CatalogEntityModel) Insert(entity *CatalogEntity) error {
+	query := fmt.Sprintf(`
+INSERT INTO %s (name, slug, aliases, description)
+VALUES ($1, $2, $3, $4)
+RETURNING id, created_at, version`, m.table)
+
+	args := []interface{}{entity.Name, entity.Slug, pq.Array(entity.Aliases), entity.Description}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&entity.ID, &entity.CreatedAt, &entity.Version)
+	if err != nil {
+		return m.translateError(err)
+	}
+	return nil
+}
+
+func (m CatalogEntityModel) Get(id int64) (*CatalogEntity, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := fmt.Sprintf(`
+SELECT id, created_at, name, slug, aliases, description, version
+FROM %s
+WHERE id = $1`, m.table)
+
+	var entity CatalogEntity
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&entity.ID,
+		&entity.CreatedAt,
+		&entity.Name,
+		&entity.Slug,
+		pq.Array(&entity.Aliases),
+		&entity.Description,
+		&entity.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &entity, nil
+}
+
+func (m CatalogEntityModel) Update(entity *CatalogEntity) error {
+	query := fmt.Sprintf(`
+UPDATE %s
+SET name = $1, slug = $2, aliases = $3, description = $4, version = version + 1
+WHERE id = $5 AND version = $6
+RETURNING version`, m.table)
+
+	args := []interface{}{entity.Name, entity.Slug, pq.Array(entity.Aliases), entity.Description, entity.ID, entity.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&entity.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return m.translateError(err)
+		}
+	}
+	return nil
+}
+
+// Delete removes an entity. Entities which are still linked to watches can't be
+// deleted and return ErrEntityInUse.
+func (m CatalogEntityModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+	query := fmt.Sprintf(`
+DELETE FROM %s
+WHERE id = $1`, m.table)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return m.translateError(err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+func (m CatalogEntityModel) GetAll(name string, filters Filters) ([]*CatalogEntity, Metadata, error) {
+	query := fmt.Sprintf(`
+SELECT count(*) OVER(), id, created_at, name, slug, aliases, description, version
+FROM %s
+WHERE (name ILIKE '%%' || $1 || '%%' OR $1 = '')
+ORDER BY %s %s, id ASC
+LIMIT $2 OFFSET $3`, m.table, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, name, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	entities := []*CatalogEntity{}
+	for rows.Next() {
+		var entity CatalogEntity
+		err := rows.Scan(
+			&totalRecords,
+			&entity.ID,
+			&entity.CreatedAt,
+			&entity.Name,
+			&entity.Slug,
+			pq.Array(&entity.Aliases),
+			&entity.Description,
+			&entity.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		entities = append(entities, &entity)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return entities, metadata, nil
+}
+
+// Resolve maps free-form names to canonical entities by slug or alias. Names which
+// don't match anything are returned in the unknown slice. Names that resolve to the
+// same entity are only returned once. Slugs and aliases are unique across the table
+// (see migration 000031), so each name matches at most one entity.
+func (m CatalogEntityModel) Resolve(names []string) ([]*CatalogEntity, []string, error) {
+	slugs := make([]string, 0, len(names))
+	for _, name := range names {
+		slugs = append(slugs, Slugify(name))
+	}
+
+	query := fmt.Sprintf(`
+SELECT id, created_at, name, slug, aliases, description, version
+FROM %s
+WHERE slug = ANY($1) OR aliases && $1
+ORDER BY id`, m.table)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(slugs))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var matched []*CatalogEntity
+	for rows.Next() {
+		var entity CatalogEntity
+		err := rows.Scan(
+			&entity.ID,
+			&entity.CreatedAt,
+			&entity.Name,
+			&entity.Slug,
+			pq.Array(&entity.Aliases),
+			&entity.Description,
+			&entity.Version,
+		)
+		if err != nil {
+			return nil, nil, err
+		}
+		matched = append(matched, &entity)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	// Slugs take precedence over aliases, in case rows written before the uniqueness
+	// trigger existed still overlap.
+	bySlug := make(map[string]*CatalogEntity)
+	for _, entity := range matched {
+		bySlug[entity.Slug] = entity
+	}
+	for _, entity := range matched {
+		for _, alias := range entity.Aliases {
+			if _, exists := bySlug[alias]; !exists {
+				bySlug[alias] = entity
+			}
+		}
+	}
+
+	var (
+		entities []*CatalogEntity
+		unknown  []string
+		seen     = make(map[int64]bool)
+	)
+	for i, slug := range slugs {
+		entity, found := bySlug[slug]
+		if !found {
+			unknown = append(unknown, names[i])
+			continue
+		}
+		if !seen[entity.ID] {
+			seen[entity.ID] = true
+			entities = append(entities, entity)
+		}
+	}
+	return entities, unknown, nil
+}
+
+// create adds entities for the given names inside an existing transaction, for watches
+// saved with catalog-auto-create enabled, and returns their ids. A slug that was taken
+// since the names were resolved, by a concurrent save for example, resolves to the
+// entity that took it.
+func (m CatalogEntityModel) create(ctx context.Context, tx *sql.Tx, names []string) ([]int64, error) {
+	insert := fmt.Sprintf(`
+INSERT INTO %s (name, slug, aliases, description)
+VALUES ($1, $2, '{}', '')
+ON CONFLICT (slug) DO NOTHING`, m.table)
+	selectID := fmt.Sprintf(`SELECT id FROM %s WHERE slug = $1`, m.table)
+
+	ids := make([]int64, 0, len(names))
+	for _, name := range names {
+		slug := Slugify(name)
+		_, err := tx.ExecContext(ctx, insert, strings.TrimSpace(name), slug)
+		if err != nil {
+			return nil, err
+		}
+		var id int64
+		err = tx.QueryRowContext(ctx, selectID, slug).Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// setLinks replaces the entities linked to a watch inside an existing transaction.
+func (m CatalogEntityModel) setLinks(ctx context.Context, tx *sql.Tx, watchID int64, ids []int64) error {
+	_, err := tx.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE watch_id = $1`, m.linkTable), watchID)
+	if err != nil {
+		return err
+	}
+	query := fmt.Sprintf(`
+INSERT INTO %s (watch_id, %s)
+SELECT $1, unnest($2::bigint[])
+ON CONFLICT DO NOTHING`, m.linkTable, m.linkColumn)
+	_, err = tx.ExecContext(ctx, query, watchID, pq.Array(ids))
+	return err
+}
+
+// watchColumnsSQL returns the subqueries that select the ids and names of the entities
+// linked to each row of the watches table, ordered consistently by name.
+func (m CatalogEntityModel) watchColumnsSQL() string {
+	subquery := `ARRAY(SELECT %[1]s.%[2]s FROM %[3]s INNER JOIN %[1]s ON %[1]s.id = %[3]s.%[4]s WHERE %[3]s.watch_id = watches.id ORDER BY %[1]s.name)`
+	return fmt.Sprintf(subquery, m.table, "id", m.linkTable, m.linkColumn) + ", " +
+		fmt.Sprintf(subquery, m.table, "name", m.linkTable, m.linkColumn)
+}
+
+// watchSortSQL returns an expression that sorts watches by the alphabetically first
+// linked entity name.
+func (m CatalogEntityModel) watchSortSQL() string {
+	return fmt.Sprintf(`(SELECT min(%[1]s.name) FROM %[2]s INNER JOIN %[1]s ON %[1]s.id = %[2]s.%[3]s WHERE %[2]s.watch_id = watches.id)`,
+		m.table, m.linkTable, m.linkColumn)
+}
+
+// watchFilterSQL returns a condition matching watches linked to the entity with the
+// slug or alias in the given placeholder.
+func (m CatalogEntityModel) watchFilterSQL(placeholder string) string {
+	return fmt.Sprintf(`EXISTS (SELECT 1 FROM %[2]s INNER JOIN %[1]s ON %[1]s.id = %[2]s.%[3]s WHERE %[2]s.watch_id = watches.id AND (%[1]s.slug = %[4]s OR %[4]s = ANY(%[1]s.aliases)))`,
+		m.table, m.linkTable, m.linkColumn, placeholder)
+}
+
+func (m CatalogEntityModel) translateError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch {
+		case pqErr.Code == "23505" && pqErr.Constraint == m.table+"_slug_key":
+			return ErrDuplicateSlug
+		case pqErr.Code == "23505" && pqErr.Constraint == m.table+"_aliases_key":
+			return ErrDuplicateAlias
+		case pqErr.Code == "23503":
+			return ErrEntityInUse
+		}
+	}
+	return err
+}
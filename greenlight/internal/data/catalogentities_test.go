@@ -0,0 +1,53 @@
+package data
+
+import (
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSlugify(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"Rolex", "rolex"},
+		{"  ROLEX SA  ", "rolex"},
+		{"Audemars Piguet", "audemars-piguet"},
+		{"A. Lange & Söhne GmbH", "a-lange-sohne"},
+		{"Hublot Genève", "hublot-geneve"},
+		{"Čapek Łódź", "capek-lodz"},
+		{"Straße Ørsted", "strasse-orsted"},
+		{"Œuvre Þór", "oeuvre-thor"},
+		{"ÉTERNA SA", "eterna"},
+		{"時計", ""},
+		{"Patek Philippe S.A.", "patek-philippe-s-a"},
+		{"IWC Schaffhausen AG", "iwc-schaffhausen"},
+		{"Omega Ltd.", "omega"},
+		{"Sarl Watches", "sarl-watches"},
+		{"18k rose-gold", "18k-rose-gold"},
+		{"--Titanium--", "titanium"},
+		{"stainless_steel 316L", "stainless-steel-316l"},
+		{"", ""},
+		{"!!!", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Slugify(tt.name); got != tt.want {
+				t.Errorf("Slugify(%q) = %q; want %q", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransliterationTable(t *testing.T) {
+	from, to := utf8.RuneCountInString(transliterateFrom), utf8.RuneCountInString(transliterateTo)
+	if from != to {
+		t.Fatalf("transliterateFrom has %d letters and transliterateTo has %d", from, to)
+	}
+	for _, r := range transliterateTo {
+		if r < 'a' || r > 'z' {
+			t.Errorf("transliterateTo contains %q; want only a-z", r)
+		}
+	}
+}
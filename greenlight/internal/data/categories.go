@@ -0,0 +1,389 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+var (
+	ErrCategoryCycle       = errors.New("category would be its own ancestor")
+	ErrCategoryHasChildren = errors.New("category has subcategories")
+	ErrUnknownCategory     = errors.New("unknown category")
+)
+
+// Category is a node in the category tree. Categories without a parent are at the
+// top level, and siblings are ordered by Position and then by name.
+type Category struct {
+	ID          int64     `json:"id"`
+	CreatedAt   time.Time `json:"-"`
+	ParentID    *int64    `json:"parent_id"`
+	Name        string    `json:"name"`
+	Slug        string    `json:"slug"`
+	Description string    `json:"description,omitempty"`
+	Position    int       `json:"position"`
+	Version     int32     `json:"version"`
+}
+
+// CategoryNode is a category with its subcategories, for returning the whole tree.
+type CategoryNode struct {
+	*Category
+	Children []*CategoryNode `json:"children"`
+}
+
+// CategoryRef is the short form of a category used in breadcrumbs.
+type CategoryRef struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+	Slug string `json:"slug"`
+}
+
+// Breadcrumb is the path from a top-level category down to one that a watch is in.
+type Breadcrumb []*CategoryRef
+
+func ValidateCategory(v *validator.Validator, category *Category) {
+	v.Check(category.Name != "", "name", "must be provided")
+	v.Check(len(category.Name) <= 200, "name", "must not be more than 200 bytes long")
+	v.Check(category.Slug != "", "slug", "must be provided")
+	v.Check(validator.Matches(category.Slug, SlugRX), "slug", "must only contain lowercase letters, digits and hyphens")
+	v.Check(len(category.Description) <= 2000, "description", "must not be more than 2000 bytes long")
+	v.Check(category.Position >= 0, "position", "must not be negative")
+	if category.ParentID != nil {
+		v.Check(*category.ParentID != category.ID, "parent_id", "must not be the category itself")
+	}
+}
+
+// CategoryTree arranges categories, as returned by GetAll, into a tree, keeping their
+// order among siblings.
+func CategoryTree(categories []*Category) []*CategoryNode {
+	nodes := make(map[int64]*CategoryNode, len(categories))
+	for _, c := range categories {
+		nodes[c.ID] = &CategoryNode{Category: c, Children: []*CategoryNode{}}
+	}
+	roots := []*CategoryNode{}
+	for _, c := range categories {
+		if c.ParentID == nil {
+			roots = append(roots, nodes[c.ID])
+			continue
+		}
+		parent := nodes[*c.ParentID]
+		parent.Children = append(parent.Children, nodes[c.ID])
+	}
+	return roots
+}
+
+// breadcrumb returns the path to a category, using categories indexed by id. It stops
+// if it comes back to a category it has already visited, so a cycle in the data can't
+// make it loop forever.
+func breadcrumb(byID map[int64]*Category, id int64) Breadcrumb {
+	var path Breadcrumb
+	seen := make(map[int64]bool)
+	for c := byID[id]; c != nil && !seen[c.ID]; {
+		seen[c.ID] = true
+		path = append(Breadcrumb{{ID: c.ID, Name: c.Name, Slug: c.Slug}}, path...)
+		if c.ParentID == nil {
+			break
+		}
+		c = byID[*c.ParentID]
+	}
+	return path
+}
+
+// categorySubtreeSQL selects the ids of the category with the slug in the given
+// placeholder and of all the categories below it. UNION rather than UNION ALL ends
+// the recursion even if the tree contains a cycle.
+func categorySubtreeSQL(placeholder string) string {
+	return fmt.Sprintf(`WITH RECURSIVE subtree AS (
+	SELECT categories.id FROM categories WHERE categories.slug = %s
+	UNION
+	SELECT categories.id FROM categories INNER JOIN subtree ON categories.parent_id = subtree.id
+) SELECT id FROM subtree`, placeholder)
+}
+
+// categoryTreeLock is the transaction-level advisory lock taken while a category is
+// moved, so that two moves can't each pass the cycle check and together make a cycle.
+const categoryTreeLock = 4_600_001
+
+type CategoryModel struct {
+	DB *sql.DB
+}
+
+func (m CategoryModel) Insert(category *Category) error {
+	query := `
+INSERT INTO categories (parent_id, name, slug, description, position)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING id, created_at, version`
+
+	args := []interface{}{category.ParentID, category.Name, category.Slug, category.Description, category.Position}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&category.ID, &category.CreatedAt, &category.Version)
+	if err != nil {
+		return translateCategoryError(err)
+	}
+	return nil
+}
+
+func (m CategoryModel) Get(id int64) (*Category, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `
+SELECT id, created_at, parent_id, name, slug, description, position, version
+FROM categories
+WHERE id = $1`
+
+	var c Category
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&c.ID, &c.CreatedAt, &c.ParentID, &c.Name, &c.Slug, &c.Description, &c.Position, &c.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &c, nil
+}
+
+// Update saves a changed category, using the version number to detect concurrent
+// changes. Moving a category below itself or one of its descendants returns
+// ErrCategoryCycle.
+func (m CategoryModel) Update(category *Category) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if category.ParentID != nil {
+		_, err = tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, categoryTreeLock)
+		if err != nil {
+			return err
+		}
+
+		query := `
+WITH RECURSIVE ancestors AS (
+	SELECT id, parent_id FROM categories WHERE id = $1
+	UNION
+	SELECT categories.id, categories.parent_id FROM categories INNER JOIN ancestors ON categories.id = ancestors.parent_id
+)
+SELECT EXISTS (SELECT 1 FROM ancestors WHERE id = $2)`
+
+		var cycle bool
+		err = tx.QueryRowContext(ctx, query, *category.ParentID, category.ID).Scan(&cycle)
+		if err != nil {
+			return err
+		}
+		if cycle {
+			return ErrCategoryCycle
+		}
+	}
+
+	query := `
+UPDATE categories
+SET parent_id = $1, name = $2, slug = $3, description = $4, position = $5, version = version + 1
+WHERE id = $6 AND version = $7
+RETURNING version`
+
+	args := []interface{}{category.ParentID, category.Name, category.Slug, category.Description, category.Position, category.ID, category.Version}
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&category.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return translateCategoryError(err)
+		}
+	}
+	return tx.Commit()
+}
+
+// Delete removes a category and its links to watches. Categories that still have
+// subcategories return ErrCategoryHasChildren.
+func (m CategoryModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+	query := `
+DELETE FROM categories
+WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23503" {
+			return ErrCategoryHasChildren
+		}
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// GetAll returns every category in sibling order. The tree is small enough to be
+// returned whole; CategoryTree arranges it.
+func (m CategoryModel) GetAll() ([]*Category, error) {
+	query := `
+SELECT id, created_at, parent_id, name, slug, description, position, version
+FROM categories
+ORDER BY position, name, id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	categories := []*Category{}
+	for rows.Next() {
+		var c Category
+		err := rows.Scan(&c.ID, &c.CreatedAt, &c.ParentID, &c.Name, &c.Slug, &c.Description, &c.Position, &c.Version)
+		if err != nil {
+			return nil, err
+		}
+		categories = append(categories, &c)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return categories, nil
+}
+
+// GetBreadcrumbs returns the path to a single category.
+func (m CategoryModel) GetBreadcrumbs(id int64) (Breadcrumb, error) {
+	categories, err := m.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	return breadcrumb(categoriesByID(categories), id), nil
+}
+
+// GetBreadcrumbsForWatches returns the breadcrumbs of the categories each of the given
+// watches is in, keyed by watch id.
+func (m CategoryModel) GetBreadcrumbsForWatches(watchIDs ...int64) (map[int64][]Breadcrumb, error) {
+	breadcrumbs := make(map[int64][]Breadcrumb, len(watchIDs))
+	if len(watchIDs) == 0 {
+		return breadcrumbs, nil
+	}
+
+	query := `
+SELECT watch_id, category_id
+FROM watches_categories
+WHERE watch_id = ANY($1)
+ORDER BY watch_id, category_id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(watchIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	links := make(map[int64][]int64)
+	for rows.Next() {
+		var watchID, categoryID int64
+		err := rows.Scan(&watchID, &categoryID)
+		if err != nil {
+			return nil, err
+		}
+		links[watchID] = append(links[watchID], categoryID)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(links) == 0 {
+		return breadcrumbs, nil
+	}
+
+	categories, err := m.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	byID := categoriesByID(categories)
+	for watchID, categoryIDs := range links {
+		for _, id := range categoryIDs {
+			breadcrumbs[watchID] = append(breadcrumbs[watchID], breadcrumb(byID, id))
+		}
+	}
+	return breadcrumbs, nil
+}
+
+func categoriesByID(categories []*Category) map[int64]*Category {
+	byID := make(map[int64]*Category, len(categories))
+	for _, c := range categories {
+		byID[c.ID] = c
+	}
+	return byID
+}
+
+// SetForWatch replaces the categories a watch is in. Category ids that don't exist
+// return ErrUnknownCategory.
+func (m CategoryModel) SetForWatch(watchID int64, categoryIDs []int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM watches_categories WHERE watch_id = $1`, watchID)
+	if err != nil {
+		return err
+	}
+	query := `
+INSERT INTO watches_categories (watch_id, category_id)
+SELECT $1, unnest($2::bigint[])
+ON CONFLICT DO NOTHING`
+	_, err = tx.ExecContext(ctx, query, watchID, pq.Array(categoryIDs))
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23503" {
+			return ErrUnknownCategory
+		}
+		return err
+	}
+	return tx.Commit()
+}
+
+func translateCategoryError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch {
+		case pqErr.Code == "23505":
+			return ErrDuplicateSlug
+		case pqErr.Code == "23503":
+			return ErrUnknownCategory
+		}
+	}
+	return err
+}
@@ -0,0 +1,242 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+// The changes that a change request can hold.
+const (
+	ChangeUpdate = "update"
+	ChangeDelete = "delete"
+)
+
+// The states of a change request. They match the review states.
+var ChangeRequestStatuses = []string{ReviewPending, ReviewApproved, ReviewRejected}
+
+// ChangeRequest is a sensitive change to a watch that waits for a second person to
+// approve it. WatchVersion is the version of the watch that the change was made
+// against; the change can only be applied while the watch is still at that version.
+// WatchID is nil once the watch has been deleted; WatchTitle keeps its title.
+type ChangeRequest struct {
+	ID             int64           `json:"id"`
+	CreatedAt      time.Time       `json:"created_at"`
+	WatchID        *int64          `json:"watch_id"`
+	WatchTitle     string          `json:"watch_title"`
+	UserID         int64           `json:"requested_by"`
+	RequesterName  string          `json:"-"`
+	RequesterEmail string          `json:"-"`
+	Action         string          `json:"action"`
+	Changes        json.RawMessage `json:"changes,omitempty"`
+	WatchVersion   int32           `json:"watch_version"`
+	Status         string          `json:"status"`
+	ReviewedBy     *int64          `json:"reviewed_by,omitempty"`
+	ReviewedAt     *time.Time      `json:"reviewed_at,omitempty"`
+	ReviewNote     string          `json:"review_note,omitempty"`
+	Version        int32           `json:"version"`
+}
+
+func ValidateChangeRequestReview(v *validator.Validator, cr *ChangeRequest) {
+	v.Check(validator.In(cr.Status, ReviewApproved, ReviewRejected), "status", "must be approved or rejected")
+	v.Check(len(cr.ReviewNote) <= 1000, "note", "must not be more than 1000 bytes long")
+}
+
+// PriceChangeExceeds reports whether the change from old to new is more than percent
+// of the old price. A change of currency can't be measured, so it always counts.
+func PriceChangeExceeds(old, new Money, percent float64) bool {
+	if old.Currency != new.Currency {
+		return true
+	}
+	diff := new.Amount - old.Amount
+	if diff < 0 {
+		diff = -diff
+	}
+	if old.Amount == 0 {
+		return diff > 0
+	}
+	return float64(diff)*100 > percent*float64(old.Amount)
+}
+
+type ChangeRequestModel struct {
+	DB *sql.DB
+}
+
+const changeRequestColumnsSQL = `change_requests.id, change_requests.created_at, change_requests.watch_id, change_requests.watch_title,
+	change_requests.user_id, users.name, users.email, change_requests.action, change_requests.changes, change_requests.watch_version,
+	change_requests.status, change_requests.reviewed_by, change_requests.reviewed_at, change_requests.review_note, change_requests.version`
+
+const changeRequestJoinsSQL = `INNER JOIN users ON users.id = change_requests.user_id`
+
+func (cr *ChangeRequest) scanFields() []interface{} {
+	return []interface{}{&cr.ID, &cr.CreatedAt, &cr.WatchID, &cr.WatchTitle,
+		&cr.UserID, &cr.RequesterName, &cr.RequesterEmail, &cr.Action, (*[]byte)(&cr.Changes), &cr.WatchVersion,
+		&cr.Status, &cr.ReviewedBy, &cr.ReviewedAt, &cr.ReviewNote, &cr.Version}
+}
+
+// Insert saves a new change request, which is pending until it is reviewed.
+func (m ChangeRequestModel) Insert(cr *ChangeRequest) error {
+	query := `
+INSERT INTO change_requests (watch_id, watch_title, user_id, action, changes, watch_version)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING id, created_at, status, version`
+
+	var changes interface{}
+	if len(cr.Changes) > 0 {
+		changes = []byte(cr.Changes)
+	}
+	args := []interface{}{cr.WatchID, cr.WatchTitle, cr.UserID, cr.Action, changes, cr.WatchVersion}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&cr.ID, &cr.CreatedAt, &cr.Status, &cr.Version)
+}
+
+func (m ChangeRequestModel) Get(id int64) (*ChangeRequest, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := fmt.Sprintf(`
+SELECT %s
+FROM change_requests
+%s
+WHERE change_requests.id = $1`, changeRequestColumnsSQL, changeRequestJoinsSQL)
+
+	var cr ChangeRequest
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(cr.scanFields()...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &cr, nil
+}
+
+// Update saves the review of a change request, using the version number to detect
+// concurrent reviews.
+func (m ChangeRequestModel) Update(cr *ChangeRequest) error {
+	query := `
+UPDATE change_requests
+SET status = $1, reviewed_by = $2, reviewed_at = $3, review_note = $4, version = version + 1
+WHERE id = $5 AND version = $6
+RETURNING version`
+
+	args := []interface{}{cr.Status, cr.ReviewedBy, cr.ReviewedAt, cr.ReviewNote, cr.ID, cr.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&cr.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// ApproveDelete records the approval of a delete request and deletes the watch in one
+// transaction, so the request is never approved without the watch going, or the other
+// way round. The watch is only deleted while it is still at WatchVersion; otherwise,
+// like a concurrent review, it returns ErrEditConflict. A watch that is still
+// referenced by orders or auctions returns ErrWatchInUse.
+func (m ChangeRequestModel) ApproveDelete(cr *ChangeRequest) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+UPDATE change_requests
+SET status = $1, reviewed_by = $2, reviewed_at = $3, review_note = $4, version = version + 1
+WHERE id = $5 AND version = $6
+RETURNING version`
+
+	args := []interface{}{cr.Status, cr.ReviewedBy, cr.ReviewedAt, cr.ReviewNote, cr.ID, cr.Version}
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&cr.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM watches WHERE id = $1 AND version = $2`, cr.WatchID, cr.WatchVersion)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23503" {
+			return ErrWatchInUse
+		}
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+	return tx.Commit()
+}
+
+// GetAll lists change requests, optionally for a single watch or requester (0 means
+// any) and in a single status.
+func (m ChangeRequestModel) GetAll(watchID, userID int64, status string, filters Filters) ([]*ChangeRequest, Metadata, error) {
+	query := fmt.Sprintf(`
+SELECT count(*) OVER(), %s
+FROM change_requests
+%s
+WHERE (change_requests.watch_id = $1 OR $1 = 0)
+AND (change_requests.user_id = $2 OR $2 = 0)
+AND (change_requests.status = $3 OR $3 = '')
+ORDER BY change_requests.%s %s, change_requests.id ASC
+LIMIT $4 OFFSET $5`, changeRequestColumnsSQL, changeRequestJoinsSQL, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, watchID, userID, status, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	requests := []*ChangeRequest{}
+	for rows.Next() {
+		var cr ChangeRequest
+		err := rows.Scan(append([]interface{}{&totalRecords}, cr.scanFields()...)...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		requests = append(requests, &cr)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return requests, metadata, nil
+}
@@ -0,0 +1,35 @@
+package data
+
+import "testing"
+
+func TestPriceChangeExceeds(t *testing.T) {
+	tests := []struct {
+		name    string
+		old     Money
+		new     Money
+		percent float64
+		want    bool
+	}{
+		{"no change", Money{10000, "USD"}, Money{10000, "USD"}, 10, false},
+		{"rise below threshold", Money{10000, "USD"}, Money{10999, "USD"}, 10, false},
+		{"rise at threshold", Money{10000, "USD"}, Money{11000, "USD"}, 10, false},
+		{"rise above threshold", Money{10000, "USD"}, Money{11001, "USD"}, 10, true},
+		{"drop at threshold", Money{10000, "USD"}, Money{9000, "USD"}, 10, false},
+		{"drop above threshold", Money{10000, "USD"}, Money{8999, "USD"}, 10, true},
+		{"drop to zero", Money{10000, "USD"}, Money{0, "USD"}, 10, true},
+		{"from zero", Money{0, "USD"}, Money{1, "USD"}, 10, true},
+		{"zero to zero", Money{0, "USD"}, Money{0, "USD"}, 10, false},
+		{"zero threshold", Money{10000, "USD"}, Money{10001, "USD"}, 0, true},
+		{"fractional threshold", Money{10000, "USD"}, Money{10050, "USD"}, 0.5, false},
+		{"currency change", Money{10000, "USD"}, Money{10000, "EUR"}, 10, true},
+		{"currency change with huge threshold", Money{10000, "USD"}, Money{10000, "EUR"}, 1000, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := PriceChangeExceeds(tt.old, tt.new, tt.percent); got != tt.want {
+				t.Errorf("PriceChangeExceeds(%v, %v, %v) = %t; want %t", tt.old, tt.new, tt.percent, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,264 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+var ErrUnknownWatch = errors.New("unknown watch")
+
+// Collection is a curated list of watches, such as "Summer picks", in a manually
+// chosen order.
+type Collection struct {
+	ID          int64     `json:"id"`
+	CreatedAt   time.Time `json:"-"`
+	Name        string    `json:"name"`
+	Slug        string    `json:"slug"`
+	Description string    `json:"description,omitempty"`
+	WatchCount  int       `json:"watch_count"`
+	Version     int32     `json:"version"`
+}
+
+func ValidateCollection(v *validator.Validator, collection *Collection) {
+	v.Check(collection.Name != "", "name", "must be provided")
+	v.Check(len(collection.Name) <= 200, "name", "must not be more than 200 bytes long")
+	v.Check(collection.Slug != "", "slug", "must be provided")
+	v.Check(validator.Matches(collection.Slug, SlugRX), "slug", "must only contain lowercase letters, digits and hyphens")
+	v.Check(len(collection.Description) <= 2000, "description", "must not be more than 2000 bytes long")
+}
+
+func ValidateCollectionWatches(v *validator.Validator, watchIDs []int64) {
+	v.Check(watchIDs != nil, "watch_ids", "must be provided")
+	v.Check(len(watchIDs) <= 500, "watch_ids", "must not contain more than 500 watches")
+	v.Check(validator.Unique(idStrings(watchIDs)), "watch_ids", "must not contain duplicate values")
+}
+
+const collectionColumnsSQL = `collections.id, collections.created_at, collections.name, collections.slug, collections.description,
+	(SELECT count(*) FROM collection_watches WHERE collection_watches.collection_id = collections.id), collections.version`
+
+func (c *Collection) scanFields() []interface{} {
+	return []interface{}{&c.ID, &c.CreatedAt, &c.Name, &c.Slug, &c.Description, &c.WatchCount, &c.Version}
+}
+
+type CollectionModel struct {
+	DB *sql.DB
+}
+
+func (m CollectionModel) Insert(collection *Collection) error {
+	query := `
+INSERT INTO collections (name, slug, description)
+VALUES ($1, $2, $3)
+RETURNING id, created_at, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, collection.Name, collection.Slug, collection.Description).Scan(&collection.ID, &collection.CreatedAt, &collection.Version)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return ErrDuplicateSlug
+		}
+		return err
+	}
+	return nil
+}
+
+func (m CollectionModel) Get(id int64) (*Collection, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := fmt.Sprintf(`
+SELECT %s
+FROM collections
+WHERE id = $1`, collectionColumnsSQL)
+
+	var collection Collection
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(collection.scanFields()...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &collection, nil
+}
+
+func (m CollectionModel) Update(collection *Collection) error {
+	query := `
+UPDATE collections
+SET name = $1, slug = $2, description = $3, version = version + 1
+WHERE id = $4 AND version = $5
+RETURNING version`
+
+	args := []interface{}{collection.Name, collection.Slug, collection.Description, collection.ID, collection.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&collection.Version)
+	if err != nil {
+		var pqErr *pq.Error
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		case errors.As(err, &pqErr) && pqErr.Code == "23505":
+			return ErrDuplicateSlug
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (m CollectionModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+	query := `
+DELETE FROM collections
+WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+func (m CollectionModel) GetAll(filters Filters) ([]*Collection, Metadata, error) {
+	query := fmt.Sprintf(`
+SELECT count(*) OVER(), %s
+FROM collections
+ORDER BY %s %s, id ASC
+LIMIT $1 OFFSET $2`, collectionColumnsSQL, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	collections := []*Collection{}
+	for rows.Next() {
+		var collection Collection
+		err := rows.Scan(append([]interface{}{&totalRecords}, collection.scanFields()...)...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		collections = append(collections, &collection)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return collections, metadata, nil
+}
+
+// SetWatches replaces the watches in a collection with the given ones, in the given
+// order. Watch ids that don't exist return ErrUnknownWatch.
+func (m CollectionModel) SetWatches(id int64, watchIDs []int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Locking the collection keeps concurrent reorders from interleaving.
+	var exists bool
+	err = tx.QueryRowContext(ctx, `SELECT true FROM collections WHERE id = $1 FOR UPDATE`, id).Scan(&exists)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM collection_watches WHERE collection_id = $1`, id)
+	if err != nil {
+		return err
+	}
+	query := `
+INSERT INTO collection_watches (collection_id, watch_id, position)
+SELECT $1, ordered.watch_id, ordered.position
+FROM unnest($2::bigint[]) WITH ORDINALITY AS ordered(watch_id, position)`
+	_, err = tx.ExecContext(ctx, query, id, pq.Array(watchIDs))
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23503" {
+			return ErrUnknownWatch
+		}
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetWatches lists the watches in a collection that are in one of the given states. It
+// sorts on the same columns as the watch list, plus position, the collection's own
+// order.
+func (m CollectionModel) GetWatches(id int64, statuses []string, filters Filters) ([]*Watches, Metadata, error) {
+	query := fmt.Sprintf(`
+SELECT count(*) OVER(), %s
+FROM collection_watches
+INNER JOIN watches ON watches.id = collection_watches.watch_id %s
+WHERE collection_watches.collection_id = $1 AND watches.status = ANY($2)
+ORDER BY %s, watches.id ASC
+LIMIT $3 OFFSET $4`, watchesColumnsSQL(), latestRateJoinSQL, watchesOrderBy(filters))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, id, pq.Array(statuses), filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	watches := []*Watches{}
+	for rows.Next() {
+		var watch Watches
+		err := rows.Scan(append([]interface{}{&totalRecords}, watch.scanFields()...)...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		watches = append(watches, &watch)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return watches, metadata, nil
+}
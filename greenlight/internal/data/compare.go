@@ -0,0 +1,137 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+// The number of watches that can be compared at once.
+const (
+	MinCompareWatches = 2
+	MaxCompareWatches = 5
+)
+
+// ComparisonRow is one attribute in a comparison of watches, with a value for each
+// watch in the same order as the watches. Differs is set when the values aren't all
+// the same, so that clients can highlight the row.
+type ComparisonRow struct {
+	Field   string        `json:"field"`
+	Values  []interface{} `json:"values"`
+	Differs bool          `json:"differs"`
+}
+
+func ValidateCompareIDs(v *validator.Validator, ids []int64) {
+	v.Check(len(ids) >= MinCompareWatches, "ids", fmt.Sprintf("must contain at least %d watches", MinCompareWatches))
+	v.Check(len(ids) <= MaxCompareWatches, "ids", fmt.Sprintf("must not contain more than %d watches", MaxCompareWatches))
+	v.Check(validator.Unique(idStrings(ids)), "ids", "must not contain duplicate values")
+}
+
+func idStrings(ids []int64) []string {
+	s := make([]string, len(ids))
+	for i, id := range ids {
+		s[i] = fmt.Sprint(id)
+	}
+	return s
+}
+
+// GetMany fetches the watches with the given ids, in the same order as the ids. Ids
+// that don't exist are skipped, so the result can be shorter than ids.
+func (w WatchesModel) GetMany(ids []int64) ([]*Watches, error) {
+	query := fmt.Sprintf(`
+SELECT %s
+FROM watches
+WHERE watches.id = ANY($1)
+ORDER BY array_position($1, watches.id)`, watchesColumnsSQL())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := w.DB.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	watches := []*Watches{}
+	for rows.Next() {
+		var watch Watches
+		err := rows.Scan(watch.scanFields()...)
+		if err != nil {
+			return nil, err
+		}
+		watches = append(watches, &watch)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return watches, nil
+}
+
+// CompareWatches builds the attribute matrix for a comparison. Brands and materials
+// are sorted and joined so that the same set always compares equal, prices use the
+// converted price when there is one, and there is a row for every spec attribute that
+// any of the watches has, with nil for the watches that lack it.
+func CompareWatches(watches []*Watches) []*ComparisonRow {
+	rows := []*ComparisonRow{
+		compareRow("title", watches, func(w *Watches) interface{} { return w.Title }),
+		compareRow("year", watches, func(w *Watches) interface{} { return w.Year }),
+		compareRow("price", watches, func(w *Watches) interface{} {
+			if w.ConvertedPrice != nil {
+				return w.ConvertedPrice.String()
+			}
+			return w.Price.String()
+		}),
+		compareRow("brand", watches, func(w *Watches) interface{} { return joinSorted(w.Brand) }),
+		compareRow("material", watches, func(w *Watches) interface{} { return joinSorted(w.Material) }),
+		compareRow("tags", watches, func(w *Watches) interface{} { return joinSorted(w.Tags) }),
+		compareRow("available", watches, func(w *Watches) interface{} { return w.Available }),
+		compareRow("rating", watches, func(w *Watches) interface{} { return w.Rating }),
+		compareRow("review_count", watches, func(w *Watches) interface{} { return w.ReviewCount }),
+	}
+
+	var specNames []string
+	seen := make(map[string]bool)
+	for _, w := range watches {
+		for name := range w.Specs {
+			if !seen[name] {
+				seen[name] = true
+				specNames = append(specNames, name)
+			}
+		}
+	}
+	sort.Strings(specNames)
+	for _, name := range specNames {
+		rows = append(rows, compareRow("specs."+name, watches, func(w *Watches) interface{} { return w.Specs[name] }))
+	}
+	return rows
+}
+
+func compareRow(field string, watches []*Watches, value func(*Watches) interface{}) *ComparisonRow {
+	row := &ComparisonRow{Field: field, Values: make([]interface{}, len(watches))}
+	var first []byte
+	for i, w := range watches {
+		row.Values[i] = value(w)
+		// Values are compared by their JSON encoding, which is what clients see, and
+		// which also handles the slices and maps that specs can hold.
+		encoded, _ := json.Marshal(row.Values[i])
+		if i == 0 {
+			first = encoded
+		} else if string(encoded) != string(first) {
+			row.Differs = true
+		}
+	}
+	return row
+}
+
+func joinSorted(values []string) string {
+	sorted := append([]string(nil), values...)
+	sort.Strings(sorted)
+	return strings.Join(sorted, ", ")
+}
@@ -0,0 +1,251 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+// BaseCurrency is the currency that all exchange rates are quoted against. A rate of
+// 0.94 for CHF means that 1 EUR buys 0.94 CHF.
+const BaseCurrency = "EUR"
+
+var (
+	ErrNoExchangeRate = errors.New("no exchange rate available")
+	ErrMoneyOverflow  = errors.New("converted amount is too large")
+)
+
+type ExchangeRate struct {
+	Currency  string    `json:"currency"`
+	Rate      float64   `json:"rate"`
+	RateDate  time.Time `json:"rate_date"`
+	Source    string    `json:"source"`
+	CreatedAt time.Time `json:"-"`
+}
+
+func ValidateExchangeRate(v *validator.Validator, key string, rate *ExchangeRate) {
+	v.Check(SupportedCurrency(rate.Currency), key, "must use a supported ISO 4217 currency code")
+	v.Check(rate.Currency != BaseCurrency, key, fmt.Sprintf("must not be the base currency %s", BaseCurrency))
+	v.Check(rate.Rate > 0, key, "rate must be greater than zero")
+	v.Check(!rate.RateDate.IsZero(), key, "rate date must be provided")
+	v.Check(rate.RateDate.Before(time.Now()), key, "rate date must not be in the future")
+	v.Check(rate.Source != "", key, "source must be provided")
+	v.Check(len(rate.Source) <= 100, key, "source must not be more than 100 bytes long")
+}
+
+// ExchangeRates holds the most recent rate for each currency, keyed by currency code.
+type ExchangeRates map[string]*ExchangeRate
+
+// rate returns the number of units of the currency that 1 unit of BaseCurrency buys.
+func (e ExchangeRates) rate(currency string) (float64, bool) {
+	if currency == BaseCurrency {
+		return 1, true
+	}
+	r, ok := e[currency]
+	if !ok {
+		return 0, false
+	}
+	return r.Rate, true
+}
+
+// Convert converts an amount of money to another currency via the base currency,
+// rounding half away from zero to the nearest minor unit of the target currency. The
+// arithmetic is done on exact rationals so that large amounts don't lose precision.
+func (e ExchangeRates) Convert(m Money, currency string) (Money, error) {
+	if m.Currency == currency {
+		return m, nil
+	}
+	from, ok := e.rate(m.Currency)
+	if !ok {
+		return Money{}, ErrNoExchangeRate
+	}
+	to, ok := e.rate(currency)
+	if !ok {
+		return Money{}, ErrNoExchangeRate
+	}
+
+	// amount * to * 10^exp(currency) / (from * 10^exp(m.Currency))
+	amount := new(big.Rat).SetInt64(m.Amount)
+	amount.Mul(amount, exactRate(to))
+	amount.Quo(amount, exactRate(from))
+	amount.Mul(amount, new(big.Rat).SetInt64(pow10(currencyExponent(currency))))
+	amount.Quo(amount, new(big.Rat).SetInt64(pow10(currencyExponent(m.Currency))))
+
+	rounded := roundRat(amount)
+	if !rounded.IsInt64() {
+		return Money{}, ErrMoneyOverflow
+	}
+	return Money{Amount: rounded.Int64(), Currency: currency}, nil
+}
+
+// exactRate returns the rate as the decimal that was stored, rather than its nearest
+// binary float. Rates are numeric(18, 8) in the database, so the shortest decimal that
+// round-trips the float is always the stored value.
+func exactRate(rate float64) *big.Rat {
+	r, _ := new(big.Rat).SetString(strconv.FormatFloat(rate, 'f', -1, 64))
+	return r
+}
+
+// roundRat rounds r to the nearest integer, with halves rounded away from zero.
+func roundRat(r *big.Rat) *big.Int {
+	num, denom := new(big.Int).Abs(r.Num()), r.Denom()
+	// (2|num| + denom) / 2denom truncates to |r| rounded half up.
+	q := new(big.Int).Lsh(num, 1)
+	q.Add(q, denom)
+	q.Quo(q, new(big.Int).Lsh(denom, 1))
+	if r.Sign() < 0 {
+		q.Neg(q)
+	}
+	return q
+}
+
+// Conversion describes the exchange rates that were used to convert prices, so that
+// clients can show where the converted figures came from.
+type Conversion struct {
+	Currency     string          `json:"currency"`
+	BaseCurrency string          `json:"base_currency"`
+	Rates        []*ExchangeRate `json:"rates"`
+	MissingRates []string        `json:"missing_rates,omitempty"`
+}
+
+// ConvertWatches sets the ConvertedPrice of each watch to its price in the given
+// currency. Watches priced in a currency with no known rate are left unconverted and
+// their currency is reported in the MissingRates field of the returned Conversion.
+func (e ExchangeRates) ConvertWatches(currency string, watches ...*Watches) *Conversion {
+	used := make(map[string]bool)
+	missing := make(map[string]bool)
+	for _, watch := range watches {
+		converted, err := e.Convert(watch.Price, currency)
+		if err != nil {
+			if errors.Is(err, ErrNoExchangeRate) {
+				missing[watch.Price.Currency] = true
+			}
+			continue
+		}
+		watch.ConvertedPrice = &converted
+		used[watch.Price.Currency] = true
+	}
+	if len(used) > 0 {
+		used[currency] = true
+	}
+
+	conversion := &Conversion{
+		Currency:     currency,
+		BaseCurrency: BaseCurrency,
+		Rates:        []*ExchangeRate{},
+	}
+	for c := range used {
+		if r, ok := e[c]; ok && c != BaseCurrency {
+			conversion.Rates = append(conversion.Rates, r)
+		}
+	}
+	for c := range missing {
+		conversion.MissingRates = append(conversion.MissingRates, c)
+	}
+	sort.Slice(conversion.Rates, func(i, j int) bool { return conversion.Rates[i].Currency < conversion.Rates[j].Currency })
+	sort.Strings(conversion.MissingRates)
+	return conversion
+}
+
+// priceInBaseCurrencySQL returns an SQL expression which converts a watch's price into
+// major units of BaseCurrency using the latest_rate lateral join, so that watches priced
+// in different currencies can be sorted together. The minor unit exponents are taken
+// from our own currency list, so the expression is safe to interpolate.
+func priceInBaseCurrencySQL() string {
+	currencies := make([]string, 0, len(currencyExponents))
+	for currency := range currencyExponents {
+		currencies = append(currencies, currency)
+	}
+	sort.Strings(currencies)
+
+	var exponent strings.Builder
+	exponent.WriteString("CASE price_currency")
+	for _, currency := range currencies {
+		fmt.Fprintf(&exponent, " WHEN '%s' THEN %d", currency, currencyExponents[currency])
+	}
+	exponent.WriteString(" ELSE 2 END")
+
+	return fmt.Sprintf("(price_amount / (10 ^ (%s))::numeric / CASE WHEN price_currency = '%s' THEN 1 ELSE latest_rate.rate END)",
+		exponent.String(), BaseCurrency)
+}
+
+// latestRateJoinSQL joins each watch to the most recent exchange rate for its currency.
+const latestRateJoinSQL = `
+LEFT JOIN LATERAL (
+	SELECT rate
+	FROM exchange_rates
+	WHERE exchange_rates.currency = watches.price_currency
+	ORDER BY rate_date DESC
+	LIMIT 1
+) latest_rate ON true`
+
+type ExchangeRateModel struct {
+	DB *sql.DB
+}
+
+// Insert stores a batch of exchange rates in a single transaction, replacing any rate
+// that already exists for the same currency and date.
+func (m ExchangeRateModel) Insert(rates []*ExchangeRate) error {
+	query := `
+INSERT INTO exchange_rates (currency, rate, rate_date, source)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (currency, rate_date) DO UPDATE
+SET rate = EXCLUDED.rate, source = EXCLUDED.source, created_at = NOW()
+RETURNING created_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, rate := range rates {
+		err = tx.QueryRowContext(ctx, query, rate.Currency, rate.Rate, rate.RateDate, rate.Source).Scan(&rate.CreatedAt)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetLatest returns the most recent rate for every currency that has one.
+func (m ExchangeRateModel) GetLatest() (ExchangeRates, error) {
+	query := `
+SELECT DISTINCT ON (currency) currency, rate, rate_date, source, created_at
+FROM exchange_rates
+ORDER BY currency, rate_date DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rates := ExchangeRates{}
+	for rows.Next() {
+		var rate ExchangeRate
+		err := rows.Scan(&rate.Currency, &rate.Rate, &rate.RateDate, &rate.Source, &rate.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		rates[rate.Currency] = &rate
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return rates, nil
+}
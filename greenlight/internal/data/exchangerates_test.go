@@ -0,0 +1,56 @@
+package data
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestExchangeRatesConvert(t *testing.T) {
+	rates := ExchangeRates{
+		"CHF": {Currency: "CHF", Rate: 0.94},
+		"USD": {Currency: "USD", Rate: 1.0825},
+		"JPY": {Currency: "JPY", Rate: 161.37},
+		"KWD": {Currency: "KWD", Rate: 0.33251},
+	}
+
+	tests := []struct {
+		money    Money
+		currency string
+		want     Money
+		err      error
+	}{
+		{Money{1250000, "CHF"}, "CHF", Money{1250000, "CHF"}, nil},
+		{Money{94, "CHF"}, "EUR", Money{100, "EUR"}, nil},
+		{Money{100, "EUR"}, "CHF", Money{94, "CHF"}, nil},
+		{Money{100, "EUR"}, "JPY", Money{161, "JPY"}, nil},
+		{Money{161, "JPY"}, "EUR", Money{100, "EUR"}, nil},
+		{Money{100, "EUR"}, "KWD", Money{333, "KWD"}, nil},
+		{Money{1000000, "USD"}, "CHF", Money{868360, "CHF"}, nil},
+
+		// Halves round away from zero.
+		{Money{25, "EUR"}, "CHF", Money{24, "CHF"}, nil},
+		{Money{-25, "EUR"}, "CHF", Money{-24, "CHF"}, nil},
+		{Money{1, "EUR"}, "CHF", Money{1, "CHF"}, nil},
+
+		// Amounts beyond float64's 53 bits of precision are converted exactly.
+		{Money{9007199254740993, "CHF"}, "EUR", Money{9582126866745737, "EUR"}, nil},
+		{Money{9007199254740993, "EUR"}, "CHF", Money{8466767299456533, "CHF"}, nil},
+
+		{Money{math.MaxInt64, "EUR"}, "JPY", Money{}, ErrMoneyOverflow},
+		{Money{100, "EUR"}, "GBP", Money{}, ErrNoExchangeRate},
+		{Money{100, "GBP"}, "EUR", Money{}, ErrNoExchangeRate},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.money.String()+" to "+tt.currency, func(t *testing.T) {
+			got, err := rates.Convert(tt.money, tt.currency)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Convert(%v, %q) error = %v; want %v", tt.money, tt.currency, err, tt.err)
+			}
+			if got != tt.want {
+				t.Errorf("Convert(%v, %q) = %v; want %v", tt.money, tt.currency, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,307 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+var (
+	ErrNoUnitsAvailable = errors.New("no units available to hold")
+	ErrUnknownClient    = errors.New("client user does not exist")
+)
+
+// The states that a hold can be in. A hold whose expiry has passed is reported as
+// expired even before the sweeper has updated its row.
+const (
+	HoldActive   = "active"
+	HoldReleased = "released"
+	HoldExpired  = "expired"
+)
+
+// activeHoldSQL is the condition for a hold that is currently reserving a unit.
+const activeHoldSQL = `holds.status = 'active' AND holds.expires_at > NOW()`
+
+// Hold reserves one unit of a watch for a client until ExpiresAt. UserID is the
+// member of staff who placed the hold. ClientID is the client's user account; it is
+// nil for walk-in clients without one, who are only named in Client.
+type Hold struct {
+	ID         int64      `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	WatchID    int64      `json:"watch_id"`
+	UserID     int64      `json:"user_id"`
+	ClientID   *int64     `json:"client_id"`
+	Client     string     `json:"client"`
+	Note       string     `json:"note,omitempty"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	Status     string     `json:"status"`
+	ReleasedAt *time.Time `json:"released_at,omitempty"`
+	Version    int32      `json:"version"`
+}
+
+func ValidateHold(v *validator.Validator, hold *Hold) {
+	v.Check(hold.WatchID > 0, "watch_id", "must be provided")
+	if hold.ClientID != nil {
+		v.Check(*hold.ClientID > 0, "client_id", "must be a positive integer")
+	} else {
+		v.Check(hold.Client != "", "client", "must be provided")
+	}
+	v.Check(len(hold.Client) <= 200, "client", "must not be more than 200 bytes long")
+	v.Check(len(hold.Note) <= 1000, "note", "must not be more than 1000 bytes long")
+}
+
+// ValidateHoldDuration checks a requested hold duration against the configured maximum.
+func ValidateHoldDuration(v *validator.Validator, d, maximum time.Duration) {
+	v.Check(d > 0, "duration", "must be greater than zero")
+	v.Check(d <= maximum, "duration", fmt.Sprintf("must not be longer than %s", maximum))
+}
+
+// watchStockSQL is the number of units of a watch in stock across all locations, and
+// watchReservedSQL the number reserved, either by active holds, by open orders that
+// haven't been shipped yet or by auctions that haven't been closed or cancelled.
+const (
+	watchStockSQL    = `COALESCE((SELECT sum(stock_levels.quantity) FROM stock_levels WHERE stock_levels.watch_id = watches.id), 0)`
+	watchReservedSQL = `((SELECT count(*) FROM holds WHERE holds.watch_id = watches.id AND ` + activeHoldSQL + `)
+	+ (SELECT count(*) FROM order_lines INNER JOIN orders ON orders.id = order_lines.order_id
+		WHERE order_lines.watch_id = watches.id AND ` + openOrderSQL + `)
+	+ (SELECT count(*) FROM auctions WHERE auctions.watch_id = watches.id AND auctions.status = 'open'))`
+)
+
+// watchAvailableSQL is true when a watch is published and has more units in stock
+// than are reserved.
+const watchAvailableSQL = `(watches.status = 'published' AND ` + watchStockSQL + ` > ` + watchReservedSQL + `)`
+
+type HoldModel struct {
+	DB *sql.DB
+}
+
+// Insert places a hold on a watch. The watch row is locked while the units are
+// counted, so two concurrent requests can never both take the last unit. A hold for a
+// client user with no Client name is given the user's name; it returns
+// ErrUnknownClient if the user doesn't exist.
+func (m HoldModel) Insert(hold *Hold) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var available bool
+	query := fmt.Sprintf(`SELECT %s FROM watches WHERE id = $1 FOR UPDATE`, watchAvailableSQL)
+	err = tx.QueryRowContext(ctx, query, hold.WatchID).Scan(&available)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	if !available {
+		return ErrNoUnitsAvailable
+	}
+
+	if hold.ClientID != nil {
+		var name string
+		err = tx.QueryRowContext(ctx, `SELECT name FROM users WHERE id = $1`, *hold.ClientID).Scan(&name)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrUnknownClient
+			default:
+				return err
+			}
+		}
+		if hold.Client == "" {
+			hold.Client = name
+		}
+	}
+
+	query = `
+INSERT INTO holds (watch_id, user_id, client_id, client, note, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING id, created_at, status, version`
+
+	args := []interface{}{hold.WatchID, hold.UserID, hold.ClientID, hold.Client, hold.Note, hold.ExpiresAt}
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&hold.ID, &hold.CreatedAt, &hold.Status, &hold.Version)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+const holdColumnsSQL = `id, created_at, watch_id, user_id, client_id, client, note, expires_at,
+	CASE WHEN status = 'active' AND expires_at <= NOW() THEN 'expired' ELSE status END,
+	released_at, version`
+
+func (h *Hold) scanFields() []interface{} {
+	return []interface{}{&h.ID, &h.CreatedAt, &h.WatchID, &h.UserID, &h.ClientID, &h.Client, &h.Note, &h.ExpiresAt, &h.Status, &h.ReleasedAt, &h.Version}
+}
+
+func (m HoldModel) Get(id int64) (*Hold, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := fmt.Sprintf(`
+SELECT %s
+FROM holds
+WHERE id = $1`, holdColumnsSQL)
+
+	var hold Hold
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(hold.scanFields()...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &hold, nil
+}
+
+// Update saves a changed expiry, status, release time or note, using the version
+// number to detect concurrent changes. Releasing a hold can put a watch back in stock,
+// so the watch's alerts are checked in the same transaction.
+func (m HoldModel) Update(hold *Hold) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	before, err := readWatchState(ctx, tx, hold.WatchID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+UPDATE holds
+SET expires_at = $1, status = $2, released_at = $3, note = $4, version = version + 1
+WHERE id = $5 AND version = $6
+RETURNING version`
+
+	args := []interface{}{hold.ExpiresAt, hold.Status, hold.ReleasedAt, hold.Note, hold.ID, hold.Version}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&hold.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	err = queueAlerts(ctx, tx, hold.WatchID, before)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetAll lists holds, optionally for a single watch and a single client user (an id
+// of 0 means any) and in a single status.
+func (m HoldModel) GetAll(watchID, clientID int64, status string, filters Filters) ([]*Hold, Metadata, error) {
+	query := fmt.Sprintf(`
+SELECT count(*) OVER(), %s
+FROM holds
+WHERE (watch_id = $1 OR $1 = 0)
+AND (client_id = $2 OR $2 = 0)
+AND (CASE WHEN status = 'active' AND expires_at <= NOW() THEN 'expired' ELSE status END = $3 OR $3 = '')
+ORDER BY %s %s, id ASC
+LIMIT $4 OFFSET $5`, holdColumnsSQL, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, watchID, clientID, status, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	holds := []*Hold{}
+	for rows.Next() {
+		var hold Hold
+		err := rows.Scan(append([]interface{}{&totalRecords}, hold.scanFields()...)...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		holds = append(holds, &hold)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return holds, metadata, nil
+}
+
+// ExpireDue marks every active hold whose expiry has passed as expired, returning the
+// number of holds released. A hold stops reserving its unit as soon as it expires, so
+// the back-in-stock alerts for the affected watches are checked against their state
+// with the expired holds still counted.
+func (m HoldModel) ExpireDue() (int64, error) {
+	query := `
+UPDATE holds
+SET status = 'expired', version = version + 1
+WHERE status = 'active' AND expires_at <= NOW()
+RETURNING watch_id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	var expired int64
+	released := map[int64]int{}
+	for rows.Next() {
+		var watchID int64
+		if err := rows.Scan(&watchID); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		released[watchID]++
+		expired++
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for watchID, n := range released {
+		before, err := readWatchState(ctx, tx, watchID)
+		if err != nil {
+			return 0, err
+		}
+		before.Reserved += n
+		err = queueAlerts(ctx, tx, watchID, before)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return expired, tx.Commit()
+}
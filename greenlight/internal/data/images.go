@@ -0,0 +1,312 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// WatchImage is an uploaded picture of a watch. The original file and its thumbnails
+// are stored under KeyPrefix in the storage backend; URL and Thumbnails are filled in
+// by the API from the storage backend's public URLs.
+type WatchImage struct {
+	ID          int64             `json:"id"`
+	CreatedAt   time.Time         `json:"created_at"`
+	WatchID     int64             `json:"-"`
+	KeyPrefix   string            `json:"-"`
+	ContentType string            `json:"content_type"`
+	Width       int               `json:"width"`
+	Height      int               `json:"height"`
+	Position    int               `json:"position"`
+	Primary     bool              `json:"primary"`
+	URL         string            `json:"url"`
+	Thumbnails  map[string]string `json:"thumbnails"`
+}
+
+// OriginalKey returns the storage key of the uploaded (metadata-stripped) file.
+func (i *WatchImage) OriginalKey() string {
+	if i.ContentType == "image/png" {
+		return i.KeyPrefix + "/original.png"
+	}
+	return i.KeyPrefix + "/original.jpg"
+}
+
+// ThumbnailKey returns the storage key of a named thumbnail. Thumbnails are always
+// JPEGs.
+func (i *WatchImage) ThumbnailKey(size string) string {
+	return i.KeyPrefix + "/" + size + ".jpg"
+}
+
+type WatchImageModel struct {
+	DB *sql.DB
+}
+
+// Insert adds an image at the end of a watch's image list. The first image of a watch
+// becomes its primary image. The watch row is locked so that concurrent uploads get
+// distinct positions.
+func (m WatchImageModel) Insert(img *WatchImage) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var watchID int64
+	err = tx.QueryRowContext(ctx, `SELECT id FROM watches WHERE id = $1 FOR UPDATE`, img.WatchID).Scan(&watchID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	query := `
+INSERT INTO watch_images (watch_id, key_prefix, content_type, width, height, position, is_primary)
+SELECT $1, $2, $3, $4, $5, COALESCE(MAX(position), 0) + 1, COUNT(*) FILTER (WHERE is_primary) = 0
+FROM watch_images
+WHERE watch_id = $1
+RETURNING id, created_at, position, is_primary`
+
+	args := []interface{}{img.WatchID, img.KeyPrefix, img.ContentType, img.Width, img.Height}
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&img.ID, &img.CreatedAt, &img.Position, &img.Primary)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (m WatchImageModel) Get(watchID, imageID int64) (*WatchImage, error) {
+	query := `
+SELECT id, created_at, watch_id, key_prefix, content_type, width, height, position, is_primary
+FROM watch_images
+WHERE watch_id = $1 AND id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var img WatchImage
+	err := m.DB.QueryRowContext(ctx, query, watchID, imageID).Scan(
+		&img.ID,
+		&img.CreatedAt,
+		&img.WatchID,
+		&img.KeyPrefix,
+		&img.ContentType,
+		&img.Width,
+		&img.Height,
+		&img.Position,
+		&img.Primary,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &img, nil
+}
+
+// GetAllForWatches returns the images of each of the given watches in display order,
+// keyed by watch id.
+func (m WatchImageModel) GetAllForWatches(watchIDs ...int64) (map[int64][]*WatchImage, error) {
+	query := `
+SELECT id, created_at, watch_id, key_prefix, content_type, width, height, position, is_primary
+FROM watch_images
+WHERE watch_id = ANY($1)
+ORDER BY watch_id, position`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(watchIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	images := make(map[int64][]*WatchImage)
+	for rows.Next() {
+		var img WatchImage
+		err := rows.Scan(
+			&img.ID,
+			&img.CreatedAt,
+			&img.WatchID,
+			&img.KeyPrefix,
+			&img.ContentType,
+			&img.Width,
+			&img.Height,
+			&img.Position,
+			&img.Primary,
+		)
+		if err != nil {
+			return nil, err
+		}
+		images[img.WatchID] = append(images[img.WatchID], &img)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+// Move changes the position of an image within its watch's list, shifting the other
+// images so that positions stay numbered 1..n.
+func (m WatchImageModel) Move(watchID, imageID int64, position int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	ids, err := lockImageIDs(ctx, tx, watchID)
+	if err != nil {
+		return err
+	}
+
+	ordered := make([]int64, 0, len(ids))
+	found := false
+	for _, id := range ids {
+		if id == imageID {
+			found = true
+			continue
+		}
+		ordered = append(ordered, id)
+	}
+	if !found {
+		return ErrRecordNotFound
+	}
+	position = min(max(position, 1), len(ids))
+	ordered = append(ordered[:position-1], append([]int64{imageID}, ordered[position-1:]...)...)
+
+	err = renumberImages(ctx, tx, ordered)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SetPrimary makes an image the primary image of its watch.
+func (m WatchImageModel) SetPrimary(watchID, imageID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `UPDATE watch_images SET is_primary = false WHERE watch_id = $1 AND is_primary`, watchID)
+	if err != nil {
+		return err
+	}
+	result, err := tx.ExecContext(ctx, `UPDATE watch_images SET is_primary = true WHERE watch_id = $1 AND id = $2`, watchID, imageID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return tx.Commit()
+}
+
+// Delete removes an image record and returns it, so that the caller can remove the
+// stored files. The remaining images are renumbered, and if the primary image was
+// deleted the first remaining image takes its place.
+func (m WatchImageModel) Delete(watchID, imageID int64) (*WatchImage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+DELETE FROM watch_images
+WHERE watch_id = $1 AND id = $2
+RETURNING id, created_at, watch_id, key_prefix, content_type, width, height, position, is_primary`
+
+	var img WatchImage
+	err = tx.QueryRowContext(ctx, query, watchID, imageID).Scan(
+		&img.ID,
+		&img.CreatedAt,
+		&img.WatchID,
+		&img.KeyPrefix,
+		&img.ContentType,
+		&img.Width,
+		&img.Height,
+		&img.Position,
+		&img.Primary,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	ids, err := lockImageIDs(ctx, tx, watchID)
+	if err != nil {
+		return nil, err
+	}
+	err = renumberImages(ctx, tx, ids)
+	if err != nil {
+		return nil, err
+	}
+	if img.Primary && len(ids) > 0 {
+		_, err = tx.ExecContext(ctx, `UPDATE watch_images SET is_primary = true WHERE id = $1`, ids[0])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &img, tx.Commit()
+}
+
+func lockImageIDs(ctx context.Context, tx *sql.Tx, watchID int64) ([]int64, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT id FROM watch_images WHERE watch_id = $1 ORDER BY position FOR UPDATE`, watchID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		err := rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func renumberImages(ctx context.Context, tx *sql.Tx, ids []int64) error {
+	query := `
+UPDATE watch_images
+SET position = ordered.position
+FROM unnest($1::bigint[]) WITH ORDINALITY AS ordered(id, position)
+WHERE watch_images.id = ordered.id`
+	_, err := tx.ExecContext(ctx, query, pq.Array(ids))
+	return err
+}
@@ -0,0 +1,283 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+// The kinds of stock movement recorded in the ledger.
+const (
+	MovementReceive  = "receive"
+	MovementTransfer = "transfer"
+	MovementSell     = "sell"
+	MovementAdjust   = "adjust"
+)
+
+var MovementTypes = []string{MovementReceive, MovementTransfer, MovementSell, MovementAdjust}
+
+var ErrStockReserved = errors.New("stock is reserved")
+
+// StockLevel is the number of units of a watch held at a location. A Version of 0
+// means that no row exists yet for the watch and location.
+type StockLevel struct {
+	WatchID      int64  `json:"watch_id"`
+	LocationID   int64  `json:"location_id"`
+	LocationName string `json:"location_name"`
+	Quantity     int    `json:"quantity"`
+	Version      int32  `json:"version"`
+}
+
+// StockMovement is an entry in the append-only stock ledger. Quantity is always
+// positive: stock leaves FromLocationID and arrives at ToLocationID, and whichever
+// side doesn't apply is nil (e.g. a sale has no destination).
+type StockMovement struct {
+	ID             int64     `json:"id"`
+	CreatedAt      time.Time `json:"created_at"`
+	WatchID        int64     `json:"watch_id"`
+	Type           string    `json:"type"`
+	FromLocationID *int64    `json:"from_location_id"`
+	ToLocationID   *int64    `json:"to_location_id"`
+	Quantity       int       `json:"quantity"`
+	Reason         string    `json:"reason,omitempty"`
+	UserID         int64     `json:"user_id"`
+}
+
+func ValidateStockMovement(v *validator.Validator, movement *StockMovement) {
+	v.Check(validator.In(movement.Type, MovementTypes...), "type", "must be one of receive, transfer, sell or adjust")
+	v.Check(movement.Quantity > 0, "quantity", "must be greater than zero")
+	v.Check(movement.Quantity <= 10_000, "quantity", "must not be more than 10000")
+	v.Check(len(movement.Reason) <= 500, "reason", "must not be more than 500 bytes long")
+
+	from, to := movement.FromLocationID != nil, movement.ToLocationID != nil
+	switch movement.Type {
+	case MovementReceive:
+		v.Check(to, "to_location_id", "must be provided")
+		v.Check(!from, "from_location_id", "must not be provided when receiving stock")
+	case MovementSell:
+		v.Check(from, "from_location_id", "must be provided")
+		v.Check(!to, "to_location_id", "must not be provided when selling stock")
+	case MovementTransfer:
+		v.Check(from, "from_location_id", "must be provided")
+		v.Check(to, "to_location_id", "must be provided")
+		v.Check(!from || !to || *movement.FromLocationID != *movement.ToLocationID, "to_location_id", "must be different from the source location")
+	case MovementAdjust:
+		v.Check(from != to, "location", "exactly one of from_location_id (decrease) or to_location_id (increase) must be provided")
+		v.Check(movement.Reason != "", "reason", "must be provided for adjustments")
+	}
+}
+
+type InventoryModel struct {
+	DB *sql.DB
+}
+
+// GetLevel returns the stock of a watch at a location. If the location has never held
+// the watch a zero StockLevel with Version 0 is returned.
+func (m InventoryModel) GetLevel(watchID, locationID int64) (*StockLevel, error) {
+	query := `
+SELECT locations.name, COALESCE(stock_levels.quantity, 0), COALESCE(stock_levels.version, 0)
+FROM locations
+LEFT JOIN stock_levels ON stock_levels.location_id = locations.id AND stock_levels.watch_id = $1
+WHERE locations.id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	level := StockLevel{WatchID: watchID, LocationID: locationID}
+	err := m.DB.QueryRowContext(ctx, query, watchID, locationID).Scan(&level.LocationName, &level.Quantity, &level.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &level, nil
+}
+
+// GetLevels returns the non-zero stock levels matching a watch or a location. Pass 0
+// to leave either criterion out.
+func (m InventoryModel) GetLevels(watchID, locationID int64) ([]*StockLevel, error) {
+	query := `
+SELECT stock_levels.watch_id, stock_levels.location_id, locations.name, stock_levels.quantity, stock_levels.version
+FROM stock_levels
+INNER JOIN locations ON locations.id = stock_levels.location_id
+WHERE (stock_levels.watch_id = $1 OR $1 = 0)
+AND (stock_levels.location_id = $2 OR $2 = 0)
+AND stock_levels.quantity > 0
+ORDER BY stock_levels.watch_id, locations.name`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, watchID, locationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	levels := []*StockLevel{}
+	for rows.Next() {
+		var level StockLevel
+		err := rows.Scan(&level.WatchID, &level.LocationID, &level.LocationName, &level.Quantity, &level.Version)
+		if err != nil {
+			return nil, err
+		}
+		levels = append(levels, &level)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return levels, nil
+}
+
+// ApplyMovement saves the new quantities of the given stock levels and appends the
+// movement to the ledger in a single transaction. As with WatchesModel.Update(), each
+// level is only written if its version is unchanged since it was read, and
+// ErrEditConflict is returned otherwise. The watch row is locked as in
+// HoldModel.Insert(), and a movement that would take the stock below the units
+// reserved by holds, orders and auctions returns ErrStockReserved.
+func (m InventoryModel) ApplyMovement(movement *StockMovement, levels ...*StockLevel) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `SELECT 1 FROM watches WHERE id = $1 FOR UPDATE`, movement.WatchID)
+	if err != nil {
+		return err
+	}
+	before, err := readWatchState(ctx, tx, movement.WatchID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	for _, level := range levels {
+		err = saveStockLevel(ctx, tx, level)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Transfers and receipts never reduce the total, so only sales and downward
+	// adjustments can eat into reserved units.
+	after, err := readWatchState(ctx, tx, movement.WatchID)
+	if err != nil {
+		return err
+	}
+	if after.Stock < before.Stock && after.Stock < after.Reserved {
+		return ErrStockReserved
+	}
+
+	query := `
+INSERT INTO stock_movements (watch_id, type, from_location_id, to_location_id, quantity, reason, user_id)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+RETURNING id, created_at`
+
+	args := []interface{}{movement.WatchID, movement.Type, movement.FromLocationID, movement.ToLocationID, movement.Quantity, movement.Reason, movement.UserID}
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&movement.ID, &movement.CreatedAt)
+	if err != nil {
+		return err
+	}
+	err = queueAlerts(ctx, tx, movement.WatchID, before)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func saveStockLevel(ctx context.Context, tx *sql.Tx, level *StockLevel) error {
+	var query string
+	var args []interface{}
+	if level.Version == 0 {
+		// Nobody has stocked this watch here yet; if a concurrent request got there
+		// first the insert does nothing and we report a conflict.
+		query = `
+INSERT INTO stock_levels (watch_id, location_id, quantity)
+VALUES ($1, $2, $3)
+ON CONFLICT (watch_id, location_id) DO NOTHING
+RETURNING version`
+		args = []interface{}{level.WatchID, level.LocationID, level.Quantity}
+	} else {
+		query = `
+UPDATE stock_levels
+SET quantity = $1, version = version + 1
+WHERE watch_id = $2 AND location_id = $3 AND version = $4
+RETURNING version`
+		args = []interface{}{level.Quantity, level.WatchID, level.LocationID, level.Version}
+	}
+
+	err := tx.QueryRowContext(ctx, query, args...).Scan(&level.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// GetMovements returns the ledger entries for a watch, newest first by default,
+// optionally limited to movements into or out of one location.
+func (m InventoryModel) GetMovements(watchID, locationID int64, filters Filters) ([]*StockMovement, Metadata, error) {
+	query := fmt.Sprintf(`
+SELECT count(*) OVER(), id, created_at, watch_id, type, from_location_id, to_location_id, quantity, reason, user_id
+FROM stock_movements
+WHERE watch_id = $1
+AND (from_location_id = $2 OR to_location_id = $2 OR $2 = 0)
+ORDER BY %s %s, id DESC
+LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, watchID, locationID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	movements := []*StockMovement{}
+	for rows.Next() {
+		var movement StockMovement
+		err := rows.Scan(
+			&totalRecords,
+			&movement.ID,
+			&movement.CreatedAt,
+			&movement.WatchID,
+			&movement.Type,
+			&movement.FromLocationID,
+			&movement.ToLocationID,
+			&movement.Quantity,
+			&movement.Reason,
+			&movement.UserID,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		movements = append(movements, &movement)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return movements, metadata, nil
+}
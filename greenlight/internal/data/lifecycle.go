@@ -0,0 +1,118 @@
+package data
+
+import (
+	"context"
+	"time"
+
+	"github.com/lib/pq"
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+// The states of a watch listing. Only published watches are shown to customers; a
+// scheduled watch is published automatically once its PublishAt time has passed.
+const (
+	WatchDraft     = "draft"
+	WatchScheduled = "scheduled"
+	WatchPublished = "published"
+	WatchArchived  = "archived"
+)
+
+var WatchStatuses = []string{WatchDraft, WatchScheduled, WatchPublished, WatchArchived}
+
+// Publish publishes the watch now, or schedules it when at is in the future. A zero at
+// means now.
+func (w *Watches) Publish(at time.Time) {
+	now := time.Now()
+	if at.After(now) {
+		w.Status = WatchScheduled
+		w.PublishAt = &at
+		return
+	}
+	w.Status = WatchPublished
+	w.PublishAt = nil
+	w.PublishedAt = &now
+}
+
+// Unpublish takes the watch off the storefront, back to a draft or into the archive.
+// PublishedAt is kept as a record of when it was last live.
+func (w *Watches) Unpublish(status string) {
+	w.Status = status
+	w.PublishAt = nil
+}
+
+func ValidateWatchStatus(v *validator.Validator, watch *Watches) {
+	v.Check(validator.In(watch.Status, WatchStatuses...), "status", "must be draft, scheduled, published or archived")
+	if watch.Status == WatchScheduled {
+		v.Check(watch.PublishAt != nil, "publish_at", "must be provided")
+	}
+}
+
+// PublishDue publishes the scheduled watches whose time has come, queues the alerts
+// that publishing them fires, and returns how many there were. Bumping the version
+// makes concurrent edits of those watches fail with an edit conflict rather than
+// overwrite the new status. Watches being edited at the same moment are skipped and
+// picked up on the next run.
+func (w WatchesModel) PublishDue() (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := w.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	query := `
+SELECT id
+FROM watches
+WHERE status = 'scheduled' AND publish_at <= NOW()
+ORDER BY id
+FOR UPDATE SKIP LOCKED`
+
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	before := make(map[int64]watchState, len(ids))
+	for _, id := range ids {
+		before[id], err = readWatchState(ctx, tx, id)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	query = `
+UPDATE watches
+SET status = 'published', publish_at = NULL, published_at = NOW(), version = version + 1
+WHERE id = ANY($1)`
+
+	_, err = tx.ExecContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return 0, err
+	}
+
+	for _, id := range ids {
+		err = queueAlerts(ctx, tx, id, before[id])
+		if err != nil {
+			return 0, err
+		}
+	}
+	return int64(len(ids)), tx.Commit()
+}
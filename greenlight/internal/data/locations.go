@@ -0,0 +1,135 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+var ErrDuplicateLocation = errors.New("duplicate location name")
+
+// Location is a boutique, warehouse or other place where watches are held in stock.
+type Location struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Name      string    `json:"name"`
+	Address   string    `json:"address,omitempty"`
+	Version   int32     `json:"version"`
+}
+
+func ValidateLocation(v *validator.Validator, location *Location) {
+	v.Check(location.Name != "", "name", "must be provided")
+	v.Check(len(location.Name) <= 200, "name", "must not be more than 200 bytes long")
+	v.Check(len(location.Address) <= 1000, "address", "must not be more than 1000 bytes long")
+}
+
+type LocationModel struct {
+	DB *sql.DB
+}
+
+func (m LocationModel) Insert(location *Location) error {
+	query := `
+INSERT INTO locations (name, address)
+VALUES ($1, $2)
+RETURNING id, created_at, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, location.Name, location.Address).Scan(&location.ID, &location.CreatedAt, &location.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "locations_name_key"`:
+			return ErrDuplicateLocation
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (m LocationModel) Get(id int64) (*Location, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `
+SELECT id, created_at, name, address, version
+FROM locations
+WHERE id = $1`
+
+	var location Location
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&location.ID, &location.CreatedAt, &location.Name, &location.Address, &location.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &location, nil
+}
+
+func (m LocationModel) Update(location *Location) error {
+	query := `
+UPDATE locations
+SET name = $1, address = $2, version = version + 1
+WHERE id = $3 AND version = $4
+RETURNING version`
+
+	args := []interface{}{location.Name, location.Address, location.ID, location.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&location.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "locations_name_key"`:
+			return ErrDuplicateLocation
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// GetAll returns every location ordered by name. There are only ever a handful of
+// locations, so the list isn't paginated.
+func (m LocationModel) GetAll() ([]*Location, error) {
+	query := `
+SELECT id, created_at, name, address, version
+FROM locations
+ORDER BY name, id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	locations := []*Location{}
+	for rows.Next() {
+		var location Location
+		err := rows.Scan(&location.ID, &location.CreatedAt, &location.Name, &location.Address, &location.Version)
+		if err != nil {
+			return nil, err
+		}
+		locations = append(locations, &location)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return locations, nil
+}
@@ -8,61 +8,143 @@ import (
 	"fmt"
 	"github.com/lib/pq"
 	"greenlight.alexedwards.net/internal/validator"
+	"strings"
 	"time"
 )
 
 // Define a custom ErrEditConflict error.
 var ErrEditConflict = errors.New("edit conflict: record has been modified")
 
+// ErrWatchInUse is returned when deleting a watch that other records, such as order
+// lines, still need.
+var ErrWatchInUse = errors.New("watch is referenced by other records")
+
 type Watches struct {
-	ID        int64     `json:"id"`
-	CreatedAt time.Time `json:"-"`
-	Title     string    `json:"title"`
-	Year      int32     `json:"year,omitempty"`
-	Price     float64   `json:"price,omitempty"`
-	Brand     []string  `json:"watchesBrand,omitempty"`
-	Material  []string  `json:"watchesMaterial,omitempty"`
-	Version   int32     `json:"version"`
+	ID             int64         `json:"id"`
+	CreatedAt      time.Time     `json:"-"`
+	Title          string        `json:"title"`
+	Year           int32         `json:"year,omitempty"`
+	Price          Money         `json:"price"`
+	ConvertedPrice *Money        `json:"converted_price,omitempty"`
+	Brand          []string      `json:"watchesBrand,omitempty"`
+	Material       []string      `json:"watchesMaterial,omitempty"`
+	BrandIDs       []int64       `json:"-"`
+	MaterialIDs    []int64       `json:"-"`
+	NewBrands      []string      `json:"-"`
+	NewMaterials   []string      `json:"-"`
+	Specs          Specs         `json:"specs,omitempty"`
+	Tags           []string      `json:"tags,omitempty"`
+	Images         []*WatchImage `json:"images,omitempty"`
+	Categories     []Breadcrumb  `json:"categories,omitempty"`
+	Available      bool          `json:"available"`
+	Rating         *float64      `json:"rating,omitempty"`
+	ReviewCount    int           `json:"review_count"`
+	Status         string        `json:"status"`
+	PublishAt      *time.Time    `json:"publish_at,omitempty"`
+	PublishedAt    *time.Time    `json:"published_at,omitempty"`
+	Version        int32         `json:"version"`
+}
+
+// watchesColumnsSQL lists the columns selected for a watch, in the same order as the
+// destinations returned by scanFields(). Brands, materials and tags are read from their
+// link tables, a watch is available when it has stock that isn't on hold, and its
+// rating comes from its approved reviews.
+func watchesColumnsSQL() string {
+	return fmt.Sprintf(`watches.id, watches.created_at, title, year, price_amount, price_currency, %s, %s, specs, %s, %s, %s, %s, watches.status, watches.publish_at, watches.published_at, watches.version`,
+		brandsTable.watchColumnsSQL(), materialsTable.watchColumnsSQL(), watchTagsSQL, watchAvailableSQL, watchRatingSQL, watchReviewCountSQL)
+}
+
+func (w *Watches) scanFields() []interface{} {
+	return []interface{}{
+		&w.ID,
+		&w.CreatedAt,
+		&w.Title,
+		&w.Year,
+		&w.Price.Amount,
+		&w.Price.Currency,
+		pq.Array(&w.BrandIDs),
+		pq.Array(&w.Brand),
+		pq.Array(&w.MaterialIDs),
+		pq.Array(&w.Material),
+		&w.Specs,
+		pq.Array(&w.Tags),
+		&w.Available,
+		&w.Rating,
+		&w.ReviewCount,
+		&w.Status,
+		&w.PublishAt,
+		&w.PublishedAt,
+		&w.Version,
+	}
 }
 
 type WatchesModel struct {
 	DB *sql.DB
 }
 
+// Insert adds a watch and links it to the brands and materials in BrandIDs and
+// MaterialIDs, creating those in NewBrands and NewMaterials first, all in one
+// transaction.
 func (w *WatchesModel) Insert(watches *Watches) error {
 	query := `
-		INSERT INTO watches (title, year, price, brand, material)
-		VALUES ($1, $2, $3, $4, $5)
+		INSERT INTO watches (title, year, price_amount, price_currency, specs, status, publish_at, published_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
 		RETURNING id, created_at, version`
-	args := []interface{}{watches.Title, watches.Year, watches.Price, pq.Array(watches.Brand), pq.Array(watches.Material)}
+	args := []interface{}{watches.Title, watches.Year, watches.Price.Amount, watches.Price.Currency, watches.Specs,
+		watches.Status, watches.PublishAt, watches.PublishedAt}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
-	return w.DB.QueryRowContext(ctx, query, args...).Scan(&watches.ID, &watches.CreatedAt, &watches.Version)
+
+	tx, err := w.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&watches.ID, &watches.CreatedAt, &watches.Version)
+	if err != nil {
+		return err
+	}
+	err = w.setLinks(ctx, tx, watches)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (w WatchesModel) setLinks(ctx context.Context, tx *sql.Tx, watch *Watches) error {
+	ids, err := brandsTable.create(ctx, tx, watch.NewBrands)
+	if err != nil {
+		return err
+	}
+	watch.BrandIDs, watch.NewBrands = append(watch.BrandIDs, ids...), nil
+	ids, err = materialsTable.create(ctx, tx, watch.NewMaterials)
+	if err != nil {
+		return err
+	}
+	watch.MaterialIDs, watch.NewMaterials = append(watch.MaterialIDs, ids...), nil
+
+	err = brandsTable.setLinks(ctx, tx, watch.ID, watch.BrandIDs)
+	if err != nil {
+		return err
+	}
+	return materialsTable.setLinks(ctx, tx, watch.ID, watch.MaterialIDs)
 }
 
 func (w WatchesModel) Get(id int64) (*Watches, error) {
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
-	query := `
-SELECT id, created_at, title, year, price, brand, material, version
+	query := fmt.Sprintf(`
+SELECT %s
 FROM watches
-WHERE id = $1`
+WHERE id = $1`, watchesColumnsSQL())
 
 	var watch Watches
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := w.DB.QueryRowContext(ctx, query, id).Scan(
-		&watch.ID,
-		&watch.CreatedAt,
-		&watch.Title,
-		&watch.Year,
-		&watch.Price,
-		pq.Array(&watch.Brand),
-		pq.Array(&watch.Material),
-		&watch.Version,
-	)
+	err := w.DB.QueryRowContext(ctx, query, id).Scan(watch.scanFields()...)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -74,26 +156,39 @@ WHERE id = $1`
 	return &watch, nil
 }
 
-func (w WatchesModel) Update(watch *Watches) error {
+// Update saves a changed watch, using the version number to detect concurrent changes.
+// A change of price is recorded in the price history against userID, and the watch's
+// alerts are checked, in the same transaction.
+func (w WatchesModel) Update(watch *Watches, userID int64) error {
 	query := `
 UPDATE watches
-SET title = $1, year = $2, price = $3, brand = $4, material = $5, version = version + 1
-WHERE id = $6 AND version = $7
+SET title = $1, year = $2, price_amount = $3, price_currency = $4, specs = $5,
+	status = $6, publish_at = $7, published_at = $8, version = version + 1
+WHERE id = $9 AND version = $10
 RETURNING version`
 
 	args := []interface{}{
 		watch.Title,
 		watch.Year,
-		watch.Price,
-		pq.Array(watch.Brand),
-		pq.Array(watch.Material),
+		watch.Price.Amount,
+		watch.Price.Currency,
+		watch.Specs,
+		watch.Status,
+		watch.PublishAt,
+		watch.PublishedAt,
 		watch.ID,
 		watch.Version,
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := w.DB.QueryRowContext(ctx, query, args...).Scan(&watch.Version)
+	tx, err := w.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	before, err := readWatchState(ctx, tx, watch.ID)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -102,7 +197,32 @@ RETURNING version`
 			return err
 		}
 	}
-	return nil
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&watch.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	err = w.setLinks(ctx, tx, watch)
+	if err != nil {
+		return err
+	}
+	if watch.Price != before.Price {
+		change := &PriceChange{WatchID: watch.ID, OldPrice: before.Price, NewPrice: watch.Price, UserID: &userID}
+		err = insertPriceChange(ctx, tx, change)
+		if err != nil {
+			return err
+		}
+	}
+	err = queueAlerts(ctx, tx, watch.ID, before)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 func (w WatchesModel) Delete(id int64) error {
@@ -117,6 +237,10 @@ WHERE id = $1`
 
 	result, err := w.DB.ExecContext(ctx, query, id)
 	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23503" {
+			return ErrWatchInUse
+		}
 		return err
 	}
 	rowsAffected, err := result.RowsAffected()
@@ -129,18 +253,104 @@ WHERE id = $1`
 	return nil
 }
 
-func (w WatchesModel) GetAll(title string, filters Filters) ([]*Watches, Metadata, error) {
+// WatchesQuery holds the optional search criteria for listing watches. Empty fields
+// are ignored.
+type WatchesQuery struct {
+	Title    string
+	Brand    string
+	Material string
+	// Category matches watches in the category with this slug or any category
+	// below it.
+	Category string
+	// Tags matches watches with any of these tag slugs, or with all of them when
+	// AllTags is set.
+	Tags    []string
+	AllTags bool
+	Specs   []*SpecFilter
+	// Statuses restricts the list to watches in one of the given states.
+	Statuses []string
+	// AddedAfter and AddedBefore restrict the list to watches published in a period,
+	// and are used for the saved search digests.
+	AddedAfter  time.Time
+	AddedBefore time.Time
+	// PriceChangedSince restricts the list to watches whose price has changed since
+	// the given time. PriceChange, if set, further restricts it to the watches whose
+	// price is now lower ("down") or higher ("up") than it was then.
+	PriceChangedSince time.Time
+	PriceChange       string
+}
+
+// where builds the WHERE clause for a WatchesQuery, appending the placeholder values to
+// args.
+func (q WatchesQuery) where(args *[]interface{}) string {
+	arg := func(value interface{}) string {
+		*args = append(*args, value)
+		return fmt.Sprintf("$%d", len(*args))
+	}
+
+	conditions := []string{"true"}
+	if q.Title != "" {
+		conditions = append(conditions, fmt.Sprintf("to_tsvector('simple', title) @@ plainto_tsquery('simple', %s)", arg(q.Title)))
+	}
+	if q.Brand != "" {
+		conditions = append(conditions, brandsTable.watchFilterSQL(arg(Slugify(q.Brand))))
+	}
+	if q.Material != "" {
+		conditions = append(conditions, materialsTable.watchFilterSQL(arg(Slugify(q.Material))))
+	}
+	if q.Category != "" {
+		conditions = append(conditions, fmt.Sprintf(
+			"EXISTS (SELECT 1 FROM watches_categories WHERE watches_categories.watch_id = watches.id AND watches_categories.category_id IN (%s))",
+			categorySubtreeSQL(arg(q.Category))))
+	}
+	if len(q.Tags) > 0 {
+		conditions = append(conditions, tagsFilterSQL(arg(pq.Array(q.Tags)), len(q.Tags), q.AllTags))
+	}
+	for _, filter := range q.Specs {
+		conditions = append(conditions, filter.sql(arg))
+	}
+	if len(q.Statuses) > 0 {
+		conditions = append(conditions, fmt.Sprintf("watches.status = ANY(%s)", arg(pq.Array(q.Statuses))))
+	}
+	if !q.AddedAfter.IsZero() {
+		conditions = append(conditions, fmt.Sprintf("watches.published_at > %s", arg(q.AddedAfter)))
+	}
+	if !q.AddedBefore.IsZero() {
+		conditions = append(conditions, fmt.Sprintf("watches.published_at <= %s", arg(q.AddedBefore)))
+	}
+	if !q.PriceChangedSince.IsZero() {
+		since := arg(q.PriceChangedSince)
+		conditions = append(conditions, fmt.Sprintf(
+			"EXISTS (SELECT 1 FROM price_history WHERE price_history.watch_id = watches.id AND price_history.changed_at >= %s)", since))
+		// The price at the start of the period is the old price of the first change
+		// in it. Prices that were in another currency then can't be compared.
+		startPrice := fmt.Sprintf(`(SELECT price_history.old_amount FROM price_history
+	WHERE price_history.watch_id = watches.id AND price_history.changed_at >= %s AND price_history.old_currency = watches.price_currency
+	ORDER BY price_history.changed_at, price_history.id LIMIT 1)`, since)
+		switch q.PriceChange {
+		case "down":
+			conditions = append(conditions, fmt.Sprintf("%s > watches.price_amount", startPrice))
+		case "up":
+			conditions = append(conditions, fmt.Sprintf("%s < watches.price_amount", startPrice))
+		}
+	}
+	return strings.Join(conditions, " AND ")
+}
+
+func (w WatchesModel) GetAll(q WatchesQuery, filters Filters) ([]*Watches, Metadata, error) {
+	args := []interface{}{}
+	where := q.where(&args)
+	args = append(args, filters.limit(), filters.offset())
 	query := fmt.Sprintf(`
-SELECT count(*) OVER(), id, created_at, title, year, price, brand, material, version
-FROM watches
-WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
-ORDER BY %s %s, id ASC
-LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+SELECT count(*) OVER(), %s
+FROM watches %s
+WHERE %s
+ORDER BY %s, id ASC
+LIMIT $%d OFFSET $%d`, watchesColumnsSQL(), latestRateJoinSQL, where, watchesOrderBy(filters), len(args)-1, len(args))
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	args := []interface{}{title, filters.limit(), filters.offset()}
 	rows, err := w.DB.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, Metadata{}, err
@@ -151,17 +361,7 @@ LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
 	watches := []*Watches{}
 	for rows.Next() {
 		var watch Watches
-		err := rows.Scan(
-			&totalRecords,
-			&watch.ID,
-			&watch.CreatedAt,
-			&watch.Title,
-			&watch.Year,
-			&watch.Price,
-			pq.Array(&watch.Brand),
-			pq.Array(&watch.Material),
-			&watch.Version,
-		)
+		err := rows.Scan(append([]interface{}{&totalRecords}, watch.scanFields()...)...)
 		if err != nil {
 			return nil, Metadata{}, err
 		}
@@ -175,6 +375,23 @@ LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
 	return watches, metadata, nil
 }
 
+// watchesOrderBy builds the ORDER BY expression for a watches listing. Prices in
+// different currencies are compared by converting them into the base currency using
+// the latest exchange rates; watches with no known rate, like watches with no rating,
+// are sorted last.
+func watchesOrderBy(filters Filters) string {
+	column, direction := filters.sortColumn(), filters.sortDirection()
+	switch column {
+	case "price":
+		return fmt.Sprintf("%s %s NULLS LAST", priceInBaseCurrencySQL(), direction)
+	case "brand":
+		return fmt.Sprintf("%s %s", brandsTable.watchSortSQL(), direction)
+	case "rating":
+		return fmt.Sprintf("%s %s NULLS LAST", watchRatingSQL, direction)
+	}
+	return fmt.Sprintf("%s %s", column, direction)
+}
+
 func (w Watches) MarshalJSON() ([]byte, error) {
 	type WatchAlias Watches
 	aux := struct {
@@ -195,7 +412,7 @@ func ValidateWatches(v *validator.Validator, watches *Watches) {
 	v.Check(watches.Year != 0, "year", "must be provided")
 	v.Check(watches.Year >= 1888, "year", "must be greater than 1888")
 	v.Check(watches.Year <= int32(time.Now().Year()), "year", "must not be in the future")
-	v.Check(watches.Price >= 0, "price", "must be a positive number")
+	ValidateMoney(v, "price", watches.Price)
 	v.Check(len(watches.Brand) > 0, "watchesBrand", "must be provided")
 	v.Check(len(watches.Material) > 0, "watchesMaterial", "must be provided")
 }
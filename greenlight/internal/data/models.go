@@ -16,6 +16,32 @@ type Models struct {
 	Tokens      TokenModel // Add a new Tokens field.
 	Permissions PermissionModel
 	Users       UserModel
+	Rates       ExchangeRateModel
+	Brands      CatalogEntityModel
+	Materials   CatalogEntityModel
+	Specs       SpecAttributeModel
+	Images      WatchImageModel
+	Locations   LocationModel
+	Inventory   InventoryModel
+	Holds       HoldModel
+	Orders      OrderModel
+	Cart        CartModel
+	Payments    PaymentModel
+	Auctions    AuctionModel
+	Wishlists   WishlistModel
+	Alerts      AlertModel
+	Searches    SavedSearchModel
+	Reviews     ReviewModel
+	Analytics   AnalyticsModel
+	Prices      PriceHistoryModel
+	Changes     ChangeRequestModel
+	Categories  CategoryModel
+	Collections CollectionModel
+	Tags        TagModel
+	Provenance  ProvenanceModel
+	Serials     SerialModel
+	Reports     RegistryReportModel
+	Service     ServiceRecordModel
 }
 
 func NewWatchesModel(db *sql.DB) Models {
@@ -24,5 +50,31 @@ func NewWatchesModel(db *sql.DB) Models {
 		Permissions: PermissionModel{DB: db},
 		Tokens:      TokenModel{DB: db}, // Initialize a new TokenModel instance.
 		Users:       UserModel{DB: db},
+		Rates:       ExchangeRateModel{DB: db},
+		Brands:      brandsTable.withDB(db),
+		Materials:   materialsTable.withDB(db),
+		Specs:       SpecAttributeModel{DB: db},
+		Images:      WatchImageModel{DB: db},
+		Locations:   LocationModel{DB: db},
+		Inventory:   InventoryModel{DB: db},
+		Holds:       HoldModel{DB: db},
+		Orders:      OrderModel{DB: db},
+		Cart:        CartModel{DB: db},
+		Payments:    PaymentModel{DB: db},
+		Auctions:    AuctionModel{DB: db},
+		Wishlists:   WishlistModel{DB: db},
+		Alerts:      AlertModel{DB: db},
+		Searches:    SavedSearchModel{DB: db},
+		Reviews:     ReviewModel{DB: db},
+		Analytics:   AnalyticsModel{DB: db},
+		Prices:      PriceHistoryModel{DB: db},
+		Changes:     ChangeRequestModel{DB: db},
+		Categories:  CategoryModel{DB: db},
+		Collections: CollectionModel{DB: db},
+		Tags:        TagModel{DB: db},
+		Provenance:  ProvenanceModel{DB: db},
+		Serials:     SerialModel{DB: db},
+		Reports:     RegistryReportModel{DB: db},
+		Service:     ServiceRecordModel{DB: db},
 	}
 }
@@ -0,0 +1,158 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+var ErrInvalidMoneyFormat = errors.New("invalid money format")
+
+// currencyExponents holds the ISO 4217 currencies that we accept, mapped to the number
+// of decimal places used by their minor unit (e.g. 2 for cents, 0 for yen).
+var currencyExponents = map[string]int{
+	"AED": 2,
+	"AUD": 2,
+	"BHD": 3,
+	"CAD": 2,
+	"CHF": 2,
+	"CNY": 2,
+	"EUR": 2,
+	"GBP": 2,
+	"HKD": 2,
+	"JPY": 0,
+	"KWD": 3,
+	"SGD": 2,
+	"USD": 2,
+}
+
+// Money holds an exact monetary amount as an integer number of minor units (e.g.
+// cents) together with its ISO 4217 currency code. It is encoded in JSON and text as a
+// string in the format "12500.00 CHF", and stored in SQL as separate amount and
+// currency columns.
+type Money struct {
+	Amount   int64
+	Currency string
+}
+
+// SupportedCurrency returns true if the currency code is one that we accept.
+func SupportedCurrency(currency string) bool {
+	_, ok := currencyExponents[currency]
+	return ok
+}
+
+// currencyExponent returns the number of minor unit digits for a currency, falling
+// back to 2 for codes that aren't in our list.
+func currencyExponent(currency string) int {
+	if exp, ok := currencyExponents[currency]; ok {
+		return exp
+	}
+	return 2
+}
+
+func pow10(n int) int64 {
+	p := int64(1)
+	for i := 0; i < n; i++ {
+		p *= 10
+	}
+	return p
+}
+
+// String formats the amount using the currency's minor unit, followed by the currency
+// code.
+func (m Money) String() string {
+	exp := currencyExponent(m.Currency)
+	sign := ""
+	amount := m.Amount
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	if exp == 0 {
+		return fmt.Sprintf("%s%d %s", sign, amount, m.Currency)
+	}
+	unit := pow10(exp)
+	return fmt.Sprintf("%s%d.%0*d %s", sign, amount/unit, exp, amount%unit, m.Currency)
+}
+
+// ParseMoney parses a string in the format "12500.00 CHF" into a Money value without
+// going through floating point, so no precision is lost.
+func ParseMoney(s string) (Money, error) {
+	parts := strings.Split(s, " ")
+	if len(parts) != 2 || len(parts[1]) != 3 || strings.ToUpper(parts[1]) != parts[1] {
+		return Money{}, ErrInvalidMoneyFormat
+	}
+	currency := parts[1]
+	exp := currencyExponent(currency)
+
+	number := parts[0]
+	negative := strings.HasPrefix(number, "-")
+	number = strings.TrimPrefix(number, "-")
+
+	whole, fraction, found := strings.Cut(number, ".")
+	if whole == "" || (found && (fraction == "" || len(fraction) > exp)) {
+		return Money{}, ErrInvalidMoneyFormat
+	}
+	for _, digits := range []string{whole, fraction} {
+		if strings.ContainsFunc(digits, func(r rune) bool { return r < '0' || r > '9' }) {
+			return Money{}, ErrInvalidMoneyFormat
+		}
+	}
+
+	units, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil {
+		return Money{}, ErrInvalidMoneyFormat
+	}
+	var minor int64
+	if fraction != "" {
+		minor, err = strconv.ParseInt(fraction+strings.Repeat("0", exp-len(fraction)), 10, 64)
+		if err != nil {
+			return Money{}, ErrInvalidMoneyFormat
+		}
+	}
+
+	unit := pow10(exp)
+	if units > (math.MaxInt64-minor)/unit {
+		return Money{}, ErrInvalidMoneyFormat
+	}
+	amount := units*unit + minor
+	if negative {
+		amount = -amount
+	}
+	return Money{Amount: amount, Currency: currency}, nil
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(m.String())), nil
+}
+
+func (m *Money) UnmarshalJSON(jsonValue []byte) error {
+	unquotedJSONValue, err := strconv.Unquote(string(jsonValue))
+	if err != nil {
+		return ErrInvalidMoneyFormat
+	}
+	return m.UnmarshalText([]byte(unquotedJSONValue))
+}
+
+func (m Money) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+func (m *Money) UnmarshalText(text []byte) error {
+	parsed, err := ParseMoney(string(text))
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+func ValidateMoney(v *validator.Validator, key string, m Money) {
+	v.Check(m.Currency != "", key, "must include a currency")
+	v.Check(SupportedCurrency(m.Currency), key, "must use a supported ISO 4217 currency code")
+	v.Check(m.Amount >= 0, key, "must not be negative")
+}
@@ -0,0 +1,96 @@
+package data
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		input string
+		want  Money
+		err   error
+	}{
+		{"12500.00 CHF", Money{1250000, "CHF"}, nil},
+		{"12500 CHF", Money{1250000, "CHF"}, nil},
+		{"0.5 USD", Money{50, "USD"}, nil},
+		{"0.05 USD", Money{5, "USD"}, nil},
+		{"0 EUR", Money{0, "EUR"}, nil},
+		{"-12.34 USD", Money{-1234, "USD"}, nil},
+		{"1500000 JPY", Money{1500000, "JPY"}, nil},
+		{"1.234 KWD", Money{1234, "KWD"}, nil},
+		{"-1.2 BHD", Money{-1200, "BHD"}, nil},
+		{"3.14 XYZ", Money{314, "XYZ"}, nil},
+		{"92233720368547758.07 USD", Money{math.MaxInt64, "USD"}, nil},
+		{"-92233720368547758.07 USD", Money{-math.MaxInt64, "USD"}, nil},
+		{"9223372036854775807 JPY", Money{math.MaxInt64, "JPY"}, nil},
+
+		// Amounts are never rounded: more decimal places than the currency has is
+		// an error.
+		{"1.005 USD", Money{}, ErrInvalidMoneyFormat},
+		{"1.5 JPY", Money{}, ErrInvalidMoneyFormat},
+		{"1.2345 KWD", Money{}, ErrInvalidMoneyFormat},
+
+		{"92233720368547758.08 USD", Money{}, ErrInvalidMoneyFormat},
+		{"9223372036854775808 JPY", Money{}, ErrInvalidMoneyFormat},
+		{"99999999999999999999 USD", Money{}, ErrInvalidMoneyFormat},
+
+		{"", Money{}, ErrInvalidMoneyFormat},
+		{"12.00", Money{}, ErrInvalidMoneyFormat},
+		{"12.00  USD", Money{}, ErrInvalidMoneyFormat},
+		{"12.00 usd", Money{}, ErrInvalidMoneyFormat},
+		{"12.00 US", Money{}, ErrInvalidMoneyFormat},
+		{"USD 12.00", Money{}, ErrInvalidMoneyFormat},
+		{"12. USD", Money{}, ErrInvalidMoneyFormat},
+		{".5 USD", Money{}, ErrInvalidMoneyFormat},
+		{"- USD", Money{}, ErrInvalidMoneyFormat},
+		{"+12 USD", Money{}, ErrInvalidMoneyFormat},
+		{"1,000 USD", Money{}, ErrInvalidMoneyFormat},
+		{"1e3 USD", Money{}, ErrInvalidMoneyFormat},
+		{"12.-5 USD", Money{}, ErrInvalidMoneyFormat},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseMoney(tt.input)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("ParseMoney(%q) error = %v; want %v", tt.input, err, tt.err)
+			}
+			if got != tt.want {
+				t.Errorf("ParseMoney(%q) = %+v; want %+v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	tests := []struct {
+		money Money
+		want  string
+	}{
+		{Money{1250000, "CHF"}, "12500.00 CHF"},
+		{Money{5, "USD"}, "0.05 USD"},
+		{Money{0, "EUR"}, "0.00 EUR"},
+		{Money{-1234, "USD"}, "-12.34 USD"},
+		{Money{-5, "USD"}, "-0.05 USD"},
+		{Money{1500000, "JPY"}, "1500000 JPY"},
+		{Money{-7, "JPY"}, "-7 JPY"},
+		{Money{1234, "KWD"}, "1.234 KWD"},
+		{Money{5, "BHD"}, "0.005 BHD"},
+		{Money{314, "XYZ"}, "3.14 XYZ"},
+		{Money{math.MaxInt64, "USD"}, "92233720368547758.07 USD"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := tt.money.String(); got != tt.want {
+				t.Errorf("String() = %q; want %q", got, tt.want)
+			}
+			parsed, err := ParseMoney(tt.want)
+			if err != nil || parsed != tt.money {
+				t.Errorf("ParseMoney(%q) = %+v, %v; want %+v", tt.want, parsed, err, tt.money)
+			}
+		})
+	}
+}
@@ -0,0 +1,720 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+var (
+	ErrInvalidTransition = errors.New("invalid order status transition")
+	ErrMixedCurrencies   = errors.New("watches are priced in different currencies")
+	ErrEmptyCart         = errors.New("cart is empty")
+	ErrPaymentRequired   = errors.New("order status can only be changed by a payment event")
+	ErrNoStockToShip     = errors.New("no stock left to ship")
+	ErrTooManyPending    = errors.New("too many pending orders")
+)
+
+// The states that an order can be in.
+const (
+	OrderPending   = "pending"
+	OrderPaid      = "paid"
+	OrderShipped   = "shipped"
+	OrderDelivered = "delivered"
+	OrderCancelled = "cancelled"
+	OrderRefunded  = "refunded"
+)
+
+var OrderStatuses = []string{OrderPending, OrderPaid, OrderShipped, OrderDelivered, OrderCancelled, OrderRefunded}
+
+// orderTransitions lists the states that an order may move to from each state.
+// Cancelled and refunded orders are final.
+var orderTransitions = map[string][]string{
+	OrderPending:   {OrderPaid, OrderCancelled},
+	OrderPaid:      {OrderShipped, OrderRefunded},
+	OrderShipped:   {OrderDelivered},
+	OrderDelivered: {OrderRefunded},
+}
+
+// CanTransition reports whether an order may move from one state to another.
+func CanTransition(from, to string) bool {
+	return validator.In(to, orderTransitions[from]...)
+}
+
+// openOrderSQL is the condition for an order whose watches haven't been shipped yet,
+// so are still reserved for it. Shipping takes the units out of stock_levels in the
+// same transaction, so they stop counting as reserved and as stock together. A pending
+// order stops reserving its watches once it expires, even before the sweeper has
+// cancelled it.
+const openOrderSQL = `(orders.status = 'paid' OR (orders.status = 'pending' AND orders.expires_at > NOW()))`
+
+// OrderLine is one watch in an order. The title and price are copied from the watch
+// when the order is placed, so later changes to the watch don't affect the order.
+type OrderLine struct {
+	ID      int64  `json:"id"`
+	WatchID int64  `json:"watch_id"`
+	Title   string `json:"title"`
+	Price   Money  `json:"price"`
+}
+
+type Order struct {
+	ID        int64        `json:"id"`
+	CreatedAt time.Time    `json:"created_at"`
+	UserID    int64        `json:"user_id"`
+	Status    string       `json:"status"`
+	Total     Money        `json:"total"`
+	Lines     []*OrderLine `json:"lines"`
+	ExpiresAt *time.Time   `json:"expires_at,omitempty"`
+	Version   int32        `json:"version"`
+}
+
+// OrderEvent is an entry in the audit trail of an order, recording each change of
+// state and who made it.
+type OrderEvent struct {
+	ID         int64     `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	OrderID    int64     `json:"order_id"`
+	FromStatus string    `json:"from_status,omitempty"`
+	ToStatus   string    `json:"to_status"`
+	UserID     int64     `json:"user_id"`
+	Note       string    `json:"note,omitempty"`
+}
+
+func ValidateOrderWatchIDs(v *validator.Validator, watchIDs []int64) {
+	v.Check(len(watchIDs) > 0, "watch_ids", "must contain at least 1 watch")
+	v.Check(len(watchIDs) <= 20, "watch_ids", "must not contain more than 20 watches")
+
+	seen := make(map[int64]bool, len(watchIDs))
+	for _, id := range watchIDs {
+		v.Check(id > 0, "watch_ids", "must contain only positive ids")
+		v.Check(!seen[id], "watch_ids", "must not contain duplicate values")
+		seen[id] = true
+	}
+}
+
+func ValidateOrderEvent(v *validator.Validator, event *OrderEvent) {
+	v.Check(validator.In(event.ToStatus, OrderStatuses...), "status", "must be a valid order status")
+	v.Check(len(event.Note) <= 1000, "note", "must not be more than 1000 bytes long")
+}
+
+type OrderModel struct {
+	DB *sql.DB
+}
+
+// Insert places a pending order for the watches in watchIDs, capturing their current
+// prices. The order reserves its watches until order.ExpiresAt. It returns
+// ErrTooManyPending if the user already has maxPending unexpired pending orders.
+func (m OrderModel) Insert(order *Order, watchIDs []int64, maxPending int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = m.insert(ctx, tx, order, watchIDs, maxPending)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// InsertFromCart places a pending order for the watches in the user's cart, and
+// empties the cart, in the same way as Insert().
+func (m OrderModel) InsertFromCart(order *Order, maxPending int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+SELECT watch_id
+FROM cart_items
+WHERE user_id = $1
+ORDER BY created_at, watch_id
+FOR UPDATE`, order.UserID)
+	if err != nil {
+		return err
+	}
+	var watchIDs []int64
+	for rows.Next() {
+		var id int64
+		err := rows.Scan(&id)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		watchIDs = append(watchIDs, id)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	if len(watchIDs) == 0 {
+		return ErrEmptyCart
+	}
+
+	err = m.insert(ctx, tx, order, watchIDs, maxPending)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM cart_items WHERE user_id = $1`, order.UserID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// insert creates the order, its lines and the first audit entry. The user's row is
+// locked while their pending orders are counted, so that concurrent requests can't
+// get past the limit together. The watches are locked in id order while their
+// availability is checked, so that concurrent orders and holds can't take the same
+// unit, and can't deadlock on each other. A watch that is held for the user is
+// available to them even if no other unit is, and the order takes over the unit by
+// releasing the hold. Errors about a particular watch wrap ErrRecordNotFound or
+// ErrNoUnitsAvailable and name the watch.
+func (m OrderModel) insert(ctx context.Context, tx *sql.Tx, order *Order, watchIDs []int64, maxPending int) error {
+	_, err := tx.ExecContext(ctx, `SELECT 1 FROM users WHERE id = $1 FOR NO KEY UPDATE`, order.UserID)
+	if err != nil {
+		return err
+	}
+	var pending int
+	err = tx.QueryRowContext(ctx, `
+SELECT count(*)
+FROM orders
+WHERE user_id = $1 AND status = 'pending' AND expires_at > NOW()`, order.UserID).Scan(&pending)
+	if err != nil {
+		return err
+	}
+	if pending >= maxPending {
+		return ErrTooManyPending
+	}
+
+	query := fmt.Sprintf(`
+SELECT id, title, price_amount, price_currency, %s,
+	(SELECT min(holds.id) FROM holds WHERE holds.watch_id = watches.id AND holds.client_id = $2 AND %s)
+FROM watches
+WHERE id = ANY($1)
+ORDER BY id
+FOR UPDATE`, watchAvailableSQL, activeHoldSQL)
+
+	rows, err := tx.QueryContext(ctx, query, pq.Array(watchIDs), order.UserID)
+	if err != nil {
+		return err
+	}
+	lines := make(map[int64]*OrderLine, len(watchIDs))
+	available := make(map[int64]bool, len(watchIDs))
+	var holdIDs []int64
+	for rows.Next() {
+		var line OrderLine
+		var ok bool
+		var holdID sql.NullInt64
+		err := rows.Scan(&line.WatchID, &line.Title, &line.Price.Amount, &line.Price.Currency, &ok, &holdID)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		lines[line.WatchID] = &line
+		available[line.WatchID] = ok || holdID.Valid
+		if holdID.Valid {
+			holdIDs = append(holdIDs, holdID.Int64)
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	order.Lines = make([]*OrderLine, 0, len(watchIDs))
+	order.Total = Money{}
+	for _, id := range watchIDs {
+		line, ok := lines[id]
+		switch {
+		case !ok:
+			return fmt.Errorf("watch %d: %w", id, ErrRecordNotFound)
+		case !available[id]:
+			return fmt.Errorf("watch %d: %w", id, ErrNoUnitsAvailable)
+		case order.Total.Currency != "" && line.Price.Currency != order.Total.Currency:
+			return ErrMixedCurrencies
+		}
+		order.Total.Currency = line.Price.Currency
+		order.Total.Amount += line.Price.Amount
+		order.Lines = append(order.Lines, line)
+	}
+
+	// The released holds and the new order reserve the same units, so the state of
+	// the watches doesn't change and there are no alerts to queue.
+	_, err = tx.ExecContext(ctx, `
+UPDATE holds
+SET status = 'released', released_at = NOW(), version = version + 1
+WHERE id = ANY($1)`, pq.Array(holdIDs))
+	if err != nil {
+		return err
+	}
+
+	return insertOrderRows(ctx, tx, order, "")
+}
+
+// insertAuctionOrder places a pending order for the winner of an auction, for the
+// watch at the winning bid. The unit that the auction reserved passes to the order in
+// the same transaction, so the watch's availability isn't checked again.
+func insertAuctionOrder(ctx context.Context, tx *sql.Tx, order *Order, auctionID, watchID int64, price Money) error {
+	line := &OrderLine{WatchID: watchID, Price: price}
+	err := tx.QueryRowContext(ctx, `SELECT title FROM watches WHERE id = $1`, watchID).Scan(&line.Title)
+	if err != nil {
+		return err
+	}
+	order.Lines = []*OrderLine{line}
+	order.Total = price
+	return insertOrderRows(ctx, tx, order, fmt.Sprintf("won at auction #%d", auctionID))
+}
+
+// insertOrderRows saves a new order with its lines and total, and the first entry of
+// its audit trail.
+func insertOrderRows(ctx context.Context, tx *sql.Tx, order *Order, note string) error {
+	query := `
+INSERT INTO orders (user_id, total_amount, total_currency, expires_at)
+VALUES ($1, $2, $3, $4)
+RETURNING id, created_at, status, version`
+
+	args := []interface{}{order.UserID, order.Total.Amount, order.Total.Currency, order.ExpiresAt}
+	err := tx.QueryRowContext(ctx, query, args...).Scan(&order.ID, &order.CreatedAt, &order.Status, &order.Version)
+	if err != nil {
+		return err
+	}
+
+	query = `
+INSERT INTO order_lines (order_id, watch_id, title, price_amount, price_currency)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING id`
+
+	for _, line := range order.Lines {
+		err = tx.QueryRowContext(ctx, query, order.ID, line.WatchID, line.Title, line.Price.Amount, line.Price.Currency).Scan(&line.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return insertOrderEvent(ctx, tx, &OrderEvent{OrderID: order.ID, ToStatus: order.Status, UserID: order.UserID, Note: note})
+}
+
+func insertOrderEvent(ctx context.Context, tx *sql.Tx, event *OrderEvent) error {
+	query := `
+INSERT INTO order_events (order_id, from_status, to_status, user_id, note)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING id, created_at`
+
+	args := []interface{}{event.OrderID, event.FromStatus, event.ToStatus, event.UserID, event.Note}
+	return tx.QueryRowContext(ctx, query, args...).Scan(&event.ID, &event.CreatedAt)
+}
+
+const orderColumnsSQL = `id, created_at, user_id, status, total_amount, total_currency, expires_at, version`
+
+func (o *Order) scanFields() []interface{} {
+	return []interface{}{&o.ID, &o.CreatedAt, &o.UserID, &o.Status, &o.Total.Amount, &o.Total.Currency, &o.ExpiresAt, &o.Version}
+}
+
+func (m OrderModel) Get(id int64) (*Order, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := fmt.Sprintf(`
+SELECT %s
+FROM orders
+WHERE id = $1`, orderColumnsSQL)
+
+	var order Order
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(order.scanFields()...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	err = m.attachLines(ctx, &order)
+	if err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
+
+// GetAll lists orders, optionally for a single user (userID 0 means any) and in a
+// single state.
+func (m OrderModel) GetAll(userID int64, status string, filters Filters) ([]*Order, Metadata, error) {
+	query := fmt.Sprintf(`
+SELECT count(*) OVER(), %s
+FROM orders
+WHERE (user_id = $1 OR $1 = 0)
+AND (status = $2 OR $2 = '')
+ORDER BY %s %s, id ASC
+LIMIT $3 OFFSET $4`, orderColumnsSQL, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, status, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	orders := []*Order{}
+	for rows.Next() {
+		var order Order
+		err := rows.Scan(append([]interface{}{&totalRecords}, order.scanFields()...)...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		orders = append(orders, &order)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	err = m.attachLines(ctx, orders...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return orders, metadata, nil
+}
+
+// attachLines loads the lines of the given orders with a single query.
+func (m OrderModel) attachLines(ctx context.Context, orders ...*Order) error {
+	if len(orders) == 0 {
+		return nil
+	}
+	byID := make(map[int64]*Order, len(orders))
+	ids := make([]int64, 0, len(orders))
+	for _, order := range orders {
+		order.Lines = []*OrderLine{}
+		byID[order.ID] = order
+		ids = append(ids, order.ID)
+	}
+
+	query := `
+SELECT order_id, id, watch_id, title, price_amount, price_currency
+FROM order_lines
+WHERE order_id = ANY($1)
+ORDER BY order_id, id`
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var orderID int64
+		var line OrderLine
+		err := rows.Scan(&orderID, &line.ID, &line.WatchID, &line.Title, &line.Price.Amount, &line.Price.Currency)
+		if err != nil {
+			return err
+		}
+		byID[orderID].Lines = append(byID[orderID].Lines, &line)
+	}
+	return rows.Err()
+}
+
+// Transition moves an order to a new state and records the change in its audit trail.
+// It returns ErrInvalidTransition if the state machine doesn't allow the change, and
+// ErrEditConflict if the order was changed by someone else since it was read. Orders
+// are only marked as paid or refunded by PaymentModel.ApplyEvent(), once the payment
+// provider has confirmed the payment, so those states return ErrPaymentRequired.
+func (m OrderModel) Transition(order *Order, event *OrderEvent) error {
+	if event.ToStatus == OrderPaid || event.ToStatus == OrderRefunded {
+		return ErrPaymentRequired
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = transitionOrder(ctx, tx, order, event)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func transitionOrder(ctx context.Context, tx *sql.Tx, order *Order, event *OrderEvent) error {
+	if !CanTransition(order.Status, event.ToStatus) {
+		return ErrInvalidTransition
+	}
+
+	// Leaving the open states releases the units the order reserved, which can put
+	// its watches back in stock.
+	before, err := readOrderWatchStates(ctx, tx, order.ID)
+	if err != nil {
+		return err
+	}
+
+	// Only pending orders expire.
+	query := `
+UPDATE orders
+SET status = $1, expires_at = NULL, version = version + 1
+WHERE id = $2 AND version = $3
+RETURNING version`
+
+	err = tx.QueryRowContext(ctx, query, event.ToStatus, order.ID, order.Version).Scan(&order.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	if event.ToStatus == OrderShipped {
+		err = shipOrderStock(ctx, tx, order.ID, event.UserID)
+		if err != nil {
+			return err
+		}
+	}
+
+	event.OrderID = order.ID
+	event.FromStatus = order.Status
+	err = insertOrderEvent(ctx, tx, event)
+	if err != nil {
+		return err
+	}
+	order.Status = event.ToStatus
+	order.ExpiresAt = nil
+
+	for watchID, state := range before {
+		err = queueAlerts(ctx, tx, watchID, state)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExpirePending cancels the pending orders whose time to pay has run out, recording the
+// change in their audit trails against the customer, and queues alerts for the watches
+// that they released. It returns the number of orders cancelled.
+func (m OrderModel) ExpirePending() (int64, error) {
+	query := `
+UPDATE orders
+SET status = 'cancelled', expires_at = NULL, version = version + 1
+WHERE status = 'pending' AND expires_at <= NOW()
+RETURNING id, user_id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	var events []*OrderEvent
+	var orderIDs []int64
+	for rows.Next() {
+		event := &OrderEvent{FromStatus: OrderPending, ToStatus: OrderCancelled, Note: "expired without payment"}
+		if err := rows.Scan(&event.OrderID, &event.UserID); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		events = append(events, event)
+		orderIDs = append(orderIDs, event.OrderID)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(events) == 0 {
+		return 0, nil
+	}
+
+	for _, event := range events {
+		err = insertOrderEvent(ctx, tx, event)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	rows, err = tx.QueryContext(ctx, `
+SELECT watch_id, count(*)
+FROM order_lines
+WHERE order_id = ANY($1)
+GROUP BY watch_id`, pq.Array(orderIDs))
+	if err != nil {
+		return 0, err
+	}
+	released := map[int64]int{}
+	for rows.Next() {
+		var watchID int64
+		var n int
+		if err := rows.Scan(&watchID, &n); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		released[watchID] = n
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for watchID, n := range released {
+		before, err := readWatchState(ctx, tx, watchID)
+		if err != nil {
+			return 0, err
+		}
+		before.Reserved += n
+		err = queueAlerts(ctx, tx, watchID, before)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return int64(len(events)), tx.Commit()
+}
+
+// shipOrderStock takes one unit of each line of an order out of stock, recording a sale
+// in the stock ledger for each. Units are taken from the location holding the most of
+// the watch. It returns ErrNoStockToShip if a watch has no stock left anywhere.
+func shipOrderStock(ctx context.Context, tx *sql.Tx, orderID, userID int64) error {
+	rows, err := tx.QueryContext(ctx, `SELECT watch_id FROM order_lines WHERE order_id = $1 ORDER BY id`, orderID)
+	if err != nil {
+		return err
+	}
+	var watchIDs []int64
+	for rows.Next() {
+		var watchID int64
+		if err := rows.Scan(&watchID); err != nil {
+			rows.Close()
+			return err
+		}
+		watchIDs = append(watchIDs, watchID)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	query := `
+UPDATE stock_levels
+SET quantity = quantity - 1, version = version + 1
+WHERE (watch_id, location_id) = (
+	SELECT watch_id, location_id
+	FROM stock_levels
+	WHERE watch_id = $1 AND quantity > 0
+	ORDER BY quantity DESC, location_id
+	LIMIT 1
+	FOR UPDATE)
+RETURNING location_id`
+
+	reason := fmt.Sprintf("order #%d shipped", orderID)
+	for _, watchID := range watchIDs {
+		var locationID int64
+		err := tx.QueryRowContext(ctx, query, watchID).Scan(&locationID)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrNoStockToShip
+			default:
+				return err
+			}
+		}
+		_, err = tx.ExecContext(ctx, `
+INSERT INTO stock_movements (watch_id, type, from_location_id, quantity, reason, user_id)
+VALUES ($1, $2, $3, 1, $4, $5)`, watchID, MovementSell, locationID, reason, userID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readOrderWatchStates reads the state of every watch in an order, keyed by watch id.
+func readOrderWatchStates(ctx context.Context, tx *sql.Tx, orderID int64) (map[int64]watchState, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT DISTINCT watch_id FROM order_lines WHERE order_id = $1`, orderID)
+	if err != nil {
+		return nil, err
+	}
+	var watchIDs []int64
+	for rows.Next() {
+		var watchID int64
+		if err := rows.Scan(&watchID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		watchIDs = append(watchIDs, watchID)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	states := make(map[int64]watchState, len(watchIDs))
+	for _, watchID := range watchIDs {
+		state, err := readWatchState(ctx, tx, watchID)
+		if err != nil {
+			return nil, err
+		}
+		states[watchID] = state
+	}
+	return states, nil
+}
+
+// GetEvents returns the audit trail of an order, oldest first.
+func (m OrderModel) GetEvents(orderID int64) ([]*OrderEvent, error) {
+	query := `
+SELECT id, created_at, order_id, from_status, to_status, user_id, note
+FROM order_events
+WHERE order_id = $1
+ORDER BY created_at, id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []*OrderEvent{}
+	for rows.Next() {
+		var event OrderEvent
+		err := rows.Scan(&event.ID, &event.CreatedAt, &event.OrderID, &event.FromStatus, &event.ToStatus, &event.UserID, &event.Note)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, &event)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
@@ -0,0 +1,39 @@
+package data
+
+import "testing"
+
+func TestCanTransition(t *testing.T) {
+	tests := []struct {
+		from string
+		to   string
+		want bool
+	}{
+		{OrderPending, OrderPaid, true},
+		{OrderPending, OrderCancelled, true},
+		{OrderPending, OrderShipped, false},
+		{OrderPending, OrderRefunded, false},
+		{OrderPaid, OrderShipped, true},
+		{OrderPaid, OrderRefunded, true},
+		{OrderPaid, OrderCancelled, false},
+		{OrderPaid, OrderPending, false},
+		{OrderShipped, OrderDelivered, true},
+		{OrderShipped, OrderPaid, false},
+		{OrderDelivered, OrderRefunded, true},
+		{OrderDelivered, OrderShipped, false},
+		{OrderCancelled, OrderPending, false},
+		{OrderCancelled, OrderPaid, false},
+		{OrderRefunded, OrderPaid, false},
+		{OrderRefunded, OrderDelivered, false},
+		{OrderPending, OrderPending, false},
+		{"unknown", OrderPaid, false},
+		{OrderPending, "unknown", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.from+"->"+tt.to, func(t *testing.T) {
+			if got := CanTransition(tt.from, tt.to); got != tt.want {
+				t.Errorf("CanTransition(%q, %q) = %v; want %v", tt.from, tt.to, got, tt.want)
+			}
+		})
+	}
+}
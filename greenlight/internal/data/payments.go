@@ -0,0 +1,315 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+var (
+	ErrDuplicateIdempotencyKey = errors.New("duplicate idempotency key")
+	ErrPaymentInProgress       = errors.New("order already has a payment in progress")
+	ErrDuplicatePaymentEvent   = errors.New("payment event has already been processed")
+	ErrStalePaymentEvent       = errors.New("payment event doesn't move the payment forward")
+)
+
+// The states of a payment intent. A new intent is created before the provider is
+// called; the other states mirror the state of the charge at the provider.
+const (
+	PaymentCreated    = "created"
+	PaymentPending    = "pending"
+	PaymentAuthorized = "authorized"
+	PaymentCaptured   = "captured"
+	PaymentDeclined   = "declined"
+	PaymentRefunded   = "refunded"
+)
+
+// paymentTransitions lists the states that a payment intent may move to from each
+// state. Provider events can arrive out of order, so they may skip states but never
+// go back. Declined and refunded payments are final.
+var paymentTransitions = map[string][]string{
+	PaymentCreated:    {PaymentPending, PaymentAuthorized, PaymentCaptured, PaymentDeclined},
+	PaymentPending:    {PaymentAuthorized, PaymentCaptured, PaymentDeclined},
+	PaymentAuthorized: {PaymentCaptured, PaymentDeclined},
+	PaymentCaptured:   {PaymentRefunded},
+}
+
+// CanPaymentTransition reports whether a payment intent may move from one state to
+// another.
+func CanPaymentTransition(from, to string) bool {
+	return validator.In(to, paymentTransitions[from]...)
+}
+
+// PaymentIntent records an attempt to pay for an order. The idempotency key is chosen
+// by the client, so that a retried request returns the original intent instead of
+// charging twice.
+type PaymentIntent struct {
+	ID             int64     `json:"id"`
+	CreatedAt      time.Time `json:"created_at"`
+	OrderID        int64     `json:"order_id"`
+	UserID         int64     `json:"user_id"`
+	IdempotencyKey string    `json:"idempotency_key"`
+	Amount         Money     `json:"amount"`
+	Provider       string    `json:"provider"`
+	ProviderRef    string    `json:"provider_reference,omitempty"`
+	Status         string    `json:"status"`
+	FailureReason  string    `json:"failure_reason,omitempty"`
+	Version        int32     `json:"version"`
+}
+
+// PaymentEvent is a verified notification from the payment provider that a charge has
+// moved into Status.
+type PaymentEvent struct {
+	Provider      string
+	EventID       string
+	ProviderRef   string
+	Status        string
+	FailureReason string
+}
+
+func ValidateIdempotencyKey(v *validator.Validator, key string) {
+	v.Check(key != "", "idempotency_key", "must be provided")
+	v.Check(len(key) <= 255, "idempotency_key", "must not be more than 255 bytes long")
+}
+
+type PaymentModel struct {
+	DB *sql.DB
+}
+
+func (m PaymentModel) Insert(intent *PaymentIntent) error {
+	query := `
+INSERT INTO payment_intents (order_id, user_id, idempotency_key, amount, currency, provider)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING id, created_at, status, version`
+
+	args := []interface{}{intent.OrderID, intent.UserID, intent.IdempotencyKey, intent.Amount.Amount, intent.Amount.Currency, intent.Provider}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&intent.ID, &intent.CreatedAt, &intent.Status, &intent.Version)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			switch pqErr.Constraint {
+			case "payment_intents_user_id_idempotency_key_key":
+				return ErrDuplicateIdempotencyKey
+			case "payment_intents_open_order_id_idx":
+				return ErrPaymentInProgress
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+const paymentIntentColumnsSQL = `id, created_at, order_id, user_id, idempotency_key, amount, currency, provider, provider_ref, status, failure_reason, version`
+
+func (p *PaymentIntent) scanFields() []interface{} {
+	return []interface{}{&p.ID, &p.CreatedAt, &p.OrderID, &p.UserID, &p.IdempotencyKey, &p.Amount.Amount, &p.Amount.Currency, &p.Provider, &p.ProviderRef, &p.Status, &p.FailureReason, &p.Version}
+}
+
+// GetByIdempotencyKey returns the intent that a user created with the given key.
+func (m PaymentModel) GetByIdempotencyKey(userID int64, key string) (*PaymentIntent, error) {
+	query := fmt.Sprintf(`
+SELECT %s
+FROM payment_intents
+WHERE user_id = $1 AND idempotency_key = $2`, paymentIntentColumnsSQL)
+
+	var intent PaymentIntent
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID, key).Scan(intent.scanFields()...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &intent, nil
+}
+
+// GetAllForOrder returns the payment attempts for an order, oldest first.
+func (m PaymentModel) GetAllForOrder(orderID int64) ([]*PaymentIntent, error) {
+	query := fmt.Sprintf(`
+SELECT %s
+FROM payment_intents
+WHERE order_id = $1
+ORDER BY created_at, id`, paymentIntentColumnsSQL)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	intents := []*PaymentIntent{}
+	for rows.Next() {
+		var intent PaymentIntent
+		err := rows.Scan(intent.scanFields()...)
+		if err != nil {
+			return nil, err
+		}
+		intents = append(intents, &intent)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return intents, nil
+}
+
+// Update saves the provider reference, status and failure reason that the provider
+// returned synchronously, using the version number to detect concurrent changes.
+func (m PaymentModel) Update(intent *PaymentIntent) error {
+	query := `
+UPDATE payment_intents
+SET provider_ref = $1, status = $2, failure_reason = $3, version = version + 1
+WHERE id = $4 AND version = $5
+RETURNING version`
+
+	args := []interface{}{intent.ProviderRef, intent.Status, intent.FailureReason, intent.ID, intent.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&intent.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// ApplyEvent records a verified provider event against its payment intent. A captured
+// payment marks a pending order as paid, and a refunded payment marks the order as
+// refunded; these are the only ways that an order reaches those states. An order
+// that was cancelled while its payment was in flight is left cancelled, and a pending
+// order that expired before the capture arrived is cancelled rather than paid, since
+// its watches may have been sold to someone else. Each event is only applied once; a
+// repeated delivery returns ErrDuplicatePaymentEvent. An event that would move the
+// intent backwards, such as an authorization that arrives after the capture, is
+// recorded but not applied, and returns ErrStalePaymentEvent.
+func (m PaymentModel) ApplyEvent(event *PaymentEvent) (*PaymentIntent, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+INSERT INTO payment_events (provider, event_id, provider_ref, status)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT DO NOTHING`
+
+	result, err := tx.ExecContext(ctx, query, event.Provider, event.EventID, event.ProviderRef, event.Status)
+	if err != nil {
+		return nil, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if rowsAffected == 0 {
+		return nil, ErrDuplicatePaymentEvent
+	}
+
+	query = fmt.Sprintf(`
+SELECT %s
+FROM payment_intents
+WHERE provider = $1 AND provider_ref = $2
+FOR UPDATE`, paymentIntentColumnsSQL)
+
+	var intent PaymentIntent
+	err = tx.QueryRowContext(ctx, query, event.Provider, event.ProviderRef).Scan(intent.scanFields()...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	if !CanPaymentTransition(intent.Status, event.Status) {
+		err = tx.Commit()
+		if err != nil {
+			return nil, err
+		}
+		return nil, ErrStalePaymentEvent
+	}
+
+	query = `
+UPDATE payment_intents
+SET status = $1, failure_reason = $2, version = version + 1
+WHERE id = $3
+RETURNING version`
+
+	err = tx.QueryRowContext(ctx, query, event.Status, event.FailureReason, intent.ID).Scan(&intent.Version)
+	if err != nil {
+		return nil, err
+	}
+	intent.Status = event.Status
+	intent.FailureReason = event.FailureReason
+
+	var toStatus string
+	switch event.Status {
+	case PaymentCaptured:
+		toStatus = OrderPaid
+	case PaymentRefunded:
+		toStatus = OrderRefunded
+	}
+	if toStatus != "" {
+		query = fmt.Sprintf(`
+SELECT %s, COALESCE(expires_at <= NOW(), false)
+FROM orders
+WHERE id = $1
+FOR UPDATE`, orderColumnsSQL)
+
+		var order Order
+		var expired bool
+		err = tx.QueryRowContext(ctx, query, intent.OrderID).Scan(append(order.scanFields(), &expired)...)
+		if err != nil {
+			return nil, err
+		}
+		note := fmt.Sprintf("payment %s %s", intent.ProviderRef, event.Status)
+		if toStatus == OrderPaid && order.Status == OrderPending && expired {
+			toStatus = OrderCancelled
+			note += " after the order expired"
+		}
+		if CanTransition(order.Status, toStatus) {
+			orderEvent := &OrderEvent{
+				ToStatus: toStatus,
+				UserID:   intent.UserID,
+				Note:     note,
+			}
+			err = transitionOrder(ctx, tx, &order, orderEvent)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return &intent, nil
+}
@@ -0,0 +1,36 @@
+package data
+
+import "testing"
+
+func TestCanPaymentTransition(t *testing.T) {
+	tests := []struct {
+		from string
+		to   string
+		want bool
+	}{
+		{PaymentCreated, PaymentPending, true},
+		{PaymentCreated, PaymentCaptured, true},
+		{PaymentPending, PaymentAuthorized, true},
+		{PaymentPending, PaymentDeclined, true},
+		{PaymentAuthorized, PaymentCaptured, true},
+		{PaymentAuthorized, PaymentDeclined, true},
+		{PaymentAuthorized, PaymentPending, false},
+		{PaymentAuthorized, PaymentAuthorized, false},
+		{PaymentCaptured, PaymentRefunded, true},
+		{PaymentCaptured, PaymentAuthorized, false},
+		{PaymentCaptured, PaymentDeclined, false},
+		{PaymentDeclined, PaymentAuthorized, false},
+		{PaymentDeclined, PaymentCaptured, false},
+		{PaymentRefunded, PaymentCaptured, false},
+		{PaymentPending, PaymentRefunded, false},
+		{"unknown", PaymentCaptured, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.from+"->"+tt.to, func(t *testing.T) {
+			if got := CanPaymentTransition(tt.from, tt.to); got != tt.want {
+				t.Errorf("CanPaymentTransition(%q, %q) = %v; want %v", tt.from, tt.to, got, tt.want)
+			}
+		})
+	}
+}
@@ -8,7 +8,7 @@ import (
 )
 
 // Define a Permissions slice, which we will use to hold the permission codes (like
-// "movies:read" and "movies:write") for a single user.
+// "watches:read" and "watches:write") for a single user.
 type Permissions []string
 
 // Add a helper method to check whether the Permissions slice contains a specific
@@ -0,0 +1,71 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// PriceChange records one change to the price of a watch. UserID is the user who
+// made the change, and is nil if their account has since been deleted.
+type PriceChange struct {
+	ID        int64     `json:"id"`
+	ChangedAt time.Time `json:"changed_at"`
+	WatchID   int64     `json:"watch_id"`
+	OldPrice  Money     `json:"old_price"`
+	NewPrice  Money     `json:"new_price"`
+	UserID    *int64    `json:"user_id,omitempty"`
+}
+
+// insertPriceChange records a price change as part of the transaction that makes it.
+func insertPriceChange(ctx context.Context, tx *sql.Tx, change *PriceChange) error {
+	query := `
+INSERT INTO price_history (watch_id, old_amount, old_currency, new_amount, new_currency, user_id)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING id, changed_at`
+
+	args := []interface{}{change.WatchID, change.OldPrice.Amount, change.OldPrice.Currency, change.NewPrice.Amount, change.NewPrice.Currency, change.UserID}
+	return tx.QueryRowContext(ctx, query, args...).Scan(&change.ID, &change.ChangedAt)
+}
+
+type PriceHistoryModel struct {
+	DB *sql.DB
+}
+
+// GetAll lists the price changes of a watch.
+func (m PriceHistoryModel) GetAll(watchID int64, filters Filters) ([]*PriceChange, Metadata, error) {
+	query := fmt.Sprintf(`
+SELECT count(*) OVER(), id, changed_at, watch_id, old_amount, old_currency, new_amount, new_currency, user_id
+FROM price_history
+WHERE watch_id = $1
+ORDER BY %s %s, id ASC
+LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, watchID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	changes := []*PriceChange{}
+	for rows.Next() {
+		var c PriceChange
+		err := rows.Scan(&totalRecords, &c.ID, &c.ChangedAt, &c.WatchID, &c.OldPrice.Amount, &c.OldPrice.Currency,
+			&c.NewPrice.Amount, &c.NewPrice.Currency, &c.UserID)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		changes = append(changes, &c)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return changes, metadata, nil
+}
@@ -0,0 +1,265 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+var OwnerTypes = []string{"manufacturer", "dealer", "auction_house", "private", "museum", "other"}
+
+// GenesisHash is the previous hash of the first entry in a chain.
+const GenesisHash = "0000000000000000000000000000000000000000000000000000000000000000"
+
+// ProvenanceEntry is one step in the ownership history of a watch. OwnerName,
+// Documents and Notes are private, and are only shown to users who may read them.
+// Hash covers the other fields and PreviousHash, chaining the entries together;
+// CreatedBy is left out, as it is cleared when the user is deleted.
+type ProvenanceEntry struct {
+	ID           int64      `json:"id"`
+	CreatedAt    time.Time  `json:"created_at"`
+	WatchID      int64      `json:"watch_id"`
+	Sequence     int        `json:"sequence"`
+	OwnerType    string     `json:"owner_type"`
+	OwnerName    string     `json:"owner_name,omitempty"`
+	AcquiredOn   *time.Time `json:"acquired_on,omitempty"`
+	Source       string     `json:"source,omitempty"`
+	Documents    []string   `json:"documents,omitempty"`
+	Notes        string     `json:"notes,omitempty"`
+	CreatedBy    *int64     `json:"created_by,omitempty"`
+	PreviousHash string     `json:"previous_hash"`
+	Hash         string     `json:"hash"`
+}
+
+func ValidateProvenanceEntry(v *validator.Validator, entry *ProvenanceEntry) {
+	v.Check(validator.In(entry.OwnerType, OwnerTypes...), "owner_type", "must be manufacturer, dealer, auction_house, private, museum or other")
+	v.Check(len(entry.OwnerName) <= 200, "owner_name", "must not be more than 200 bytes long")
+	if entry.AcquiredOn != nil {
+		v.Check(!entry.AcquiredOn.After(time.Now()), "acquired_on", "must not be in the future")
+	}
+	v.Check(len(entry.Source) <= 500, "source", "must not be more than 500 bytes long")
+	v.Check(len(entry.Documents) <= 20, "documents", "must not contain more than 20 entries")
+	for _, document := range entry.Documents {
+		v.Check(document != "" && len(document) <= 500, "documents", "must only contain references of 1 to 500 bytes")
+	}
+	v.Check(len(entry.Notes) <= 5000, "notes", "must not be more than 5000 bytes long")
+}
+
+// Redact removes the private fields, for users who may not read them.
+func (e *ProvenanceEntry) Redact() {
+	e.OwnerName = ""
+	e.Documents = nil
+	e.Notes = ""
+}
+
+// computeHash hashes the entry's content together with PreviousHash. The content is
+// encoded as JSON with a fixed field order, and times in UTC, so the hash only
+// depends on the values.
+func (e *ProvenanceEntry) computeHash() string {
+	var acquiredOn string
+	if e.AcquiredOn != nil {
+		acquiredOn = e.AcquiredOn.Format("2006-01-02")
+	}
+	documents := e.Documents
+	if documents == nil {
+		documents = []string{}
+	}
+	content, _ := json.Marshal([]interface{}{
+		e.PreviousHash,
+		e.WatchID,
+		e.Sequence,
+		e.CreatedAt.UTC().Format(time.RFC3339),
+		e.OwnerType,
+		e.OwnerName,
+		acquiredOn,
+		e.Source,
+		documents,
+		e.Notes,
+	})
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// ProvenanceHead is the sequence and hash of the last entry appended to a watch's
+// chain. It is stored on the watch, so that entries removed from the end of the chain
+// can be noticed.
+type ProvenanceHead struct {
+	Sequence int
+	Hash     string
+}
+
+// ChainBreak reports an entry that doesn't fit in its chain. EntryID is left out when
+// the whole chain is missing.
+type ChainBreak struct {
+	EntryID  int64  `json:"entry_id,omitempty"`
+	Sequence int    `json:"sequence"`
+	Problem  string `json:"problem"`
+}
+
+// VerifyProvenanceChain checks entries, in sequence order, against each other and the
+// head recorded on the watch, and returns the places where the chain is broken: an
+// entry whose content doesn't match its hash, one that doesn't point at the hash of
+// the entry before it, a gap in the sequence left by a removed entry, or a chain that
+// doesn't end at the head.
+func VerifyProvenanceChain(entries []*ProvenanceEntry, head ProvenanceHead) []*ChainBreak {
+	breaks := []*ChainBreak{}
+	previousHash := GenesisHash
+	last := &ProvenanceEntry{PreviousHash: GenesisHash, Hash: GenesisHash}
+	for i, e := range entries {
+		if e.Sequence != i+1 {
+			breaks = append(breaks, &ChainBreak{EntryID: e.ID, Sequence: e.Sequence, Problem: "entries are missing before this one"})
+		}
+		if e.PreviousHash != previousHash {
+			breaks = append(breaks, &ChainBreak{EntryID: e.ID, Sequence: e.Sequence, Problem: "previous hash does not match the entry before"})
+		}
+		if e.computeHash() != e.Hash {
+			breaks = append(breaks, &ChainBreak{EntryID: e.ID, Sequence: e.Sequence, Problem: "content does not match its hash"})
+		}
+		previousHash = e.Hash
+		last = e
+	}
+	switch {
+	case last.Sequence < head.Sequence:
+		breaks = append(breaks, &ChainBreak{EntryID: last.ID, Sequence: last.Sequence, Problem: "entries are missing after this one"})
+	case last.Sequence > head.Sequence || last.Hash != head.Hash:
+		breaks = append(breaks, &ChainBreak{EntryID: last.ID, Sequence: last.Sequence, Problem: "the chain does not end at the head recorded on the watch"})
+	}
+	return breaks
+}
+
+type ProvenanceModel struct {
+	DB *sql.DB
+}
+
+// Insert appends an entry to the end of a watch's chain, and moves the head recorded on
+// the watch to it. The watch row is locked while the head is read, so that concurrent
+// appends can't fork the chain.
+func (m ProvenanceModel) Insert(entry *ProvenanceEntry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `SELECT provenance_sequence, provenance_head FROM watches WHERE id = $1 FOR UPDATE`
+	err = tx.QueryRowContext(ctx, query, entry.WatchID).Scan(&entry.Sequence, &entry.PreviousHash)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	entry.Sequence++
+
+	// The column only stores whole seconds, so the hash is taken over the same value.
+	entry.CreatedAt = time.Now().UTC().Truncate(time.Second)
+	if entry.Documents == nil {
+		entry.Documents = []string{}
+	}
+	entry.Hash = entry.computeHash()
+
+	query = `
+INSERT INTO provenance_entries (created_at, watch_id, sequence, owner_type, owner_name, acquired_on, source, documents, notes,
+	created_by, previous_hash, hash)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+RETURNING id`
+	args := []interface{}{entry.CreatedAt, entry.WatchID, entry.Sequence, entry.OwnerType, entry.OwnerName, entry.AcquiredOn,
+		entry.Source, pq.Array(entry.Documents), entry.Notes, entry.CreatedBy, entry.PreviousHash, entry.Hash}
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&entry.ID)
+	if err != nil {
+		return err
+	}
+
+	query = `UPDATE watches SET provenance_sequence = $1, provenance_head = $2 WHERE id = $3`
+	_, err = tx.ExecContext(ctx, query, entry.Sequence, entry.Hash, entry.WatchID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetAll returns a watch's whole chain in sequence order.
+func (m ProvenanceModel) GetAll(watchID int64) ([]*ProvenanceEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, provenanceChainSQL, watchID)
+	if err != nil {
+		return nil, err
+	}
+	return scanProvenanceEntries(rows)
+}
+
+// GetChain returns a watch's whole chain in sequence order along with the head
+// recorded on the watch, for verifying. The watch row is share-locked so that no entry
+// can be appended between reading the head and the entries.
+func (m ProvenanceModel) GetChain(watchID int64) ([]*ProvenanceEntry, ProvenanceHead, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, ProvenanceHead{}, err
+	}
+	defer tx.Rollback()
+
+	var head ProvenanceHead
+	query := `SELECT provenance_sequence, provenance_head FROM watches WHERE id = $1 FOR SHARE`
+	err = tx.QueryRowContext(ctx, query, watchID).Scan(&head.Sequence, &head.Hash)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ProvenanceHead{}, ErrRecordNotFound
+		default:
+			return nil, ProvenanceHead{}, err
+		}
+	}
+
+	rows, err := tx.QueryContext(ctx, provenanceChainSQL, watchID)
+	if err != nil {
+		return nil, ProvenanceHead{}, err
+	}
+	entries, err := scanProvenanceEntries(rows)
+	if err != nil {
+		return nil, ProvenanceHead{}, err
+	}
+	return entries, head, tx.Commit()
+}
+
+const provenanceChainSQL = `
+SELECT id, created_at, watch_id, sequence, owner_type, owner_name, acquired_on, source, documents, notes,
+	created_by, previous_hash, hash
+FROM provenance_entries
+WHERE watch_id = $1
+ORDER BY sequence`
+
+func scanProvenanceEntries(rows *sql.Rows) ([]*ProvenanceEntry, error) {
+	defer rows.Close()
+
+	entries := []*ProvenanceEntry{}
+	for rows.Next() {
+		var e ProvenanceEntry
+		err := rows.Scan(&e.ID, &e.CreatedAt, &e.WatchID, &e.Sequence, &e.OwnerType, &e.OwnerName, &e.AcquiredOn, &e.Source,
+			pq.Array(&e.Documents), &e.Notes, &e.CreatedBy, &e.PreviousHash, &e.Hash)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, &e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
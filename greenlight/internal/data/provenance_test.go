@@ -0,0 +1,175 @@
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+func testProvenanceEntry() *ProvenanceEntry {
+	acquiredOn := time.Date(2019, 6, 1, 0, 0, 0, 0, time.UTC)
+	return &ProvenanceEntry{
+		ID:           1,
+		CreatedAt:    time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC),
+		WatchID:      7,
+		Sequence:     1,
+		OwnerType:    "dealer",
+		OwnerName:    "Acme Watches",
+		AcquiredOn:   &acquiredOn,
+		Source:       "invoice",
+		Documents:    []string{"invoice-123.pdf"},
+		Notes:        "boxed with papers",
+		PreviousHash: GenesisHash,
+	}
+}
+
+func TestComputeHash(t *testing.T) {
+	base := testProvenanceEntry().computeHash()
+	if len(base) != 64 {
+		t.Fatalf("hash %q is not 64 hex characters", base)
+	}
+
+	tests := []struct {
+		name   string
+		change func(*ProvenanceEntry)
+		same   bool
+	}{
+		{"unchanged", func(e *ProvenanceEntry) {}, true},
+		{"created_by", func(e *ProvenanceEntry) { id := int64(3); e.CreatedBy = &id }, true},
+		{"created_at in another zone", func(e *ProvenanceEntry) { e.CreatedAt = e.CreatedAt.In(time.FixedZone("CET", 3600)) }, true},
+		{"id", func(e *ProvenanceEntry) { e.ID = 2 }, true},
+		{"hash", func(e *ProvenanceEntry) { e.Hash = "abc" }, true},
+		{"previous_hash", func(e *ProvenanceEntry) { e.PreviousHash = base }, false},
+		{"watch_id", func(e *ProvenanceEntry) { e.WatchID = 8 }, false},
+		{"sequence", func(e *ProvenanceEntry) { e.Sequence = 2 }, false},
+		{"created_at", func(e *ProvenanceEntry) { e.CreatedAt = e.CreatedAt.Add(time.Second) }, false},
+		{"owner_type", func(e *ProvenanceEntry) { e.OwnerType = "private" }, false},
+		{"owner_name", func(e *ProvenanceEntry) { e.OwnerName = "Someone Else" }, false},
+		{"acquired_on", func(e *ProvenanceEntry) { e.AcquiredOn = nil }, false},
+		{"source", func(e *ProvenanceEntry) { e.Source = "auction" }, false},
+		{"documents", func(e *ProvenanceEntry) { e.Documents = []string{"invoice-124.pdf"} }, false},
+		{"notes", func(e *ProvenanceEntry) { e.Notes = "" }, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := testProvenanceEntry()
+			tt.change(e)
+			if got := e.computeHash() == base; got != tt.same {
+				t.Errorf("hash unchanged = %t; want %t", got, tt.same)
+			}
+		})
+	}
+}
+
+func TestComputeHashNilDocuments(t *testing.T) {
+	withNil, withEmpty := testProvenanceEntry(), testProvenanceEntry()
+	withNil.Documents, withEmpty.Documents = nil, []string{}
+	if withNil.computeHash() != withEmpty.computeHash() {
+		t.Error("nil and empty documents hash differently")
+	}
+}
+
+// testProvenanceChain returns a valid chain of n entries and its head.
+func testProvenanceChain(n int) ([]*ProvenanceEntry, ProvenanceHead) {
+	entries := []*ProvenanceEntry{}
+	head := ProvenanceHead{Hash: GenesisHash}
+	for i := 1; i <= n; i++ {
+		e := testProvenanceEntry()
+		e.ID, e.Sequence, e.PreviousHash = int64(i), i, head.Hash
+		e.Hash = e.computeHash()
+		entries = append(entries, e)
+		head = ProvenanceHead{Sequence: i, Hash: e.Hash}
+	}
+	return entries, head
+}
+
+func TestVerifyProvenanceChain(t *testing.T) {
+	tests := []struct {
+		name   string
+		length int
+		change func([]*ProvenanceEntry, *ProvenanceHead) []*ProvenanceEntry
+		want   []ChainBreak
+	}{
+		{
+			name:   "empty",
+			length: 0,
+		},
+		{
+			name:   "intact",
+			length: 3,
+		},
+		{
+			name:   "edited content",
+			length: 3,
+			change: func(entries []*ProvenanceEntry, head *ProvenanceHead) []*ProvenanceEntry {
+				entries[1].Notes = "no papers"
+				return entries
+			},
+			want: []ChainBreak{{EntryID: 2, Sequence: 2, Problem: "content does not match its hash"}},
+		},
+		{
+			name:   "entry removed from the middle",
+			length: 3,
+			change: func(entries []*ProvenanceEntry, head *ProvenanceHead) []*ProvenanceEntry {
+				return append(entries[:1], entries[2])
+			},
+			want: []ChainBreak{
+				{EntryID: 3, Sequence: 3, Problem: "entries are missing before this one"},
+				{EntryID: 3, Sequence: 3, Problem: "previous hash does not match the entry before"},
+			},
+		},
+		{
+			name:   "entries removed from the end",
+			length: 3,
+			change: func(entries []*ProvenanceEntry, head *ProvenanceHead) []*ProvenanceEntry {
+				return entries[:1]
+			},
+			want: []ChainBreak{{EntryID: 1, Sequence: 1, Problem: "entries are missing after this one"}},
+		},
+		{
+			name:   "every entry removed",
+			length: 2,
+			change: func(entries []*ProvenanceEntry, head *ProvenanceHead) []*ProvenanceEntry {
+				return nil
+			},
+			want: []ChainBreak{{Sequence: 0, Problem: "entries are missing after this one"}},
+		},
+		{
+			name:   "last entry replaced",
+			length: 2,
+			change: func(entries []*ProvenanceEntry, head *ProvenanceHead) []*ProvenanceEntry {
+				entries[1].Notes = "replaced"
+				entries[1].Hash = entries[1].computeHash()
+				return entries
+			},
+			want: []ChainBreak{{EntryID: 2, Sequence: 2, Problem: "the chain does not end at the head recorded on the watch"}},
+		},
+		{
+			name:   "entry added without moving the head",
+			length: 2,
+			change: func(entries []*ProvenanceEntry, head *ProvenanceHead) []*ProvenanceEntry {
+				head.Sequence, head.Hash = 1, entries[0].Hash
+				return entries
+			},
+			want: []ChainBreak{{EntryID: 2, Sequence: 2, Problem: "the chain does not end at the head recorded on the watch"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, head := testProvenanceChain(tt.length)
+			if tt.change != nil {
+				entries = tt.change(entries, &head)
+			}
+			breaks := VerifyProvenanceChain(entries, head)
+			if len(breaks) != len(tt.want) {
+				t.Fatalf("got %d breaks; want %d: %+v", len(breaks), len(tt.want), breaks)
+			}
+			for i, b := range breaks {
+				if *b != tt.want[i] {
+					t.Errorf("break %d = %+v; want %+v", i, *b, tt.want[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,378 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+var (
+	ErrDuplicateSerial = errors.New("duplicate serial")
+	ErrDuplicateReport = errors.New("duplicate report")
+)
+
+// The kinds of registry report, and the states a report moves through. A report is
+// pending until a member of staff verifies or dismisses it.
+const (
+	ReportStolen    = "stolen"
+	ReportLost      = "lost"
+	ReportVerified  = "verified"
+	ReportDismissed = "dismissed"
+)
+
+var ReportStatuses = []string{ReviewPending, ReportVerified, ReportDismissed}
+
+var (
+	serialPunctuationRX = regexp.MustCompile(`[\s\-./]+`)
+	SerialRX            = regexp.MustCompile(`^[A-Z0-9]{3,40}$`)
+)
+
+// NormalizeSerial puts a serial number in the form it is stored and matched in, so
+// that "ab-123 456" and "AB123456" are the same serial.
+func NormalizeSerial(serial string) string {
+	return serialPunctuationRX.ReplaceAllString(strings.ToUpper(serial), "")
+}
+
+// ValidateSerialNumber checks a serial number that has already been normalized.
+func ValidateSerialNumber(v *validator.Validator, serial string) {
+	v.Check(serial != "", "serial", "must be provided")
+	v.Check(serial == "" || validator.Matches(serial, SerialRX), "serial", "must be 3 to 40 letters and digits")
+}
+
+// Serial is the serial number of one piece of a watch. BrandID must be one of the
+// watch's brands.
+type Serial struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	WatchID   int64     `json:"watch_id"`
+	BrandID   int64     `json:"brand_id"`
+	Brand     string    `json:"brand"`
+	Serial    string    `json:"serial"`
+}
+
+func ValidateSerial(v *validator.Validator, serial *Serial) {
+	v.Check(serial.BrandID > 0, "brand", "must be provided")
+	ValidateSerialNumber(v, serial.Serial)
+}
+
+// RegistryReport is a report that a watch has been stolen or lost. The reporter's
+// name and email are only used to tell them the outcome of the review.
+type RegistryReport struct {
+	ID              int64      `json:"id"`
+	CreatedAt       time.Time  `json:"created_at"`
+	BrandID         int64      `json:"brand_id"`
+	Brand           string     `json:"brand"`
+	Serial          string     `json:"serial"`
+	Kind            string     `json:"kind"`
+	OccurredOn      *time.Time `json:"occurred_on,omitempty"`
+	Description     string     `json:"description,omitempty"`
+	PoliceReference string     `json:"police_reference,omitempty"`
+	UserID          int64      `json:"reported_by"`
+	ReporterName    string     `json:"-"`
+	ReporterEmail   string     `json:"-"`
+	Status          string     `json:"status"`
+	ReviewedBy      *int64     `json:"reviewed_by,omitempty"`
+	ReviewedAt      *time.Time `json:"reviewed_at,omitempty"`
+	ReviewNote      string     `json:"review_note,omitempty"`
+	Version         int32      `json:"version"`
+}
+
+func ValidateRegistryReport(v *validator.Validator, report *RegistryReport) {
+	v.Check(report.BrandID > 0, "brand", "must be provided")
+	ValidateSerialNumber(v, report.Serial)
+	v.Check(validator.In(report.Kind, ReportStolen, ReportLost), "kind", "must be stolen or lost")
+	if report.OccurredOn != nil {
+		v.Check(!report.OccurredOn.After(time.Now()), "occurred_on", "must not be in the future")
+	}
+	v.Check(len(report.Description) <= 2000, "description", "must not be more than 2000 bytes long")
+	v.Check(len(report.PoliceReference) <= 100, "police_reference", "must not be more than 100 bytes long")
+}
+
+func ValidateRegistryReportReview(v *validator.Validator, report *RegistryReport) {
+	v.Check(validator.In(report.Status, ReportVerified, ReportDismissed), "status", "must be verified or dismissed")
+	v.Check(len(report.ReviewNote) <= 1000, "note", "must not be more than 1000 bytes long")
+}
+
+// RegistryCheck is the public answer to whether a serial is safe to deal in. It says
+// whether the serial is registered and describes the latest verified report, but never
+// who owns the watch or who reported it. Reports that haven't been reviewed yet could
+// be malicious, so they only show as UnderReview, with no details.
+type RegistryCheck struct {
+	Brand       string         `json:"brand"`
+	Serial      string         `json:"serial"`
+	Registered  bool           `json:"registered"`
+	Reported    bool           `json:"reported"`
+	Report      *ReportSummary `json:"report,omitempty"`
+	UnderReview bool           `json:"under_review"`
+}
+
+type ReportSummary struct {
+	Kind       string    `json:"kind"`
+	ReportedAt time.Time `json:"reported_at"`
+}
+
+type SerialModel struct {
+	DB *sql.DB
+}
+
+// Insert registers a serial for a watch. A serial that is already registered under
+// the same brand returns ErrDuplicateSerial.
+func (m SerialModel) Insert(serial *Serial) error {
+	query := `
+INSERT INTO serial_numbers (watch_id, brand_id, serial)
+VALUES ($1, $2, $3)
+RETURNING id, created_at, (SELECT name FROM brands WHERE id = $2)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, serial.WatchID, serial.BrandID, serial.Serial).Scan(&serial.ID, &serial.CreatedAt, &serial.Brand)
+	if err != nil {
+		var pqErr *pq.Error
+		switch {
+		case errors.As(err, &pqErr) && pqErr.Code == "23505":
+			return ErrDuplicateSerial
+		case errors.As(err, &pqErr) && pqErr.Code == "23503":
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// GetForWatch lists the serials registered for a watch, by brand and then serial.
+func (m SerialModel) GetForWatch(watchID int64) ([]*Serial, error) {
+	query := `
+SELECT serial_numbers.id, serial_numbers.created_at, serial_numbers.watch_id, serial_numbers.brand_id, brands.name, serial_numbers.serial
+FROM serial_numbers
+INNER JOIN brands ON brands.id = serial_numbers.brand_id
+WHERE serial_numbers.watch_id = $1
+ORDER BY brands.name, serial_numbers.serial`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, watchID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	serials := []*Serial{}
+	for rows.Next() {
+		var serial Serial
+		err := rows.Scan(&serial.ID, &serial.CreatedAt, &serial.WatchID, &serial.BrandID, &serial.Brand, &serial.Serial)
+		if err != nil {
+			return nil, err
+		}
+		serials = append(serials, &serial)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return serials, nil
+}
+
+// Delete removes a serial from a watch. It returns ErrRecordNotFound if the serial
+// doesn't belong to the watch.
+func (m SerialModel) Delete(watchID, id int64) error {
+	query := `
+DELETE FROM serial_numbers
+WHERE id = $1 AND watch_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, watchID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// Check looks a serial up in the registry and the reports. Only verified reports are
+// described; pending ones just set UnderReview, and dismissed ones are ignored.
+func (m SerialModel) Check(brand *CatalogEntity, serial string) (*RegistryCheck, error) {
+	query := `
+SELECT EXISTS (SELECT 1 FROM serial_numbers WHERE brand_id = $1 AND serial = $2),
+	EXISTS (SELECT 1 FROM registry_reports WHERE brand_id = $1 AND serial = $2 AND status = 'pending'),
+	report.kind, report.created_at
+FROM (SELECT 1) AS one
+LEFT JOIN LATERAL (
+	SELECT kind, created_at
+	FROM registry_reports
+	WHERE brand_id = $1 AND serial = $2 AND status = 'verified'
+	ORDER BY created_at DESC
+	LIMIT 1
+) AS report ON true`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	check := RegistryCheck{Brand: brand.Name, Serial: serial}
+	var (
+		kind       *string
+		reportedAt *time.Time
+	)
+	err := m.DB.QueryRowContext(ctx, query, brand.ID, serial).Scan(&check.Registered, &check.UnderReview, &kind, &reportedAt)
+	if err != nil {
+		return nil, err
+	}
+	if kind != nil {
+		check.Reported = true
+		check.Report = &ReportSummary{Kind: *kind, ReportedAt: *reportedAt}
+	}
+	return &check, nil
+}
+
+type RegistryReportModel struct {
+	DB *sql.DB
+}
+
+const registryReportColumnsSQL = `registry_reports.id, registry_reports.created_at, registry_reports.brand_id, brands.name,
+	registry_reports.serial, registry_reports.kind, registry_reports.occurred_on, registry_reports.description,
+	registry_reports.police_reference, registry_reports.user_id, users.name, users.email, registry_reports.status,
+	registry_reports.reviewed_by, registry_reports.reviewed_at, registry_reports.review_note, registry_reports.version`
+
+const registryReportJoinsSQL = `INNER JOIN brands ON brands.id = registry_reports.brand_id
+INNER JOIN users ON users.id = registry_reports.user_id`
+
+func (rr *RegistryReport) scanFields() []interface{} {
+	return []interface{}{&rr.ID, &rr.CreatedAt, &rr.BrandID, &rr.Brand,
+		&rr.Serial, &rr.Kind, &rr.OccurredOn, &rr.Description,
+		&rr.PoliceReference, &rr.UserID, &rr.ReporterName, &rr.ReporterEmail, &rr.Status,
+		&rr.ReviewedBy, &rr.ReviewedAt, &rr.ReviewNote, &rr.Version}
+}
+
+// Insert files a new report, which is pending until it is reviewed. A user can only
+// have one pending report for a serial, and a second returns ErrDuplicateReport.
+func (m RegistryReportModel) Insert(report *RegistryReport) error {
+	query := `
+INSERT INTO registry_reports (brand_id, serial, kind, occurred_on, description, police_reference, user_id)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+RETURNING id, created_at, status, version, (SELECT name FROM brands WHERE id = $1)`
+
+	args := []interface{}{report.BrandID, report.Serial, report.Kind, report.OccurredOn, report.Description, report.PoliceReference, report.UserID}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&report.ID, &report.CreatedAt, &report.Status, &report.Version, &report.Brand)
+	if err != nil {
+		var pqErr *pq.Error
+		switch {
+		case errors.As(err, &pqErr) && pqErr.Code == "23505":
+			return ErrDuplicateReport
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (m RegistryReportModel) Get(id int64) (*RegistryReport, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := fmt.Sprintf(`
+SELECT %s
+FROM registry_reports
+%s
+WHERE registry_reports.id = $1`, registryReportColumnsSQL, registryReportJoinsSQL)
+
+	var report RegistryReport
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(report.scanFields()...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &report, nil
+}
+
+// Update saves the review of a report, using the version number to detect concurrent
+// reviews.
+func (m RegistryReportModel) Update(report *RegistryReport) error {
+	query := `
+UPDATE registry_reports
+SET status = $1, reviewed_by = $2, reviewed_at = $3, review_note = $4, version = version + 1
+WHERE id = $5 AND version = $6
+RETURNING version`
+
+	args := []interface{}{report.Status, report.ReviewedBy, report.ReviewedAt, report.ReviewNote, report.ID, report.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&report.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// GetAll lists reports, optionally for a single reporter (0 means any), in a single
+// status and for a single serial.
+func (m RegistryReportModel) GetAll(userID int64, status, serial string, filters Filters) ([]*RegistryReport, Metadata, error) {
+	query := fmt.Sprintf(`
+SELECT count(*) OVER(), %s
+FROM registry_reports
+%s
+WHERE (registry_reports.user_id = $1 OR $1 = 0)
+AND (registry_reports.status = $2 OR $2 = '')
+AND (registry_reports.serial = $3 OR $3 = '')
+ORDER BY registry_reports.%s %s, registry_reports.id ASC
+LIMIT $4 OFFSET $5`, registryReportColumnsSQL, registryReportJoinsSQL, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, status, serial, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	reports := []*RegistryReport{}
+	for rows.Next() {
+		var report RegistryReport
+		err := rows.Scan(append([]interface{}{&totalRecords}, report.scanFields()...)...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		reports = append(reports, &report)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return reports, metadata, nil
+}
@@ -0,0 +1,226 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+var (
+	ErrDuplicateReview = errors.New("the user has already reviewed this watch")
+	ErrNotOwner        = errors.New("the user has not bought this watch")
+)
+
+// The moderation states of a review. Only approved reviews are shown publicly and
+// counted in a watch's rating.
+const (
+	ReviewPending  = "pending"
+	ReviewApproved = "approved"
+	ReviewRejected = "rejected"
+)
+
+var ReviewStatuses = []string{ReviewPending, ReviewApproved, ReviewRejected}
+
+// ownedWatchSQL is true when the user in $2 has bought the watch in $1, that is, when
+// one of their orders containing it has been shipped or delivered.
+const ownedWatchSQL = `EXISTS (
+	SELECT 1 FROM order_lines INNER JOIN orders ON orders.id = order_lines.order_id
+	WHERE order_lines.watch_id = $1 AND orders.user_id = $2 AND orders.status IN ('shipped', 'delivered'))`
+
+// The rating of a watch is the average of its approved reviews. A watch with no
+// approved reviews has no rating, and sorts after the rated watches.
+const (
+	watchRatingSQL      = `(SELECT round(avg(reviews.rating), 2)::float8 FROM reviews WHERE reviews.watch_id = watches.id AND reviews.status = 'approved')`
+	watchReviewCountSQL = `(SELECT count(*) FROM reviews WHERE reviews.watch_id = watches.id AND reviews.status = 'approved')`
+)
+
+// Review is a customer's rating of a watch they bought. Author is the reviewer's name;
+// their user id isn't published.
+type Review struct {
+	ID             int64      `json:"id"`
+	CreatedAt      time.Time  `json:"created_at"`
+	WatchID        int64      `json:"watch_id"`
+	UserID         int64      `json:"-"`
+	Author         string     `json:"author"`
+	Rating         int        `json:"rating"`
+	Text           string     `json:"text"`
+	Status         string     `json:"status"`
+	ModeratedBy    *int64     `json:"moderated_by,omitempty"`
+	ModeratedAt    *time.Time `json:"moderated_at,omitempty"`
+	ModerationNote string     `json:"moderation_note,omitempty"`
+	Version        int32      `json:"version"`
+}
+
+func ValidateReview(v *validator.Validator, review *Review) {
+	v.Check(review.Rating >= 1 && review.Rating <= 5, "rating", "must be between 1 and 5")
+	v.Check(review.Text != "", "text", "must be provided")
+	v.Check(len(review.Text) <= 5000, "text", "must not be more than 5000 bytes long")
+}
+
+func ValidateReviewModeration(v *validator.Validator, review *Review) {
+	v.Check(validator.In(review.Status, ReviewApproved, ReviewRejected), "status", "must be approved or rejected")
+	v.Check(len(review.ModerationNote) <= 1000, "note", "must not be more than 1000 bytes long")
+}
+
+type ReviewModel struct {
+	DB *sql.DB
+}
+
+const reviewColumnsSQL = `reviews.id, reviews.created_at, reviews.watch_id, reviews.user_id, users.name, reviews.rating, reviews.text,
+	reviews.status, reviews.moderated_by, reviews.moderated_at, reviews.moderation_note, reviews.version`
+
+func (r *Review) scanFields() []interface{} {
+	return []interface{}{&r.ID, &r.CreatedAt, &r.WatchID, &r.UserID, &r.Author, &r.Rating, &r.Text,
+		&r.Status, &r.ModeratedBy, &r.ModeratedAt, &r.ModerationNote, &r.Version}
+}
+
+// Insert saves a new review, which waits in the moderation queue until it is approved.
+// The user must have bought the watch, and may only review it once.
+func (m ReviewModel) Insert(review *Review) error {
+	query := fmt.Sprintf(`
+INSERT INTO reviews (watch_id, user_id, rating, text)
+SELECT $1, $2, $3, $4
+WHERE %s
+RETURNING id, created_at, status, version`, ownedWatchSQL)
+
+	args := []interface{}{review.WatchID, review.UserID, review.Rating, review.Text}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&review.ID, &review.CreatedAt, &review.Status, &review.Version)
+	if err != nil {
+		var pqErr *pq.Error
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrNotOwner
+		case errors.As(err, &pqErr) && pqErr.Code == "23505":
+			return ErrDuplicateReview
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (m ReviewModel) Get(id int64) (*Review, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := fmt.Sprintf(`
+SELECT %s
+FROM reviews
+INNER JOIN users ON users.id = reviews.user_id
+WHERE reviews.id = $1`, reviewColumnsSQL)
+
+	var review Review
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(review.scanFields()...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &review, nil
+}
+
+// Update saves an edited review, or a moderation decision, using the version number to
+// detect concurrent changes.
+func (m ReviewModel) Update(review *Review) error {
+	query := `
+UPDATE reviews
+SET rating = $1, text = $2, status = $3, moderated_by = $4, moderated_at = $5, moderation_note = $6, version = version + 1
+WHERE id = $7 AND version = $8
+RETURNING version`
+
+	args := []interface{}{review.Rating, review.Text, review.Status, review.ModeratedBy, review.ModeratedAt, review.ModerationNote, review.ID, review.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&review.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (m ReviewModel) Delete(id, userID int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+	query := `
+DELETE FROM reviews
+WHERE id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// GetAll lists reviews, optionally for a single watch or user (0 means any) and in a
+// single status.
+func (m ReviewModel) GetAll(watchID, userID int64, status string, filters Filters) ([]*Review, Metadata, error) {
+	query := fmt.Sprintf(`
+SELECT count(*) OVER(), %s
+FROM reviews
+INNER JOIN users ON users.id = reviews.user_id
+WHERE (reviews.watch_id = $1 OR $1 = 0)
+AND (reviews.user_id = $2 OR $2 = 0)
+AND (reviews.status = $3 OR $3 = '')
+ORDER BY reviews.%s %s, reviews.id ASC
+LIMIT $4 OFFSET $5`, reviewColumnsSQL, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, watchID, userID, status, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	reviews := []*Review{}
+	for rows.Next() {
+		var review Review
+		err := rows.Scan(append([]interface{}{&totalRecords}, review.scanFields()...)...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		reviews = append(reviews, &review)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return reviews, metadata, nil
+}
@@ -0,0 +1,259 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+var ErrDuplicateSavedSearch = errors.New("duplicate saved search name")
+
+// How often a saved search's digest of new matches is emailed.
+const (
+	DigestDaily  = "daily"
+	DigestWeekly = "weekly"
+)
+
+// SavedSearch is a named watch list query. Query holds the filter parameters in the
+// same form as the query string of the watch list, and Sort one of its sort values.
+// LastNotifiedAt is the watermark up to which new matches have been sent; the next
+// digest includes the watches added after it.
+type SavedSearch struct {
+	ID             int64     `json:"id"`
+	CreatedAt      time.Time `json:"created_at"`
+	UserID         int64     `json:"-"`
+	Name           string    `json:"name"`
+	Query          string    `json:"query"`
+	Sort           string    `json:"sort"`
+	Frequency      string    `json:"frequency"`
+	LastNotifiedAt time.Time `json:"last_notified_at"`
+	Version        int32     `json:"version"`
+}
+
+// DueSearch is a saved search whose digest is due, with the owner to send it to.
+type DueSearch struct {
+	Search *SavedSearch
+	Name   string
+	Email  string
+}
+
+func ValidateSavedSearch(v *validator.Validator, search *SavedSearch) {
+	v.Check(search.Name != "", "name", "must be provided")
+	v.Check(len(search.Name) <= 100, "name", "must not be more than 100 bytes long")
+	v.Check(len(search.Query) <= 2000, "query", "must not be more than 2000 bytes long")
+	v.Check(validator.In(search.Frequency, DigestDaily, DigestWeekly), "frequency", "must be daily or weekly")
+}
+
+type SavedSearchModel struct {
+	DB *sql.DB
+}
+
+const savedSearchColumnsSQL = `id, created_at, user_id, name, query, sort, frequency, last_notified_at, version`
+
+func (s *SavedSearch) scanFields() []interface{} {
+	return []interface{}{&s.ID, &s.CreatedAt, &s.UserID, &s.Name, &s.Query, &s.Sort, &s.Frequency, &s.LastNotifiedAt, &s.Version}
+}
+
+// Insert saves a search. Its watermark starts at the time it was created, so the first
+// digest only includes watches added since.
+func (m SavedSearchModel) Insert(search *SavedSearch) error {
+	query := `
+INSERT INTO saved_searches (user_id, name, query, sort, frequency)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING id, created_at, last_notified_at, version`
+
+	args := []interface{}{search.UserID, search.Name, search.Query, search.Sort, search.Frequency}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&search.ID, &search.CreatedAt, &search.LastNotifiedAt, &search.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "saved_searches_user_id_name_key"`:
+			return ErrDuplicateSavedSearch
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// Get fetches one of a user's saved searches. Searches saved by other users are
+// reported as not found.
+func (m SavedSearchModel) Get(id, userID int64) (*SavedSearch, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := fmt.Sprintf(`
+SELECT %s
+FROM saved_searches
+WHERE id = $1 AND user_id = $2`, savedSearchColumnsSQL)
+
+	var search SavedSearch
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id, userID).Scan(search.scanFields()...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &search, nil
+}
+
+func (m SavedSearchModel) GetAll(userID int64, filters Filters) ([]*SavedSearch, Metadata, error) {
+	query := fmt.Sprintf(`
+SELECT count(*) OVER(), %s
+FROM saved_searches
+WHERE user_id = $1
+ORDER BY %s %s, id ASC
+LIMIT $2 OFFSET $3`, savedSearchColumnsSQL, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	searches := []*SavedSearch{}
+	for rows.Next() {
+		var search SavedSearch
+		err := rows.Scan(append([]interface{}{&totalRecords}, search.scanFields()...)...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		searches = append(searches, &search)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return searches, metadata, nil
+}
+
+func (m SavedSearchModel) Update(search *SavedSearch) error {
+	query := `
+UPDATE saved_searches
+SET name = $1, query = $2, sort = $3, frequency = $4, version = version + 1
+WHERE id = $5 AND version = $6
+RETURNING version`
+
+	args := []interface{}{search.Name, search.Query, search.Sort, search.Frequency, search.ID, search.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&search.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "saved_searches_user_id_name_key"`:
+			return ErrDuplicateSavedSearch
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (m SavedSearchModel) Delete(id, userID int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+	query := `
+DELETE FROM saved_searches
+WHERE id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// GetDue returns the saved searches of activated users whose digest is due: a day or
+// a week, depending on the frequency, after their watermark.
+func (m SavedSearchModel) GetDue() ([]*DueSearch, error) {
+	query := `
+SELECT s.id, s.created_at, s.user_id, s.name, s.query, s.sort, s.frequency, s.last_notified_at, s.version,
+	users.name, users.email
+FROM saved_searches s
+INNER JOIN users ON users.id = s.user_id
+WHERE users.activated
+AND s.last_notified_at <= NOW() - CASE s.frequency WHEN 'weekly' THEN interval '7 days' ELSE interval '1 day' END
+ORDER BY s.last_notified_at, s.id
+LIMIT 500`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	due := []*DueSearch{}
+	for rows.Next() {
+		d := DueSearch{Search: &SavedSearch{}}
+		err := rows.Scan(append(d.Search.scanFields(), &d.Name, &d.Email)...)
+		if err != nil {
+			return nil, err
+		}
+		due = append(due, &d)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return due, nil
+}
+
+// Advance moves a search's watermark forward once its digest has been handled. Like
+// Update(), it returns ErrEditConflict if the search changed since it was read.
+func (m SavedSearchModel) Advance(search *SavedSearch, watermark time.Time) error {
+	query := `
+UPDATE saved_searches
+SET last_notified_at = $1, version = version + 1
+WHERE id = $2 AND version = $3
+RETURNING version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, watermark, search.ID, search.Version).Scan(&search.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	search.LastNotifiedAt = watermark
+	return nil
+}
@@ -0,0 +1,375 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+var ErrUnknownOwner = errors.New("owner user does not exist")
+
+// ServiceRecord is one service of a watch. UserID is whoever logged the record, and
+// OwnerID the customer who owns the watch, who is reminded by email as NextDueOn
+// approaches. Without an owner, whoever logged the record is reminded instead. Either
+// is nil once their account is deleted.
+type ServiceRecord struct {
+	ID         int64      `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	WatchID    int64      `json:"watch_id"`
+	UserID     *int64     `json:"logged_by"`
+	OwnerID    *int64     `json:"owner_id"`
+	ServicedOn time.Time  `json:"serviced_on"`
+	Workshop   string     `json:"workshop"`
+	WorkDone   string     `json:"work_done"`
+	Parts      []string   `json:"parts"`
+	Cost       *Money     `json:"cost,omitempty"`
+	NextDueOn  *time.Time `json:"next_due_on,omitempty"`
+	Version    int32      `json:"version"`
+}
+
+func ValidateServiceRecord(v *validator.Validator, record *ServiceRecord) {
+	v.Check(record.OwnerID == nil || *record.OwnerID > 0, "owner_id", "must be a positive integer")
+	v.Check(!record.ServicedOn.IsZero(), "serviced_on", "must be provided")
+	v.Check(!record.ServicedOn.After(time.Now()), "serviced_on", "must not be in the future")
+	v.Check(record.Workshop != "", "workshop", "must be provided")
+	v.Check(len(record.Workshop) <= 200, "workshop", "must not be more than 200 bytes long")
+	v.Check(record.WorkDone != "", "work_done", "must be provided")
+	v.Check(len(record.WorkDone) <= 5000, "work_done", "must not be more than 5000 bytes long")
+	v.Check(len(record.Parts) <= 50, "parts", "must not contain more than 50 entries")
+	for _, part := range record.Parts {
+		v.Check(part != "" && len(part) <= 200, "parts", "must only contain parts of 1 to 200 bytes")
+	}
+	if record.Cost != nil {
+		ValidateMoney(v, "cost", *record.Cost)
+	}
+	if record.NextDueOn != nil {
+		v.Check(record.NextDueOn.After(record.ServicedOn), "next_due_on", "must be after serviced_on")
+	}
+}
+
+// ServiceDue is a watch whose next service is due, taken from its latest record.
+type ServiceDue struct {
+	WatchID    int64     `json:"watch_id"`
+	WatchTitle string    `json:"watch_title"`
+	RecordID   int64     `json:"service_record_id"`
+	ServicedOn time.Time `json:"last_serviced_on"`
+	Workshop   string    `json:"workshop"`
+	NextDueOn  time.Time `json:"next_due_on"`
+	Overdue    bool      `json:"overdue"`
+}
+
+// ServiceReminder is a due service that the owner of the watch, or whoever logged the
+// record, hasn't been reminded of yet.
+type ServiceReminder struct {
+	Record     *ServiceRecord
+	WatchTitle string
+	Name       string
+	Email      string
+}
+
+// latestServiceRecordSQL is true for the latest record of each watch. Only the latest
+// record's next due date counts; an earlier one is superseded by the service after it.
+const latestServiceRecordSQL = `NOT EXISTS (SELECT 1 FROM service_records later
+	WHERE later.watch_id = service_records.watch_id
+	AND (later.serviced_on, later.id) > (service_records.serviced_on, service_records.id))`
+
+// watchBuyerSQL finds the customer of the latest delivered order for the watch in $1.
+const watchBuyerSQL = `SELECT orders.user_id
+	FROM orders
+	INNER JOIN order_lines ON order_lines.order_id = orders.id
+	WHERE order_lines.watch_id = $1 AND orders.status = 'delivered'
+	ORDER BY orders.created_at DESC, orders.id DESC
+	LIMIT 1`
+
+type ServiceRecordModel struct {
+	DB *sql.DB
+}
+
+const serviceRecordColumnsSQL = `service_records.id, service_records.created_at, service_records.watch_id, service_records.user_id, service_records.owner_id,
+	service_records.serviced_on, service_records.workshop, service_records.work_done, service_records.parts,
+	service_records.cost_amount, service_records.cost_currency, service_records.next_due_on, service_records.version`
+
+// serviceRecordScan holds the nullable cost columns while a record is scanned; call
+// finish() afterwards to fill in Cost.
+type serviceRecordScan struct {
+	record       *ServiceRecord
+	costAmount   sql.NullInt64
+	costCurrency sql.NullString
+}
+
+func (s *serviceRecordScan) fields() []interface{} {
+	r := s.record
+	return []interface{}{&r.ID, &r.CreatedAt, &r.WatchID, &r.UserID, &r.OwnerID,
+		&r.ServicedOn, &r.Workshop, &r.WorkDone, pq.Array(&r.Parts),
+		&s.costAmount, &s.costCurrency, &r.NextDueOn, &r.Version}
+}
+
+func (s *serviceRecordScan) finish() {
+	if s.costAmount.Valid {
+		s.record.Cost = &Money{Amount: s.costAmount.Int64, Currency: s.costCurrency.String}
+	}
+}
+
+func (r *ServiceRecord) costArgs() (*int64, *string) {
+	if r.Cost == nil {
+		return nil, nil
+	}
+	return &r.Cost.Amount, &r.Cost.Currency
+}
+
+// Insert logs a service. Without an OwnerID, the owner is taken to be the customer who
+// last had the watch delivered, if anyone has. It returns ErrRecordNotFound if the
+// watch doesn't exist and ErrUnknownOwner if the owner doesn't.
+func (m ServiceRecordModel) Insert(record *ServiceRecord) error {
+	query := fmt.Sprintf(`
+INSERT INTO service_records (watch_id, user_id, owner_id, serviced_on, workshop, work_done, parts, cost_amount, cost_currency, next_due_on)
+VALUES ($1, $2, COALESCE($3::bigint, (%s)), $4, $5, $6, $7, $8, $9, $10)
+RETURNING id, created_at, owner_id, version`, watchBuyerSQL)
+
+	costAmount, costCurrency := record.costArgs()
+	args := []interface{}{record.WatchID, record.UserID, record.OwnerID, record.ServicedOn, record.Workshop, record.WorkDone,
+		pq.Array(record.Parts), costAmount, costCurrency, record.NextDueOn}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&record.ID, &record.CreatedAt, &record.OwnerID, &record.Version)
+	if err != nil {
+		var pqErr *pq.Error
+		switch {
+		case errors.As(err, &pqErr) && pqErr.Code == "23503" && pqErr.Constraint == "service_records_owner_id_fkey":
+			return ErrUnknownOwner
+		case errors.As(err, &pqErr) && pqErr.Code == "23503":
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (m ServiceRecordModel) Get(id int64) (*ServiceRecord, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := fmt.Sprintf(`
+SELECT %s
+FROM service_records
+WHERE service_records.id = $1`, serviceRecordColumnsSQL)
+
+	scan := serviceRecordScan{record: &ServiceRecord{}}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(scan.fields()...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	scan.finish()
+	return scan.record, nil
+}
+
+// Update saves changes to a record, using the version number to detect concurrent
+// changes. Changing the next due date means a new reminder will be sent for it. It
+// returns ErrUnknownOwner if the owner doesn't exist.
+func (m ServiceRecordModel) Update(record *ServiceRecord) error {
+	query := `
+UPDATE service_records
+SET owner_id = $1, serviced_on = $2, workshop = $3, work_done = $4, parts = $5, cost_amount = $6, cost_currency = $7,
+	reminder_sent_at = CASE WHEN next_due_on IS DISTINCT FROM $8 THEN NULL ELSE reminder_sent_at END,
+	next_due_on = $8, version = version + 1
+WHERE id = $9 AND version = $10
+RETURNING version`
+
+	costAmount, costCurrency := record.costArgs()
+	args := []interface{}{record.OwnerID, record.ServicedOn, record.Workshop, record.WorkDone, pq.Array(record.Parts),
+		costAmount, costCurrency, record.NextDueOn, record.ID, record.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&record.Version)
+	if err != nil {
+		var pqErr *pq.Error
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		case errors.As(err, &pqErr) && pqErr.Code == "23503":
+			return ErrUnknownOwner
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (m ServiceRecordModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+	query := `
+DELETE FROM service_records
+WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// GetAll lists the service history of a watch.
+func (m ServiceRecordModel) GetAll(watchID int64, filters Filters) ([]*ServiceRecord, Metadata, error) {
+	query := fmt.Sprintf(`
+SELECT count(*) OVER(), %s
+FROM service_records
+WHERE service_records.watch_id = $1
+ORDER BY service_records.%s %s, service_records.id DESC
+LIMIT $2 OFFSET $3`, serviceRecordColumnsSQL, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, watchID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	records := []*ServiceRecord{}
+	for rows.Next() {
+		scan := serviceRecordScan{record: &ServiceRecord{}}
+		err := rows.Scan(append([]interface{}{&totalRecords}, scan.fields()...)...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		scan.finish()
+		records = append(records, scan.record)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return records, metadata, nil
+}
+
+// GetDue lists the watches whose next service is due on or before the given date,
+// including overdue ones, soonest first.
+func (m ServiceRecordModel) GetDue(before time.Time, filters Filters) ([]*ServiceDue, Metadata, error) {
+	query := fmt.Sprintf(`
+SELECT count(*) OVER(), watches.id, watches.title, service_records.id, service_records.serviced_on,
+	service_records.workshop, service_records.next_due_on, service_records.next_due_on < CURRENT_DATE
+FROM service_records
+INNER JOIN watches ON watches.id = service_records.watch_id
+WHERE service_records.next_due_on <= $1
+AND %s
+ORDER BY service_records.next_due_on ASC, watches.id ASC
+LIMIT $2 OFFSET $3`, latestServiceRecordSQL)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, before, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	due := []*ServiceDue{}
+	for rows.Next() {
+		var d ServiceDue
+		err := rows.Scan(&totalRecords, &d.WatchID, &d.WatchTitle, &d.RecordID, &d.ServicedOn, &d.Workshop, &d.NextDueOn, &d.Overdue)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		due = append(due, &d)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return due, metadata, nil
+}
+
+// GetReminders returns the latest records whose next service is due within the given
+// number of days and whose reminder hasn't been sent, addressed to the owner of the
+// watch or, without one, to whoever logged the record. Records with no one to remind,
+// or whose user isn't activated, are left out by the join.
+func (m ServiceRecordModel) GetReminders(days int) ([]*ServiceReminder, error) {
+	query := fmt.Sprintf(`
+SELECT %s, watches.title, users.name, users.email
+FROM service_records
+INNER JOIN watches ON watches.id = service_records.watch_id
+INNER JOIN users ON users.id = COALESCE(service_records.owner_id, service_records.user_id)
+WHERE users.activated
+AND service_records.reminder_sent_at IS NULL
+AND service_records.next_due_on <= CURRENT_DATE + $1::integer
+AND %s
+ORDER BY service_records.next_due_on, service_records.id
+LIMIT 500`, serviceRecordColumnsSQL, latestServiceRecordSQL)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, days)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reminders := []*ServiceReminder{}
+	for rows.Next() {
+		reminder := ServiceReminder{Record: &ServiceRecord{}}
+		scan := serviceRecordScan{record: reminder.Record}
+		err := rows.Scan(append(scan.fields(), &reminder.WatchTitle, &reminder.Name, &reminder.Email)...)
+		if err != nil {
+			return nil, err
+		}
+		scan.finish()
+		reminders = append(reminders, &reminder)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return reminders, nil
+}
+
+// MarkReminded records that the reminder for a record has been sent. The version
+// isn't changed, as sending a reminder isn't an edit, but the record is only marked if
+// its due date is still the one that was reminded about.
+func (m ServiceRecordModel) MarkReminded(record *ServiceRecord) error {
+	query := `
+UPDATE service_records
+SET reminder_sent_at = NOW()
+WHERE id = $1 AND next_due_on = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, record.ID, record.NextDueOn)
+	return err
+}
@@ -0,0 +1,152 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+// Similarity configures how watches are compared for recommendations. Each weight
+// sets how much one attribute counts towards the score, and YearRange is the gap in
+// years at which two watches are no longer considered close in age.
+type Similarity struct {
+	BrandWeight    float64
+	MaterialWeight float64
+	PriceWeight    float64
+	YearWeight     float64
+	SpecsWeight    float64
+	YearRange      int
+}
+
+func ValidateSimilarity(v *validator.Validator, s Similarity) {
+	for key, weight := range map[string]float64{
+		"brand": s.BrandWeight, "material": s.MaterialWeight, "price": s.PriceWeight, "year": s.YearWeight, "specs": s.SpecsWeight,
+	} {
+		v.Check(weight >= 0, key, "weight must not be negative")
+	}
+	v.Check(s.totalWeight() > 0, "weights", "at least one weight must be greater than zero")
+	v.Check(s.YearRange > 0, "year_range", "must be greater than zero")
+}
+
+func (s Similarity) totalWeight() float64 {
+	return s.BrandWeight + s.MaterialWeight + s.PriceWeight + s.YearWeight + s.SpecsWeight
+}
+
+// SimilarWatch is a recommended watch with its similarity score, from 0 to 1.
+type SimilarWatch struct {
+	Watch *Watches `json:"watch"`
+	Score float64  `json:"score"`
+}
+
+// similarityScoreSQL scores each watch against the target CTE of GetSimilar. Every
+// part is between 0 and 1: the share of the target's brands, materials and spec values
+// that a watch has, and how close its price (compared in the base currency) and year
+// are. The weights are in $2 to $6 and the year range in $7.
+var similarityScoreSQL = fmt.Sprintf(`(
+	$2 * (SELECT count(*) FROM watches_brands WHERE watches_brands.watch_id = watches.id AND watches_brands.brand_id = ANY(target.brand_ids))::float8
+		/ GREATEST(cardinality(target.brand_ids), 1)
+	+ $3 * (SELECT count(*) FROM watches_materials WHERE watches_materials.watch_id = watches.id AND watches_materials.material_id = ANY(target.material_ids))::float8
+		/ GREATEST(cardinality(target.material_ids), 1)
+	+ $4 * COALESCE(GREATEST(0, 1 - abs(%s - target.base_price) / NULLIF(target.base_price, 0))::float8, 0)
+	+ $5 * GREATEST(0, 1 - abs(year - target.target_year)::float8 / $7)
+	+ $6 * COALESCE((SELECT count(*) FROM jsonb_each(target.target_specs) spec WHERE watches.specs -> spec.key = spec.value)::float8
+		/ NULLIF((SELECT count(*) FROM jsonb_each(target.target_specs)), 0), 0)
+)`, priceInBaseCurrencySQL())
+
+// similarityCandidatesSQL picks the watches worth scoring against the target CTE of
+// GetSimilar, each branch served by an index: those sharing a brand or a material,
+// those close enough in age to score on year, and those priced in the same currency
+// close enough to score on price. Watches that only share specs aren't scored.
+const similarityCandidatesSQL = `
+	SELECT watch_id AS id FROM watches_brands, target WHERE brand_id = ANY(target.brand_ids)
+	UNION
+	SELECT watch_id FROM watches_materials, target WHERE material_id = ANY(target.material_ids)
+	UNION
+	SELECT watches.id FROM watches, target WHERE year > target.target_year - $7 AND year < target.target_year + $7
+	UNION
+	SELECT watches.id FROM watches, target
+	WHERE price_currency = target.target_currency AND price_amount > 0 AND price_amount < 2 * target.target_amount`
+
+// GetSimilar lists the published watches most similar to the given one, best match
+// first. The scores are computed in a single query, so there's nothing to keep up to
+// date as the catalog changes, but only for the candidates that could score well.
+func (w WatchesModel) GetSimilar(id int64, similarity Similarity, filters Filters) ([]*SimilarWatch, Metadata, error) {
+	if id < 1 {
+		return nil, Metadata{}, ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var exists bool
+	err := w.DB.QueryRowContext(ctx, `SELECT true FROM watches WHERE id = $1`, id).Scan(&exists)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, Metadata{}, ErrRecordNotFound
+		default:
+			return nil, Metadata{}, err
+		}
+	}
+
+	// The target's columns are renamed so that they don't clash with the unqualified
+	// watch columns in watchesColumnsSQL() and priceInBaseCurrencySQL().
+	query := fmt.Sprintf(`
+WITH target AS (
+	SELECT watches.id AS target_id, year AS target_year, COALESCE(specs, '{}') AS target_specs, %[1]s AS base_price,
+		price_currency AS target_currency, price_amount AS target_amount,
+		ARRAY(SELECT brand_id FROM watches_brands WHERE watches_brands.watch_id = watches.id) AS brand_ids,
+		ARRAY(SELECT material_id FROM watches_materials WHERE watches_materials.watch_id = watches.id) AS material_ids
+	FROM watches %[2]s
+	WHERE watches.id = $1
+), candidates AS (%[5]s
+)
+SELECT count(*) OVER(), %[3]s, similarity.score
+FROM watches %[2]s
+INNER JOIN candidates ON candidates.id = watches.id
+CROSS JOIN target
+CROSS JOIN LATERAL (SELECT %[4]s / $8 AS score) similarity
+WHERE watches.id <> target.target_id AND watches.status = 'published'
+ORDER BY similarity.score DESC, watches.id ASC
+LIMIT $9 OFFSET $10`, priceInBaseCurrencySQL(), latestRateJoinSQL, watchesColumnsSQL(), similarityScoreSQL, similarityCandidatesSQL)
+
+	args := []interface{}{
+		id,
+		similarity.BrandWeight,
+		similarity.MaterialWeight,
+		similarity.PriceWeight,
+		similarity.YearWeight,
+		similarity.SpecsWeight,
+		similarity.YearRange,
+		similarity.totalWeight(),
+		filters.limit(),
+		filters.offset(),
+	}
+	rows, err := w.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	similar := []*SimilarWatch{}
+	for rows.Next() {
+		s := SimilarWatch{Watch: &Watches{}}
+		dest := append([]interface{}{&totalRecords}, s.Watch.scanFields()...)
+		err := rows.Scan(append(dest, &s.Score)...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		similar = append(similar, &s)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return similar, metadata, nil
+}
@@ -0,0 +1,426 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+var (
+	ErrDuplicateSpecAttribute = errors.New("duplicate spec attribute")
+	ErrSpecValuesInvalid      = errors.New("existing spec values are invalid")
+)
+
+// The types that a spec attribute can take.
+const (
+	SpecTypeString  = "string"
+	SpecTypeInteger = "integer"
+	SpecTypeNumber  = "number"
+	SpecTypeBoolean = "boolean"
+	SpecTypeEnum    = "enum"
+	SpecTypeList    = "list"
+)
+
+var (
+	SpecTypes         = []string{SpecTypeString, SpecTypeInteger, SpecTypeNumber, SpecTypeBoolean, SpecTypeEnum, SpecTypeList}
+	SpecAttributeRX   = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+	specFilterOpSplit = regexp.MustCompile(`^(.+)_(gte|lte|gt|lt)$`)
+)
+
+// SpecAttribute is an admin-defined entry in the technical specification schema, such
+// as "case_mm" (a number measured in mm) or "movement" (an enum).
+type SpecAttribute struct {
+	ID            int64     `json:"id"`
+	CreatedAt     time.Time `json:"-"`
+	Name          string    `json:"name"`
+	Label         string    `json:"label"`
+	Type          string    `json:"type"`
+	Unit          string    `json:"unit,omitempty"`
+	AllowedValues []string  `json:"allowed_values,omitempty"`
+	Version       int32     `json:"version"`
+}
+
+func (a *SpecAttribute) numeric() bool {
+	return a.Type == SpecTypeInteger || a.Type == SpecTypeNumber
+}
+
+func ValidateSpecAttribute(v *validator.Validator, attribute *SpecAttribute) {
+	v.Check(attribute.Name != "", "name", "must be provided")
+	v.Check(len(attribute.Name) <= 50, "name", "must not be more than 50 bytes long")
+	v.Check(validator.Matches(attribute.Name, SpecAttributeRX), "name", "must start with a letter and only contain lowercase letters, digits and underscores")
+	v.Check(attribute.Label != "", "label", "must be provided")
+	v.Check(len(attribute.Label) <= 200, "label", "must not be more than 200 bytes long")
+	v.Check(validator.In(attribute.Type, SpecTypes...), "type", "must be one of "+strings.Join(SpecTypes, ", "))
+	v.Check(len(attribute.Unit) <= 20, "unit", "must not be more than 20 bytes long")
+	v.Check(attribute.Type != SpecTypeEnum || len(attribute.AllowedValues) > 0, "allowed_values", "must be provided for enum attributes")
+	v.Check(len(attribute.AllowedValues) == 0 || attribute.Type == SpecTypeEnum || attribute.Type == SpecTypeList, "allowed_values", "may only be set for enum and list attributes")
+	v.Check(validator.Unique(attribute.AllowedValues), "allowed_values", "must not contain duplicate values")
+}
+
+// SpecSchema is the full set of spec attributes, keyed by attribute name.
+type SpecSchema map[string]*SpecAttribute
+
+// Specs holds the technical specification values of a watch. It is stored in the
+// watches.specs JSONB column.
+type Specs map[string]interface{}
+
+func (s Specs) Value() (driver.Value, error) {
+	if s == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(s)
+}
+
+func (s *Specs) Scan(src interface{}) error {
+	b, ok := src.([]byte)
+	if !ok {
+		return fmt.Errorf("cannot scan %T into Specs", src)
+	}
+	return json.Unmarshal(b, s)
+}
+
+// ValidateSpecs checks each spec value against the attribute schema. JSON numbers are
+// decoded as float64, so integer attributes are checked for a fractional part.
+func ValidateSpecs(v *validator.Validator, specs Specs, schema SpecSchema) {
+	for name, value := range specs {
+		key := "specs." + name
+		attribute, ok := schema[name]
+		if !ok {
+			v.AddError(key, "is not a known spec attribute")
+			continue
+		}
+
+		switch attribute.Type {
+		case SpecTypeString:
+			s, ok := value.(string)
+			v.Check(ok, key, "must be a string")
+			v.Check(len(s) <= 500, key, "must not be more than 500 bytes long")
+		case SpecTypeInteger:
+			n, ok := value.(float64)
+			v.Check(ok && n == math.Trunc(n), key, "must be an integer")
+			v.Check(n >= 0, key, "must not be negative")
+		case SpecTypeNumber:
+			n, ok := value.(float64)
+			v.Check(ok, key, "must be a number")
+			v.Check(n >= 0, key, "must not be negative")
+		case SpecTypeBoolean:
+			_, ok := value.(bool)
+			v.Check(ok, key, "must be true or false")
+		case SpecTypeEnum:
+			s, ok := value.(string)
+			v.Check(ok && validator.In(s, attribute.AllowedValues...), key, "must be one of "+strings.Join(attribute.AllowedValues, ", "))
+		case SpecTypeList:
+			values, ok := value.([]interface{})
+			if !ok {
+				v.AddError(key, "must be a list of strings")
+				continue
+			}
+			items := make([]string, 0, len(values))
+			for _, item := range values {
+				s, ok := item.(string)
+				if !ok {
+					v.AddError(key, "must be a list of strings")
+					break
+				}
+				items = append(items, s)
+			}
+			v.Check(validator.Unique(items), key, "must not contain duplicate values")
+			if len(attribute.AllowedValues) > 0 {
+				v.Check(validator.AllIn(items, attribute.AllowedValues...), key, "must only contain "+strings.Join(attribute.AllowedValues, ", "))
+			}
+		}
+	}
+}
+
+// SpecFilter is a single condition on a spec attribute, parsed from a query string
+// parameter such as "specs.case_mm_gte=40" or "specs.movement=automatic".
+type SpecFilter struct {
+	Attribute *SpecAttribute
+	Operator  string
+	Value     interface{}
+}
+
+var specFilterOperators = map[string]string{"gte": ">=", "lte": "<=", "gt": ">", "lt": "<"}
+
+// ParseSpecFilter converts a query string key (without the "specs." prefix) and value
+// into a SpecFilter, recording any problems in the validator.
+func ParseSpecFilter(v *validator.Validator, schema SpecSchema, key, value string) *SpecFilter {
+	errorKey := "specs." + key
+	name, operator := key, "="
+	if _, exact := schema[key]; !exact {
+		if matches := specFilterOpSplit.FindStringSubmatch(key); matches != nil {
+			name, operator = matches[1], specFilterOperators[matches[2]]
+		}
+	}
+
+	attribute, ok := schema[name]
+	if !ok {
+		v.AddError(errorKey, "is not a known spec attribute")
+		return nil
+	}
+	filter := &SpecFilter{Attribute: attribute, Operator: operator}
+
+	switch {
+	case attribute.numeric():
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			v.AddError(errorKey, "must be a number")
+			return nil
+		}
+		filter.Value = n
+	case operator != "=":
+		v.AddError(errorKey, "range filters are only supported for numeric attributes")
+		return nil
+	case attribute.Type == SpecTypeBoolean:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			v.AddError(errorKey, "must be true or false")
+			return nil
+		}
+		filter.Value = b
+	case attribute.Type == SpecTypeList:
+		filter.Value = []string{value}
+	default:
+		filter.Value = value
+	}
+	return filter
+}
+
+// sql returns the condition for the filter. Equality uses JSONB containment so that
+// it can be served by the GIN index on watches.specs.
+func (f *SpecFilter) sql(arg func(interface{}) string) string {
+	if f.Operator == "=" {
+		// The value is always a string, float64, bool or []string, so marshaling it
+		// can't fail.
+		containment, _ := json.Marshal(map[string]interface{}{f.Attribute.Name: f.Value})
+		return fmt.Sprintf("specs @> %s::jsonb", arg(string(containment)))
+	}
+	name := arg(f.Attribute.Name)
+	return fmt.Sprintf("(jsonb_typeof(specs -> %[1]s) = 'number' AND (specs ->> %[1]s)::numeric %[2]s %[3]s)",
+		name, f.Operator, arg(f.Value))
+}
+
+type SpecAttributeModel struct {
+	DB *sql.DB
+}
+
+func (m SpecAttributeModel) Insert(attribute *SpecAttribute) error {
+	query := `
+INSERT INTO spec_attributes (name, label, type, unit, allowed_values)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING id, created_at, version`
+
+	args := []interface{}{attribute.Name, attribute.Label, attribute.Type, attribute.Unit, pq.Array(attribute.AllowedValues)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&attribute.ID, &attribute.CreatedAt, &attribute.Version)
+	if err != nil {
+		var pqErr *pq.Error
+		switch {
+		case errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == "spec_attributes_name_key":
+			return ErrDuplicateSpecAttribute
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (m SpecAttributeModel) Get(id int64) (*SpecAttribute, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `
+SELECT id, created_at, name, label, type, unit, allowed_values, version
+FROM spec_attributes
+WHERE id = $1`
+
+	var attribute SpecAttribute
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&attribute.ID,
+		&attribute.CreatedAt,
+		&attribute.Name,
+		&attribute.Label,
+		&attribute.Type,
+		&attribute.Unit,
+		pq.Array(&attribute.AllowedValues),
+		&attribute.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &attribute, nil
+}
+
+// Update changes the label, type, unit and allowed values of an attribute. The name is
+// the key used in watches.specs and can't be changed. The values already stored on
+// watches are checked against the new definition in the same transaction, and
+// ErrSpecValuesInvalid is returned if any of them would no longer be valid.
+func (m SpecAttributeModel) Update(attribute *SpecAttribute) error {
+	query := `
+UPDATE spec_attributes
+SET label = $1, type = $2, unit = $3, allowed_values = $4, version = version + 1
+WHERE id = $5 AND version = $6
+RETURNING version`
+
+	args := []interface{}{attribute.Label, attribute.Type, attribute.Unit, pq.Array(attribute.AllowedValues), attribute.ID, attribute.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var version int32
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	// FOR SHARE stops the values being changed until the new definition is committed.
+	rows, err := tx.QueryContext(ctx, `SELECT specs -> $1 FROM watches WHERE specs ? $1 FOR SHARE`, attribute.Name)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	schema := SpecSchema{attribute.Name: attribute}
+	for rows.Next() {
+		var raw []byte
+		err := rows.Scan(&raw)
+		if err != nil {
+			return err
+		}
+		var value interface{}
+		err = json.Unmarshal(raw, &value)
+		if err != nil {
+			return err
+		}
+		v := validator.New()
+		if ValidateSpecs(v, Specs{attribute.Name: value}, schema); !v.Valid() {
+			return ErrSpecValuesInvalid
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	attribute.Version = version
+	return nil
+}
+
+// Delete removes an attribute from the schema and strips its values from every watch,
+// so that existing watches remain valid against the new schema.
+func (m SpecAttributeModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var name string
+	err = tx.QueryRowContext(ctx, `DELETE FROM spec_attributes WHERE id = $1 RETURNING name`, id).Scan(&name)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE watches SET specs = specs - $1 WHERE specs ? $1`, name)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetSchema returns every spec attribute. The schema is small, so it isn't paginated.
+func (m SpecAttributeModel) GetSchema() (SpecSchema, error) {
+	query := `
+SELECT id, created_at, name, label, type, unit, allowed_values, version
+FROM spec_attributes`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	schema := SpecSchema{}
+	for rows.Next() {
+		var attribute SpecAttribute
+		err := rows.Scan(
+			&attribute.ID,
+			&attribute.CreatedAt,
+			&attribute.Name,
+			&attribute.Label,
+			&attribute.Type,
+			&attribute.Unit,
+			pq.Array(&attribute.AllowedValues),
+			&attribute.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+		schema[attribute.Name] = &attribute
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return schema, nil
+}
+
+// Attributes returns the schema as a slice sorted by name.
+func (s SpecSchema) Attributes() []*SpecAttribute {
+	attributes := make([]*SpecAttribute, 0, len(s))
+	for _, attribute := range s {
+		attributes = append(attributes, attribute)
+	}
+	sort.Slice(attributes, func(i, j int) bool { return attributes[i].Name < attributes[j].Name })
+	return attributes
+}
@@ -0,0 +1,78 @@
+package data
+
+import (
+	"reflect"
+	"testing"
+
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+func TestParseSpecFilter(t *testing.T) {
+	schema := SpecSchema{
+		"case_mm":     {Name: "case_mm", Type: SpecTypeNumber},
+		"jewels":      {Name: "jewels", Type: SpecTypeInteger},
+		"movement":    {Name: "movement", Type: SpecTypeEnum, AllowedValues: []string{"automatic", "manual", "quartz"}},
+		"dial":        {Name: "dial", Type: SpecTypeString},
+		"chronometer": {Name: "chronometer", Type: SpecTypeBoolean},
+		"functions":   {Name: "functions", Type: SpecTypeList},
+		"water_lt":    {Name: "water_lt", Type: SpecTypeString},
+	}
+
+	tests := []struct {
+		key       string
+		value     string
+		attribute string
+		operator  string
+		want      interface{}
+		err       string
+	}{
+		{key: "case_mm", value: "40", attribute: "case_mm", operator: "=", want: 40.0},
+		{key: "case_mm_gte", value: "39.5", attribute: "case_mm", operator: ">=", want: 39.5},
+		{key: "case_mm_lte", value: "42", attribute: "case_mm", operator: "<=", want: 42.0},
+		{key: "jewels_gt", value: "-1", attribute: "jewels", operator: ">", want: -1.0},
+		{key: "jewels_lt", value: "30", attribute: "jewels", operator: "<", want: 30.0},
+		{key: "movement", value: "automatic", attribute: "movement", operator: "=", want: "automatic"},
+		{key: "dial", value: "", attribute: "dial", operator: "=", want: ""},
+		{key: "chronometer", value: "true", attribute: "chronometer", operator: "=", want: true},
+		{key: "chronometer", value: "0", attribute: "chronometer", operator: "=", want: false},
+		{key: "functions", value: "date", attribute: "functions", operator: "=", want: []string{"date"}},
+
+		// An attribute whose name ends like an operator is matched exactly.
+		{key: "water_lt", value: "100m", attribute: "water_lt", operator: "=", want: "100m"},
+
+		{key: "case_mm", value: "forty", err: "must be a number"},
+		{key: "case_mm_gte", value: "", err: "must be a number"},
+		{key: "movement_gte", value: "automatic", err: "range filters are only supported for numeric attributes"},
+		{key: "chronometer_lt", value: "true", err: "range filters are only supported for numeric attributes"},
+		{key: "chronometer", value: "yes", err: "must be true or false"},
+		{key: "bezel", value: "steel", err: "is not a known spec attribute"},
+		{key: "bezel_gte", value: "1", err: "is not a known spec attribute"},
+		{key: "case_mm_eq", value: "40", err: "is not a known spec attribute"},
+		{key: "Case_mm", value: "40", err: "is not a known spec attribute"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key+"="+tt.value, func(t *testing.T) {
+			v := validator.New()
+			filter := ParseSpecFilter(v, schema, tt.key, tt.value)
+
+			if tt.err != "" {
+				if filter != nil {
+					t.Errorf("ParseSpecFilter(%q, %q) = %+v; want nil", tt.key, tt.value, filter)
+				}
+				if got := v.Errors["specs."+tt.key]; got != tt.err {
+					t.Errorf("ParseSpecFilter(%q, %q) error = %q; want %q", tt.key, tt.value, got, tt.err)
+				}
+				return
+			}
+
+			if !v.Valid() {
+				t.Fatalf("ParseSpecFilter(%q, %q) errors = %v; want none", tt.key, tt.value, v.Errors)
+			}
+			if filter.Attribute != schema[tt.attribute] || filter.Operator != tt.operator || !reflect.DeepEqual(filter.Value, tt.want) {
+				t.Errorf("ParseSpecFilter(%q, %q) = {%s %s %#v}; want {%s %s %#v}", tt.key, tt.value,
+					filter.Attribute.Name, filter.Operator, filter.Value, tt.attribute, tt.operator, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,301 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+var spaceRX = regexp.MustCompile(`\s+`)
+
+// Tag is a free-form label on watches, such as "limited edition" or "COSC". Count is
+// the number of published watches with the tag.
+type Tag struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"-"`
+	Name      string    `json:"name"`
+	Slug      string    `json:"slug"`
+	Count     int       `json:"count"`
+	Version   int32     `json:"version"`
+}
+
+// TagName tidies the spacing of a tag name.
+func TagName(name string) string {
+	return spaceRX.ReplaceAllString(strings.TrimSpace(name), " ")
+}
+
+// TagSlug returns the normalized form that tags are matched by. Unlike Slugify it
+// keeps company suffixes, which mean nothing in a tag.
+func TagSlug(name string) string {
+	return strings.Trim(slugSeparatorRX.ReplaceAllString(strings.ToLower(name), "-"), "-")
+}
+
+func ValidateTag(v *validator.Validator, tag *Tag) {
+	v.Check(tag.Name != "", "name", "must be provided")
+	v.Check(len(tag.Name) <= 50, "name", "must not be more than 50 bytes long")
+	v.Check(tag.Slug != "", "name", "must contain at least one letter or digit")
+}
+
+func ValidateTagNames(v *validator.Validator, names []string) {
+	v.Check(len(names) > 0, "tags", "must contain at least one tag")
+	v.Check(len(names) <= 20, "tags", "must not contain more than 20 tags")
+	for _, name := range names {
+		name = TagName(name)
+		v.Check(name != "" && TagSlug(name) != "", "tags", "must not contain empty tags")
+		v.Check(len(name) <= 50, "tags", "must not contain tags more than 50 bytes long")
+	}
+}
+
+// watchTagsSQL selects the names of a watch's tags, for watchesColumnsSQL().
+const watchTagsSQL = `ARRAY(SELECT tags.name FROM watches_tags INNER JOIN tags ON tags.id = watches_tags.tag_id WHERE watches_tags.watch_id = watches.id ORDER BY tags.name)`
+
+// tagsFilterSQL returns a condition matching watches with any, or all, of the tag
+// slugs in the given placeholder.
+func tagsFilterSQL(placeholder string, count int, all bool) string {
+	if all {
+		return fmt.Sprintf(`(SELECT count(*) FROM watches_tags INNER JOIN tags ON tags.id = watches_tags.tag_id
+	WHERE watches_tags.watch_id = watches.id AND tags.slug = ANY(%s)) = %d`, placeholder, count)
+	}
+	return fmt.Sprintf(`EXISTS (SELECT 1 FROM watches_tags INNER JOIN tags ON tags.id = watches_tags.tag_id
+	WHERE watches_tags.watch_id = watches.id AND tags.slug = ANY(%s))`, placeholder)
+}
+
+type TagModel struct {
+	DB *sql.DB
+}
+
+const tagColumnsSQL = `tags.id, tags.created_at, tags.name, tags.slug,
+	(SELECT count(*) FROM watches_tags INNER JOIN watches ON watches.id = watches_tags.watch_id
+		WHERE watches_tags.tag_id = tags.id AND watches.status = 'published') AS count, tags.version`
+
+func (t *Tag) scanFields() []interface{} {
+	return []interface{}{&t.ID, &t.CreatedAt, &t.Name, &t.Slug, &t.Count, &t.Version}
+}
+
+func (m TagModel) Get(id int64) (*Tag, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := fmt.Sprintf(`
+SELECT %s
+FROM tags
+WHERE id = $1`, tagColumnsSQL)
+
+	var tag Tag
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(tag.scanFields()...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &tag, nil
+}
+
+// GetAll lists tags with their counts, for a tag cloud. Tags with fewer than minCount
+// published watches are left out.
+func (m TagModel) GetAll(name string, minCount int, filters Filters) ([]*Tag, Metadata, error) {
+	query := fmt.Sprintf(`
+SELECT count(*) OVER(), *
+FROM (
+	SELECT %s
+	FROM tags
+	WHERE (tags.name ILIKE '%%' || $1 || '%%' OR $1 = '')
+) tags
+WHERE count >= $2
+ORDER BY %s %s, id ASC
+LIMIT $3 OFFSET $4`, tagColumnsSQL, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, name, minCount, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	tags := []*Tag{}
+	for rows.Next() {
+		var tag Tag
+		err := rows.Scan(append([]interface{}{&totalRecords}, tag.scanFields()...)...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		tags = append(tags, &tag)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return tags, metadata, nil
+}
+
+// AddToWatch tags a watch, creating the tags that don't exist yet. Tags the watch
+// already has are left as they are.
+func (m TagModel) AddToWatch(watchID int64, names []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, name := range names {
+		name = TagName(name)
+		query := `
+INSERT INTO tags (name, slug)
+VALUES ($1, $2)
+ON CONFLICT (slug) DO NOTHING`
+		_, err = tx.ExecContext(ctx, query, name, TagSlug(name))
+		if err != nil {
+			return err
+		}
+		query = `
+INSERT INTO watches_tags (watch_id, tag_id)
+SELECT $1, id FROM tags WHERE slug = $2
+ON CONFLICT DO NOTHING`
+		_, err = tx.ExecContext(ctx, query, watchID, TagSlug(name))
+		if err != nil {
+			var pqErr *pq.Error
+			if errors.As(err, &pqErr) && pqErr.Code == "23503" {
+				return ErrRecordNotFound
+			}
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// RemoveFromWatch removes the tag with the given slug from a watch. The tag itself is
+// kept, even if no other watch has it.
+func (m TagModel) RemoveFromWatch(watchID int64, slug string) error {
+	query := `
+DELETE FROM watches_tags
+USING tags
+WHERE watches_tags.tag_id = tags.id AND watches_tags.watch_id = $1 AND tags.slug = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, watchID, TagSlug(slug))
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// Rename changes a tag's name, and with it the slug. If another tag already has the
+// new slug it returns ErrDuplicateSlug; the tags should be merged instead.
+func (m TagModel) Rename(tag *Tag) error {
+	query := `
+UPDATE tags
+SET name = $1, slug = $2, version = version + 1
+WHERE id = $3 AND version = $4
+RETURNING version`
+
+	args := []interface{}{tag.Name, tag.Slug, tag.ID, tag.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&tag.Version)
+	if err != nil {
+		var pqErr *pq.Error
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		case errors.As(err, &pqErr) && pqErr.Code == "23505":
+			return ErrDuplicateSlug
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// Merge moves every watch tagged with the source tag over to the target tag, and then
+// deletes the source tag.
+func (m TagModel) Merge(sourceID, targetID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Lock both tags, in id order, so that merges can't deadlock or interleave.
+	var found int
+	query := `SELECT count(*) FROM (SELECT id FROM tags WHERE id IN ($1, $2) ORDER BY id FOR UPDATE) locked`
+	err = tx.QueryRowContext(ctx, query, sourceID, targetID).Scan(&found)
+	if err != nil {
+		return err
+	}
+	if found != 2 {
+		return ErrRecordNotFound
+	}
+
+	query = `
+INSERT INTO watches_tags (watch_id, tag_id)
+SELECT watch_id, $2 FROM watches_tags WHERE tag_id = $1
+ON CONFLICT DO NOTHING`
+	_, err = tx.ExecContext(ctx, query, sourceID, targetID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM tags WHERE id = $1`, sourceID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (m TagModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+	query := `
+DELETE FROM tags
+WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
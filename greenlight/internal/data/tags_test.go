@@ -0,0 +1,27 @@
+package data
+
+import "testing"
+
+func TestTagSlug(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"Vintage", "vintage"},
+		{"  Box & Papers  ", "box-papers"},
+		{"limited-edition", "limited-edition"},
+		{"Limited   Edition", "limited-edition"},
+		{"Rolex SA", "rolex-sa"},
+		{"1970s", "1970s"},
+		{"--", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := TagSlug(tt.name); got != tt.want {
+				t.Errorf("TagSlug(%q) = %q; want %q", tt.name, got, tt.want)
+			}
+		})
+	}
+}
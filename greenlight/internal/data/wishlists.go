@@ -0,0 +1,106 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// WishlistItem is a watch that a user has saved. The watch is read fresh each time,
+// so it shows the current price and availability.
+type WishlistItem struct {
+	AddedAt time.Time `json:"added_at"`
+	Watch   *Watches  `json:"watch"`
+}
+
+// WishlistModel stores each user's saved watches. Entries are removed along with their
+// watch when it is deleted.
+type WishlistModel struct {
+	DB *sql.DB
+}
+
+// Add saves a watch to a user's wishlist. Adding a watch that is already there does
+// nothing. It returns ErrRecordNotFound if the watch doesn't exist.
+func (m WishlistModel) Add(userID, watchID int64) error {
+	query := `
+INSERT INTO wishlist_items (user_id, watch_id)
+VALUES ($1, $2)
+ON CONFLICT DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, watchID)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23503" {
+			return ErrRecordNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+func (m WishlistModel) Remove(userID, watchID int64) error {
+	query := `
+DELETE FROM wishlist_items
+WHERE user_id = $1 AND watch_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, watchID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// GetAll lists a user's wishlist, leaving out watches that have since been taken off
+// the storefront. It sorts on the same columns as the watch list, plus added_at.
+func (m WishlistModel) GetAll(userID int64, filters Filters) ([]*WishlistItem, Metadata, error) {
+	query := fmt.Sprintf(`
+SELECT count(*) OVER(), wishlist_items.added_at, %s
+FROM wishlist_items
+INNER JOIN watches ON watches.id = wishlist_items.watch_id %s
+WHERE wishlist_items.user_id = $1 AND watches.status = 'published'
+ORDER BY %s, watches.id ASC
+LIMIT $2 OFFSET $3`, watchesColumnsSQL(), latestRateJoinSQL, watchesOrderBy(filters))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	items := []*WishlistItem{}
+	for rows.Next() {
+		item := WishlistItem{Watch: &Watches{}}
+		err := rows.Scan(append([]interface{}{&totalRecords, &item.AddedAt}, item.Watch.scanFields()...)...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		items = append(items, &item)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return items, metadata, nil
+}
@@ -0,0 +1,203 @@
+package images
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+)
+
+var (
+	ErrUnsupportedType = errors.New("image must be a JPEG or PNG file")
+	ErrTooManyPixels   = errors.New("image dimensions are too large")
+	ErrMalformed       = errors.New("image data is malformed")
+)
+
+// MaxPixels limits the decoded size of an upload, so that a small but highly
+// compressed file can't exhaust the server's memory.
+const MaxPixels = 50_000_000
+
+// ThumbnailSizes maps each thumbnail name to the length in pixels of its longest side.
+var ThumbnailSizes = map[string]int{
+	"small":  200,
+	"medium": 800,
+}
+
+// Sniff returns the content type of the image data, or ErrUnsupportedType if it isn't
+// one that we accept.
+func Sniff(data []byte) (string, error) {
+	contentType := http.DetectContentType(data)
+	switch contentType {
+	case "image/jpeg", "image/png":
+		return contentType, nil
+	default:
+		return "", ErrUnsupportedType
+	}
+}
+
+// Decode checks the image dimensions before decoding the full image.
+func Decode(data []byte) (image.Image, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	if cfg.Width*cfg.Height > MaxPixels {
+		return nil, ErrTooManyPixels
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	return img, nil
+}
+
+// StripMetadata removes EXIF, XMP and other embedded metadata from JPEG and PNG files
+// without re-encoding the image data.
+func StripMetadata(data []byte, contentType string) ([]byte, error) {
+	switch contentType {
+	case "image/jpeg":
+		return stripJPEG(data)
+	case "image/png":
+		return stripPNG(data)
+	default:
+		return nil, ErrUnsupportedType
+	}
+}
+
+// stripJPEG drops the APP1-APP15 and COM segments which carry EXIF, XMP, IPTC and
+// comments. APP0 (JFIF) is kept, and everything from the start of scan onwards is
+// copied unchanged.
+func stripJPEG(data []byte) ([]byte, error) {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, ErrMalformed
+	}
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:2])
+
+	i := 2
+	for {
+		if i+4 > len(data) || data[i] != 0xFF {
+			return nil, ErrMalformed
+		}
+		marker := data[i+1]
+		if marker == 0xDA {
+			out.Write(data[i:])
+			return out.Bytes(), nil
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2 : i+4]))
+		end := i + 2 + length
+		if length < 2 || end > len(data) {
+			return nil, ErrMalformed
+		}
+		if !(marker >= 0xE1 && marker <= 0xEF) && marker != 0xFE {
+			out.Write(data[i:end])
+		}
+		i = end
+	}
+}
+
+// stripPNG drops the ancillary chunks that hold EXIF data and free-form text.
+func stripPNG(data []byte) ([]byte, error) {
+	const signatureLength = 8
+	if len(data) < signatureLength {
+		return nil, ErrMalformed
+	}
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:signatureLength])
+
+	i := signatureLength
+	for i < len(data) {
+		if i+8 > len(data) {
+			return nil, ErrMalformed
+		}
+		length := int(binary.BigEndian.Uint32(data[i : i+4]))
+		chunkType := string(data[i+4 : i+8])
+		end := i + 12 + length
+		if length < 0 || end > len(data) {
+			return nil, ErrMalformed
+		}
+		switch chunkType {
+		case "eXIf", "tEXt", "zTXt", "iTXt", "tIME":
+		default:
+			out.Write(data[i:end])
+		}
+		i = end
+	}
+	return out.Bytes(), nil
+}
+
+// Thumbnail scales the image down so that its longest side is at most maxSide pixels,
+// averaging the source pixels that fall into each destination pixel. Images that are
+// already small enough are returned unchanged.
+func Thumbnail(src image.Image, maxSide int) image.Image {
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w <= maxSide && h <= maxSide {
+		return src
+	}
+
+	dw, dh := maxSide, h*maxSide/w
+	if h > w {
+		dw, dh = w*maxSide/h, maxSide
+	}
+	dw, dh = max(dw, 1), max(dh, 1)
+
+	// Each destination pixel averages a block of whole source pixels. columns maps a
+	// source column to the destination column it is averaged into.
+	columns := make([]int, w)
+	for x := 0; x < dw; x++ {
+		for sx := x * w / dw; sx < (x+1)*w/dw; sx++ {
+			columns[sx] = x
+		}
+	}
+
+	// The source rows for each destination row are converted to RGBA in one draw call,
+	// which has fast paths for the types that the JPEG and PNG decoders return, rather
+	// than calling At for every pixel. Converting a band at a time keeps the memory
+	// used to a few rows of the source.
+	band := image.NewRGBA(image.Rect(0, 0, w, (h+dh-1)/dh))
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	sums := make([]uint64, dw*4)
+	counts := make([]uint64, dw)
+	for y := 0; y < dh; y++ {
+		y0, y1 := y*h/dh, (y+1)*h/dh
+		rows := image.Rect(0, 0, w, y1-y0)
+		draw.Draw(band, rows, src, image.Pt(bounds.Min.X, bounds.Min.Y+y0), draw.Src)
+
+		clear(sums)
+		clear(counts)
+		for row := 0; row < rows.Dy(); row++ {
+			pix := band.Pix[row*band.Stride : row*band.Stride+w*4]
+			for sx, x := range columns {
+				s := sums[x*4 : x*4+4]
+				s[0] += uint64(pix[sx*4])
+				s[1] += uint64(pix[sx*4+1])
+				s[2] += uint64(pix[sx*4+2])
+				s[3] += uint64(pix[sx*4+3])
+				counts[x]++
+			}
+		}
+
+		out := dst.Pix[y*dst.Stride : y*dst.Stride+dw*4]
+		for x, n := range counts {
+			for c := 0; c < 4; c++ {
+				out[x*4+c] = uint8(sums[x*4+c] / n)
+			}
+		}
+	}
+	return dst
+}
+
+// EncodeJPEG writes the image as a JPEG, flattening any transparency onto a white
+// background. Re-encoding means the output never contains metadata from the source.
+func EncodeJPEG(w io.Writer, img image.Image) error {
+	flattened := image.NewRGBA(img.Bounds())
+	draw.Draw(flattened, flattened.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(flattened, flattened.Bounds(), img, img.Bounds().Min, draw.Over)
+	return jpeg.Encode(w, flattened, &jpeg.Options{Quality: 85})
+}
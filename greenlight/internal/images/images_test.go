@@ -0,0 +1,283 @@
+package images
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// jpegSegment returns a JPEG marker segment with the given payload.
+func jpegSegment(marker byte, payload string) []byte {
+	segment := []byte{0xFF, marker, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+// pngChunk returns a PNG chunk with the given data. The CRC isn't checked when
+// stripping, so it is left as zero.
+func pngChunk(chunkType, data string) []byte {
+	chunk := make([]byte, 8, 12+len(data))
+	binary.BigEndian.PutUint32(chunk, uint32(len(data)))
+	copy(chunk[4:], chunkType)
+	chunk = append(chunk, data...)
+	return append(chunk, 0, 0, 0, 0)
+}
+
+func join(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+func TestStripJPEG(t *testing.T) {
+	soi := []byte{0xFF, 0xD8}
+	app0 := jpegSegment(0xE0, "JFIF\x00\x01\x01")
+	exif := jpegSegment(0xE1, "Exif\x00\x00gps")
+	xmp := jpegSegment(0xE1, "http://ns.adobe.com/xap/1.0/")
+	iptc := jpegSegment(0xED, "Photoshop 3.0")
+	app15 := jpegSegment(0xEF, "x")
+	comment := jpegSegment(0xFE, "shot on my phone")
+	dqt := jpegSegment(0xDB, "\x00quant")
+	sof := jpegSegment(0xC0, "\x08\x00\x01\x00\x01")
+	// The scan data may contain bytes that look like metadata markers; they must be
+	// copied unchanged.
+	scan := join(jpegSegment(0xDA, "\x01"), []byte{0x12, 0xFF, 0x00, 0xFF, 0xE1, 0x00, 0x04, 0xFF, 0xD9})
+
+	tests := []struct {
+		name  string
+		input []byte
+		want  []byte
+		err   error
+	}{
+		{"no metadata", join(soi, app0, dqt, sof, scan), join(soi, app0, dqt, sof, scan), nil},
+		{"exif and xmp", join(soi, app0, exif, xmp, dqt, sof, scan), join(soi, app0, dqt, sof, scan), nil},
+		{"iptc, app15 and comment", join(soi, iptc, dqt, comment, app15, sof, scan), join(soi, dqt, sof, scan), nil},
+		{"empty segment", join(soi, jpegSegment(0xE1, ""), scan), join(soi, scan), nil},
+
+		{"empty", []byte{}, nil, ErrMalformed},
+		{"missing SOI", join(app0, scan), nil, ErrMalformed},
+		{"SOI only", soi, nil, ErrMalformed},
+		{"no start of scan", join(soi, app0, dqt), nil, ErrMalformed},
+		{"segment runs past the end", join(soi, exif[:len(exif)-1]), nil, ErrMalformed},
+		{"length below two", join(soi, []byte{0xFF, 0xE1, 0x00, 0x01}, scan), nil, ErrMalformed},
+		{"missing marker prefix", join(soi, []byte{0x00, 0xE0, 0x00, 0x02}, scan), nil, ErrMalformed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := stripJPEG(tt.input)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("stripJPEG() error = %v; want %v", err, tt.err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("stripJPEG() = % x; want % x", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStripPNG(t *testing.T) {
+	signature := []byte("\x89PNG\r\n\x1a\n")
+	ihdr := pngChunk("IHDR", "\x00\x00\x00\x01\x00\x00\x00\x01\x08\x02\x00\x00\x00")
+	idat := pngChunk("IDAT", "pixels")
+	iend := pngChunk("IEND", "")
+
+	tests := []struct {
+		name  string
+		input []byte
+		want  []byte
+		err   error
+	}{
+		{"no metadata", join(signature, ihdr, idat, iend), join(signature, ihdr, idat, iend), nil},
+		{
+			"metadata chunks",
+			join(signature, ihdr, pngChunk("eXIf", "MM\x00*"), pngChunk("tEXt", "Author\x00me"),
+				pngChunk("zTXt", "Comment\x00\x00z"), pngChunk("iTXt", "XML:com.adobe.xmp\x00"),
+				pngChunk("tIME", "\x07\xe8\x03\x01\x0c\x1e\x00"), idat, iend),
+			join(signature, ihdr, idat, iend),
+			nil,
+		},
+		{
+			"other ancillary chunks are kept",
+			join(signature, ihdr, pngChunk("gAMA", "\x00\x00\xb1\x8f"), pngChunk("pHYs", "\x00\x00\x0b\x13\x00\x00\x0b\x13\x01"), idat, iend),
+			join(signature, ihdr, pngChunk("gAMA", "\x00\x00\xb1\x8f"), pngChunk("pHYs", "\x00\x00\x0b\x13\x00\x00\x0b\x13\x01"), idat, iend),
+			nil,
+		},
+		{"signature only", signature, signature, nil},
+
+		{"short signature", signature[:7], nil, ErrMalformed},
+		{"truncated chunk header", join(signature, ihdr, iend[:6]), nil, ErrMalformed},
+		{"truncated chunk data", join(signature, ihdr, idat[:len(idat)-5]), nil, ErrMalformed},
+		{"missing CRC", join(signature, ihdr, idat[:len(idat)-4]), nil, ErrMalformed},
+		{"length past the end", join(signature, []byte{0xFF, 0xFF, 0xFF, 0xFF}, []byte("tEXt")), nil, ErrMalformed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := stripPNG(tt.input)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("stripPNG() error = %v; want %v", err, tt.err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("stripPNG() = % x; want % x", got, tt.want)
+			}
+		})
+	}
+}
+
+// naiveThumbnail averages each destination pixel's block of source pixels through At,
+// as a reference for Thumbnail.
+func naiveThumbnail(src image.Image, dw, dh int) *image.RGBA {
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sum [4]uint32
+			n := uint32(0)
+			for sy := y * h / dh; sy < (y+1)*h/dh; sy++ {
+				for sx := x * w / dw; sx < (x+1)*w/dw; sx++ {
+					c := color.RGBAModel.Convert(src.At(bounds.Min.X+sx, bounds.Min.Y+sy)).(color.RGBA)
+					sum[0], sum[1], sum[2], sum[3] = sum[0]+uint32(c.R), sum[1]+uint32(c.G), sum[2]+uint32(c.B), sum[3]+uint32(c.A)
+					n++
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{uint8(sum[0] / n), uint8(sum[1] / n), uint8(sum[2] / n), uint8(sum[3] / n)})
+		}
+	}
+	return dst
+}
+
+func TestThumbnail(t *testing.T) {
+	nrgba := image.NewNRGBA(image.Rect(3, 5, 103, 45))
+	for y := nrgba.Rect.Min.Y; y < nrgba.Rect.Max.Y; y++ {
+		for x := nrgba.Rect.Min.X; x < nrgba.Rect.Max.X; x++ {
+			nrgba.SetNRGBA(x, y, color.NRGBA{uint8(x * 7), uint8(y * 13), uint8(x * y), uint8(128 + x)})
+		}
+	}
+	ycbcr := image.NewYCbCr(image.Rect(0, 0, 37, 91), image.YCbCrSubsampleRatio420)
+	for i := range ycbcr.Y {
+		ycbcr.Y[i] = uint8(i * 31)
+	}
+	for i := range ycbcr.Cb {
+		ycbcr.Cb[i], ycbcr.Cr[i] = uint8(i*17), uint8(255-i*5)
+	}
+
+	tests := []struct {
+		name    string
+		src     image.Image
+		maxSide int
+		dw, dh  int
+	}{
+		{"landscape NRGBA with offset bounds", nrgba, 30, 30, 12},
+		{"portrait YCbCr", ycbcr, 20, 8, 20},
+		{"single pixel wide", nrgba, 1, 1, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Thumbnail(tt.src, tt.maxSide)
+			if got.Bounds() != image.Rect(0, 0, tt.dw, tt.dh) {
+				t.Fatalf("Thumbnail() bounds = %v; want %dx%d", got.Bounds(), tt.dw, tt.dh)
+			}
+			want := naiveThumbnail(tt.src, tt.dw, tt.dh)
+			if !bytes.Equal(got.(*image.RGBA).Pix, want.Pix) {
+				t.Errorf("Thumbnail() pixels differ from the per-pixel average")
+			}
+		})
+	}
+
+	if got := Thumbnail(ycbcr, 100); got != image.Image(ycbcr) {
+		t.Errorf("Thumbnail() of a small image = %T; want the source unchanged", got)
+	}
+}
+
+// tiff returns a minimal TIFF structure whose first IFD has an orientation entry.
+func tiff(order binary.ByteOrder, orientation uint16) []byte {
+	b := make([]byte, 8+2+12+4)
+	if order == binary.LittleEndian {
+		copy(b, "II*\x00")
+	} else {
+		copy(b, "MM\x00*")
+	}
+	order.PutUint32(b[4:], 8)
+	order.PutUint16(b[8:], 1)
+	order.PutUint16(b[10:], exifOrientationTag)
+	order.PutUint16(b[12:], 3)
+	order.PutUint32(b[14:], 1)
+	order.PutUint16(b[18:], orientation)
+	return b
+}
+
+func TestOrientation(t *testing.T) {
+	soi := []byte{0xFF, 0xD8}
+	scan := jpegSegment(0xDA, "\x01")
+	signature := []byte("\x89PNG\r\n\x1a\n")
+
+	tests := []struct {
+		name        string
+		data        []byte
+		contentType string
+		want        int
+	}{
+		{"jpeg big endian", join(soi, jpegSegment(0xE0, "JFIF"), jpegSegment(0xE1, "Exif\x00\x00"+string(tiff(binary.BigEndian, 6))), scan), "image/jpeg", 6},
+		{"jpeg little endian", join(soi, jpegSegment(0xE1, "Exif\x00\x00"+string(tiff(binary.LittleEndian, 8))), scan), "image/jpeg", 8},
+		{"jpeg after xmp", join(soi, jpegSegment(0xE1, "http://ns.adobe.com/xap/1.0/"), jpegSegment(0xE1, "Exif\x00\x00"+string(tiff(binary.BigEndian, 3))), scan), "image/jpeg", 3},
+		{"jpeg without exif", join(soi, jpegSegment(0xE0, "JFIF"), scan), "image/jpeg", 1},
+		{"jpeg exif after scan", join(soi, scan, jpegSegment(0xE1, "Exif\x00\x00"+string(tiff(binary.BigEndian, 6)))), "image/jpeg", 1},
+		{"jpeg out of range", join(soi, jpegSegment(0xE1, "Exif\x00\x00"+string(tiff(binary.BigEndian, 9))), scan), "image/jpeg", 1},
+		{"jpeg truncated tiff", join(soi, jpegSegment(0xE1, "Exif\x00\x00"+string(tiff(binary.BigEndian, 6)[:15])), scan), "image/jpeg", 1},
+		{"png", join(signature, pngChunk("IHDR", "x"), pngChunk("eXIf", string(tiff(binary.BigEndian, 5))), pngChunk("IEND", "")), "image/png", 5},
+		{"png without exif", join(signature, pngChunk("IHDR", "x"), pngChunk("IEND", "")), "image/png", 1},
+		{"garbage", []byte("not an image"), "image/jpeg", 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Orientation(tt.data, tt.contentType); got != tt.want {
+				t.Errorf("Orientation() = %d; want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOrient(t *testing.T) {
+	// A 3x2 image with a distinct grey level for each pixel:
+	//
+	//	1 2 3
+	//	4 5 6
+	src := image.NewGray(image.Rect(0, 0, 3, 2))
+	copy(src.Pix, []uint8{1, 2, 3, 4, 5, 6})
+
+	tests := []struct {
+		orientation int
+		want        [][]uint8
+	}{
+		{1, [][]uint8{{1, 2, 3}, {4, 5, 6}}},
+		{2, [][]uint8{{3, 2, 1}, {6, 5, 4}}},
+		{3, [][]uint8{{6, 5, 4}, {3, 2, 1}}},
+		{4, [][]uint8{{4, 5, 6}, {1, 2, 3}}},
+		{5, [][]uint8{{1, 4}, {2, 5}, {3, 6}}},
+		{6, [][]uint8{{4, 1}, {5, 2}, {6, 3}}},
+		{7, [][]uint8{{6, 3}, {5, 2}, {4, 1}}},
+		{8, [][]uint8{{3, 6}, {2, 5}, {1, 4}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.orientation), func(t *testing.T) {
+			got := Orient(src, tt.orientation)
+			if got.Bounds() != image.Rect(0, 0, len(tt.want[0]), len(tt.want)) {
+				t.Fatalf("Orient(%d) bounds = %v; want %dx%d", tt.orientation, got.Bounds(), len(tt.want[0]), len(tt.want))
+			}
+			for y, row := range tt.want {
+				for x, want := range row {
+					if c := color.GrayModel.Convert(got.At(x, y)).(color.Gray); c.Y != want {
+						t.Errorf("Orient(%d) at (%d, %d) = %d; want %d", tt.orientation, x, y, c.Y, want)
+					}
+				}
+			}
+		})
+	}
+}
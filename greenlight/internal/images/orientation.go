@@ -0,0 +1,165 @@
+package images
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/draw"
+	"image/png"
+	"io"
+)
+
+// exifOrientationTag is the TIFF tag that records how the camera was held, using the
+// eight values defined by the EXIF specification. 1 means the pixels are upright.
+const exifOrientationTag = 0x0112
+
+// Orientation returns the EXIF orientation (1-8) of a JPEG or PNG file, or 1 if the
+// file doesn't record one. It must be read before StripMetadata removes the EXIF data.
+func Orientation(data []byte, contentType string) int {
+	var exif []byte
+	switch contentType {
+	case "image/jpeg":
+		exif = jpegEXIF(data)
+	case "image/png":
+		exif = pngEXIF(data)
+	}
+	return tiffOrientation(exif)
+}
+
+// jpegEXIF returns the TIFF structure from the first EXIF APP1 segment, if any.
+func jpegEXIF(data []byte) []byte {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil
+	}
+	for i := 2; i+4 <= len(data) && data[i] == 0xFF && data[i+1] != 0xDA; {
+		end := i + 2 + int(binary.BigEndian.Uint16(data[i+2:i+4]))
+		if end > len(data) || end < i+4 {
+			return nil
+		}
+		if payload := data[i+4 : end]; data[i+1] == 0xE1 && bytes.HasPrefix(payload, []byte("Exif\x00\x00")) {
+			return payload[6:]
+		}
+		i = end
+	}
+	return nil
+}
+
+// pngEXIF returns the contents of the eXIf chunk, if any.
+func pngEXIF(data []byte) []byte {
+	for i := 8; i+8 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[i : i+4]))
+		end := i + 12 + length
+		if length < 0 || end > len(data) {
+			return nil
+		}
+		if string(data[i+4:i+8]) == "eXIf" {
+			return data[i+8 : i+8+length]
+		}
+		i = end
+	}
+	return nil
+}
+
+// tiffOrientation reads the orientation tag from the first IFD of a TIFF structure.
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:4]) {
+	case "II*\x00":
+		order = binary.LittleEndian
+	case "MM\x00*":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:8]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+	count := int(order.Uint16(tiff[ifd : ifd+2]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:entry+2]) != exifOrientationTag {
+			continue
+		}
+		// The value is a single SHORT, stored in the first two bytes of the entry's
+		// value field.
+		if order.Uint16(tiff[entry+2:entry+4]) != 3 {
+			return 1
+		}
+		if o := int(order.Uint16(tiff[entry+8 : entry+10])); o >= 1 && o <= 8 {
+			return o
+		}
+		return 1
+	}
+	return 1
+}
+
+// Orient turns the pixels upright according to an EXIF orientation, so that the image
+// displays correctly once the orientation tag has been stripped.
+func Orient(src image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return src
+	}
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	rgba := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(rgba, rgba.Bounds(), src, bounds.Min, draw.Src)
+
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	// Each orientation maps destination pixels to source pixels linearly, so the
+	// source offset moves by a fixed step along each destination row and column.
+	offset := func(x, y int) int {
+		var sx, sy int
+		switch orientation {
+		case 2: // mirrored
+			sx, sy = w-1-x, y
+		case 3: // rotated 180°
+			sx, sy = w-1-x, h-1-y
+		case 4: // mirrored vertically
+			sx, sy = x, h-1-y
+		case 5: // transposed
+			sx, sy = y, x
+		case 6: // needs turning 90° clockwise
+			sx, sy = y, h-1-x
+		case 7: // transversed
+			sx, sy = w-1-y, h-1-x
+		case 8: // needs turning 90° anticlockwise
+			sx, sy = w-1-y, x
+		}
+		return rgba.PixOffset(sx, sy)
+	}
+	origin := offset(0, 0)
+	stepX, stepY := offset(1, 0)-origin, offset(0, 1)-origin
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		i, out := origin+y*stepY, dst.Pix[y*dst.Stride:y*dst.Stride+dw*4]
+		for j := 0; j < len(out); j += 4 {
+			copy(out[j:j+4], rgba.Pix[i:i+4])
+			i += stepX
+		}
+	}
+	return dst
+}
+
+// Encode writes the image in the given format. PNGs keep their transparency; JPEGs are
+// written with EncodeJPEG.
+func Encode(w io.Writer, img image.Image, contentType string) error {
+	switch contentType {
+	case "image/jpeg":
+		return EncodeJPEG(w, img)
+	case "image/png":
+		return png.Encode(w, img)
+	default:
+		return ErrUnsupportedType
+	}
+}
@@ -0,0 +1,226 @@
+package payments
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The payment methods understood by the fake provider. Any other value is treated
+// like FakeMethodSuccess.
+const (
+	// FakeMethodSuccess is authorized immediately.
+	FakeMethodSuccess = "fake_success"
+	// FakeMethodDecline is always declined.
+	FakeMethodDecline = "fake_decline"
+	// FakeMethodAsync returns a pending charge, and is authorized later through the
+	// webhook.
+	FakeMethodAsync = "fake_async"
+)
+
+const fakeSignatureHeader = "Fake-Signature"
+
+// webhookTolerance is how old a signed webhook may be before it is rejected, to limit
+// replays.
+const webhookTolerance = 5 * time.Minute
+
+// Fake is an in-process payment provider for development and testing. It keeps its
+// charges in memory and sends signed events to the webhook URL, in the same way as a
+// real provider would.
+type Fake struct {
+	secret     []byte
+	webhookURL string
+	delay      time.Duration
+	background func(func())
+	onError    func(error)
+	client     *http.Client
+
+	mu          sync.Mutex
+	charges     map[string]*Charge
+	idempotency map[string]string
+}
+
+// NewFake returns a fake provider that signs its webhooks with secret and posts them to
+// webhookURL. Events are sent after delay, using background to run the delivery, and
+// delivery failures are passed to onError.
+func NewFake(secret, webhookURL string, delay time.Duration, background func(func()), onError func(error)) *Fake {
+	return &Fake{
+		secret:      []byte(secret),
+		webhookURL:  webhookURL,
+		delay:       delay,
+		background:  background,
+		onError:     onError,
+		client:      &http.Client{Timeout: 5 * time.Second},
+		charges:     make(map[string]*Charge),
+		idempotency: make(map[string]string),
+	}
+}
+
+func (f *Fake) Name() string {
+	return "fake"
+}
+
+func (f *Fake) Authorize(ctx context.Context, req AuthorizeRequest) (*Charge, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if ref, ok := f.idempotency[req.IdempotencyKey]; ok {
+		return f.result(f.charges[ref])
+	}
+
+	charge := &Charge{Reference: "fake_ch_" + randomHex(12)}
+	switch req.PaymentMethod {
+	case FakeMethodDecline:
+		charge.Status = StatusDeclined
+		charge.FailureReason = "card declined"
+	case FakeMethodAsync:
+		charge.Status = StatusPending
+		f.later(charge.Reference, StatusAuthorized, EventAuthorized)
+	default:
+		charge.Status = StatusAuthorized
+	}
+	f.charges[charge.Reference] = charge
+	f.idempotency[req.IdempotencyKey] = charge.Reference
+	return f.result(charge)
+}
+
+// result returns a copy of the charge, with ErrDeclined for a declined charge.
+func (f *Fake) result(charge *Charge) (*Charge, error) {
+	c := *charge
+	if c.Status == StatusDeclined {
+		return &c, ErrDeclined
+	}
+	return &c, nil
+}
+
+func (f *Fake) Capture(ctx context.Context, reference string) (*Charge, error) {
+	return f.move(reference, StatusAuthorized, StatusCaptured, EventCaptured)
+}
+
+func (f *Fake) Refund(ctx context.Context, reference string) (*Charge, error) {
+	return f.move(reference, StatusCaptured, StatusRefunded, EventRefunded)
+}
+
+// move accepts a change from one state to another, which completes and is reported
+// through the webhook after the configured delay.
+func (f *Fake) move(reference, from, to, eventType string) (*Charge, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	charge, ok := f.charges[reference]
+	if !ok {
+		return nil, ErrUnknownCharge
+	}
+	if charge.Status != from {
+		return nil, ErrInvalidState
+	}
+	f.later(reference, to, eventType)
+	c := *charge
+	return &c, nil
+}
+
+// later changes the state of a charge and sends the matching event once the delay
+// has passed. It must be called with f.mu held.
+func (f *Fake) later(reference, status, eventType string) {
+	f.background(func() {
+		time.Sleep(f.delay)
+
+		f.mu.Lock()
+		f.charges[reference].Status = status
+		f.mu.Unlock()
+
+		event := Event{ID: "fake_evt_" + randomHex(12), Type: eventType, Reference: reference}
+		err := f.send(event)
+		if err != nil {
+			f.onError(fmt.Errorf("fake payment provider: sending %s: %w", eventType, err))
+		}
+	})
+}
+
+func (f *Fake) send(event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, f.webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(fakeSignatureHeader, f.sign(time.Now(), body))
+
+	res, err := f.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with %s", res.Status)
+	}
+	return nil
+}
+
+// sign returns a signature header value of the form "t=<unix time>,v1=<hex HMAC>",
+// where the HMAC covers the timestamp and the body.
+func (f *Fake) sign(t time.Time, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	return "t=" + ts + ",v1=" + hex.EncodeToString(f.mac(ts, body))
+}
+
+func (f *Fake) mac(ts string, body []byte) []byte {
+	mac := hmac.New(sha256.New, f.secret)
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+func (f *Fake) VerifyWebhook(header http.Header, body []byte) (*Event, error) {
+	var ts, sig string
+	for _, part := range strings.Split(header.Get(fakeSignatureHeader), ",") {
+		key, value, _ := strings.Cut(part, "=")
+		switch key {
+		case "t":
+			ts = value
+		case "v1":
+			sig = value
+		}
+	}
+
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidSignature
+	}
+	if age := time.Since(time.Unix(unix, 0)); age > webhookTolerance || age < -webhookTolerance {
+		return nil, ErrInvalidSignature
+	}
+	expected, err := hex.DecodeString(sig)
+	if err != nil || !hmac.Equal(expected, f.mac(ts, body)) {
+		return nil, ErrInvalidSignature
+	}
+
+	var event Event
+	err = json.Unmarshal(body, &event)
+	if err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,74 @@
+// Package payments defines the interface between the API and a payment provider.
+package payments
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+var (
+	// ErrDeclined is returned by Authorize when the provider refuses the payment.
+	ErrDeclined = errors.New("payment declined")
+	// ErrInvalidSignature is returned by VerifyWebhook for a request that didn't come
+	// from the provider, or that has been tampered with.
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+	// ErrInvalidState is returned when a charge can't be captured or refunded in its
+	// current state.
+	ErrInvalidState  = errors.New("charge is not in a valid state for this operation")
+	ErrUnknownCharge = errors.New("unknown charge")
+)
+
+// The states of a charge at the provider.
+const (
+	StatusPending    = "pending"
+	StatusAuthorized = "authorized"
+	StatusCaptured   = "captured"
+	StatusDeclined   = "declined"
+	StatusRefunded   = "refunded"
+)
+
+// The types of event that a provider sends to the webhook endpoint. Each one reports
+// that a charge has moved into the matching state.
+const (
+	EventAuthorized = "payment.authorized"
+	EventCaptured   = "payment.captured"
+	EventDeclined   = "payment.declined"
+	EventRefunded   = "payment.refunded"
+)
+
+// AuthorizeRequest asks the provider to reserve an amount, in minor units, on a
+// payment method. Requests with the same IdempotencyKey return the same charge.
+type AuthorizeRequest struct {
+	IdempotencyKey string
+	Amount         int64
+	Currency       string
+	PaymentMethod  string
+}
+
+// Charge is the provider's view of a payment.
+type Charge struct {
+	Reference     string
+	Status        string
+	FailureReason string
+}
+
+// Event is a verified notification from the provider.
+type Event struct {
+	ID            string `json:"id"`
+	Type          string `json:"type"`
+	Reference     string `json:"reference"`
+	FailureReason string `json:"failure_reason,omitempty"`
+}
+
+// Gateway is implemented by each payment provider. Authorize may complete
+// synchronously or return a pending charge that is confirmed later through the
+// webhook. Capture and Refund start the change at the provider; the webhook event is
+// the confirmation that it has happened.
+type Gateway interface {
+	Name() string
+	Authorize(ctx context.Context, req AuthorizeRequest) (*Charge, error)
+	Capture(ctx context.Context, reference string) (*Charge, error)
+	Refund(ctx context.Context, reference string) (*Charge, error)
+	VerifyWebhook(header http.Header, body []byte) (*Event, error)
+}
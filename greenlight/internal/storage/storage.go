@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	ErrNotFound   = errors.New("storage: object not found")
+	ErrInvalidKey = errors.New("storage: invalid key")
+)
+
+// Storage is the interface for the object stores that hold uploaded files. Keys are
+// slash-separated relative paths like "watches/12/3f9a.../original.jpg".
+type Storage interface {
+	Put(key string, r io.Reader) error
+	Open(key string) (io.ReadSeekCloser, error)
+	Delete(key string) error
+	// URL returns the public URL that the object is served from.
+	URL(key string) string
+}
+
+// Local stores objects as files below a root directory on the local filesystem.
+type Local struct {
+	root    string
+	baseURL string
+}
+
+// NewLocal returns a Local storage which writes to the root directory, creating it if
+// necessary, and whose objects are served below baseURL.
+func NewLocal(root, baseURL string) (*Local, error) {
+	err := os.MkdirAll(root, 0o755)
+	if err != nil {
+		return nil, err
+	}
+	return &Local{root: root, baseURL: strings.TrimSuffix(baseURL, "/")}, nil
+}
+
+// path converts a key to a filesystem path, rejecting keys that would escape the root
+// directory.
+func (l *Local) path(key string) (string, error) {
+	cleaned := path.Clean("/" + key)
+	if key == "" || cleaned != "/"+key {
+		return "", ErrInvalidKey
+	}
+	return filepath.Join(l.root, filepath.FromSlash(cleaned)), nil
+}
+
+// Put writes the object to a temporary file first and renames it into place, so that
+// readers never see a partially written file.
+func (l *Local) Put(key string, r io.Reader) error {
+	p, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(p), 0o755)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(tmp, r)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
+
+func (l *Local) Open(key string) (io.ReadSeekCloser, error) {
+	p, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if info.IsDir() {
+		f.Close()
+		return nil, ErrNotFound
+	}
+	return f, nil
+}
+
+func (l *Local) Delete(key string) error {
+	p, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(p)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (l *Local) URL(key string) string {
+	return l.baseURL + "/" + key
+}
@@ -47,6 +47,16 @@ func In(value string, list ...string) bool {
 	return false
 }
 
+// AllIn returns true if every value in a slice is in a list of strings.
+func AllIn(values []string, list ...string) bool {
+	for _, value := range values {
+		if !In(value, list...) {
+			return false
+		}
+	}
+	return true
+}
+
 // Matches returns true if a string value matches a specific regexp pattern.
 func Matches(value string, rx *regexp.Regexp) bool {
 	return rx.MatchString(value)
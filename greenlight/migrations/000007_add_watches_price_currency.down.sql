@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS watches_price_idx;
+ALTER TABLE watches DROP CONSTRAINT IF EXISTS watches_price_currency_check;
+ALTER TABLE watches DROP CONSTRAINT IF EXISTS watches_price_amount_check;
+ALTER TABLE watches ADD COLUMN IF NOT EXISTS Price integer NOT NULL DEFAULT 0;
+UPDATE watches SET Price = price_amount / 100;
+ALTER TABLE watches ALTER COLUMN Price DROP DEFAULT;
+ALTER TABLE watches ADD CONSTRAINT watches_price_check CHECK (Price >= 0);
+ALTER TABLE watches DROP COLUMN IF EXISTS price_currency;
+ALTER TABLE watches DROP COLUMN IF EXISTS price_amount;
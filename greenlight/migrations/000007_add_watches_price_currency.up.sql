@@ -0,0 +1,11 @@
+ALTER TABLE watches ADD COLUMN IF NOT EXISTS price_amount bigint NOT NULL DEFAULT 0;
+ALTER TABLE watches ADD COLUMN IF NOT EXISTS price_currency text NOT NULL DEFAULT 'USD';
+-- Existing prices were stored as whole units, so convert them to minor units (cents).
+UPDATE watches SET price_amount = Price::bigint * 100;
+ALTER TABLE watches DROP CONSTRAINT IF EXISTS watches_price_check;
+ALTER TABLE watches DROP COLUMN IF EXISTS Price;
+ALTER TABLE watches ALTER COLUMN price_amount DROP DEFAULT;
+ALTER TABLE watches ALTER COLUMN price_currency DROP DEFAULT;
+ALTER TABLE watches ADD CONSTRAINT watches_price_amount_check CHECK (price_amount >= 0);
+ALTER TABLE watches ADD CONSTRAINT watches_price_currency_check CHECK (price_currency ~ '^[A-Z]{3}$');
+CREATE INDEX IF NOT EXISTS watches_price_idx ON watches (price_currency, price_amount);
@@ -0,0 +1,2 @@
+DELETE FROM permissions WHERE code = 'exchange_rates:write';
+DROP TABLE IF EXISTS exchange_rates;
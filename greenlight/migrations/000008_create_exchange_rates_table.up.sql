@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS exchange_rates (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    currency text NOT NULL,
+    rate numeric(18, 8) NOT NULL CHECK (rate > 0),
+    rate_date date NOT NULL,
+    source text NOT NULL,
+    UNIQUE (currency, rate_date)
+);
+INSERT INTO permissions (code) VALUES ('exchange_rates:write');
@@ -0,0 +1,11 @@
+ALTER TABLE watches ADD COLUMN IF NOT EXISTS brand text[] NOT NULL DEFAULT '{}';
+ALTER TABLE watches ADD COLUMN IF NOT EXISTS material text[] NOT NULL DEFAULT '{}';
+UPDATE watches SET
+    brand = ARRAY(SELECT brands.name FROM watches_brands INNER JOIN brands ON brands.id = watches_brands.brand_id WHERE watches_brands.watch_id = watches.id ORDER BY brands.name),
+    material = ARRAY(SELECT materials.name FROM watches_materials INNER JOIN materials ON materials.id = watches_materials.material_id WHERE watches_materials.watch_id = watches.id ORDER BY materials.name);
+CREATE INDEX IF NOT EXISTS watches_brand_idx ON watches USING GIN (brand);
+CREATE INDEX IF NOT EXISTS watches_material_idx ON watches USING GIN (material);
+DROP TABLE IF EXISTS watches_materials;
+DROP TABLE IF EXISTS watches_brands;
+DROP TABLE IF EXISTS materials;
+DROP TABLE IF EXISTS brands;
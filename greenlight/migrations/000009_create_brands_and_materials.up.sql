@@ -0,0 +1,76 @@
+CREATE TABLE IF NOT EXISTS brands (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    name text NOT NULL,
+    slug text UNIQUE NOT NULL,
+    aliases text[] NOT NULL DEFAULT '{}',
+    description text NOT NULL DEFAULT '',
+    version integer NOT NULL DEFAULT 1
+);
+CREATE TABLE IF NOT EXISTS materials (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    name text NOT NULL,
+    slug text UNIQUE NOT NULL,
+    aliases text[] NOT NULL DEFAULT '{}',
+    description text NOT NULL DEFAULT '',
+    version integer NOT NULL DEFAULT 1
+);
+CREATE INDEX IF NOT EXISTS brands_aliases_idx ON brands USING GIN (aliases);
+CREATE INDEX IF NOT EXISTS materials_aliases_idx ON materials USING GIN (aliases);
+
+CREATE TABLE IF NOT EXISTS watches_brands (
+    watch_id bigint NOT NULL REFERENCES watches ON DELETE CASCADE,
+    brand_id bigint NOT NULL REFERENCES brands ON DELETE RESTRICT,
+    PRIMARY KEY (watch_id, brand_id)
+);
+CREATE TABLE IF NOT EXISTS watches_materials (
+    watch_id bigint NOT NULL REFERENCES watches ON DELETE CASCADE,
+    material_id bigint NOT NULL REFERENCES materials ON DELETE RESTRICT,
+    PRIMARY KEY (watch_id, material_id)
+);
+CREATE INDEX IF NOT EXISTS watches_brands_brand_id_idx ON watches_brands (brand_id);
+CREATE INDEX IF NOT EXISTS watches_materials_material_id_idx ON watches_materials (material_id);
+
+-- Deduplicate the existing free-form arrays. Names are normalised in the same way as
+-- data.Slugify(), so "Rolex", "rolex" and "ROLEX SA" all become the "rolex" brand.
+CREATE FUNCTION pg_temp.slugify(name text) RETURNS text AS $$
+    SELECT regexp_replace(trim(both '-' FROM regexp_replace(
+        replace(replace(replace(replace(translate(lower(trim(name)),
+            'àáâãäåāăąçćĉċčďđèéêëēĕėęěĝğġģĥħìíîïĩīĭįıĵķĺļľŀłñńņňòóôõöøōŏőŕŗřśŝşšţťŧùúûüũūŭůűųŵýÿŷźżžð',
+            'aaaaaaaaacccccddeeeeeeeeegggghhiiiiiiiiijklllllnnnnooooooooorrrsssstttuuuuuuuuuuwyyyzzzd'),
+            'ß', 'ss'), 'æ', 'ae'), 'œ', 'oe'), 'þ', 'th'),
+        '[^a-z0-9]+', '-', 'g')), '-(sa|ag|gmbh|ltd|inc|sarl)$', '')
+$$ LANGUAGE sql IMMUTABLE;
+CREATE TEMPORARY TABLE watch_brand_names AS
+SELECT DISTINCT watches.id AS watch_id, trim(name) AS name,
+    pg_temp.slugify(name) AS slug
+FROM watches, unnest(watches.brand) AS name;
+CREATE TEMPORARY TABLE watch_material_names AS
+SELECT DISTINCT watches.id AS watch_id, trim(name) AS name,
+    pg_temp.slugify(name) AS slug
+FROM watches, unnest(watches.material) AS name;
+
+INSERT INTO brands (name, slug)
+SELECT DISTINCT ON (slug) name, slug FROM watch_brand_names WHERE slug <> '' ORDER BY slug, name
+ON CONFLICT (slug) DO NOTHING;
+INSERT INTO materials (name, slug)
+SELECT DISTINCT ON (slug) name, slug FROM watch_material_names WHERE slug <> '' ORDER BY slug, name
+ON CONFLICT (slug) DO NOTHING;
+
+INSERT INTO watches_brands (watch_id, brand_id)
+SELECT watch_brand_names.watch_id, brands.id
+FROM watch_brand_names INNER JOIN brands ON brands.slug = watch_brand_names.slug
+ON CONFLICT DO NOTHING;
+INSERT INTO watches_materials (watch_id, material_id)
+SELECT watch_material_names.watch_id, materials.id
+FROM watch_material_names INNER JOIN materials ON materials.slug = watch_material_names.slug
+ON CONFLICT DO NOTHING;
+
+DROP TABLE watch_brand_names;
+DROP TABLE watch_material_names;
+
+DROP INDEX IF EXISTS watches_brand_idx;
+DROP INDEX IF EXISTS watches_material_idx;
+ALTER TABLE watches DROP COLUMN IF EXISTS brand;
+ALTER TABLE watches DROP COLUMN IF EXISTS material;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS watches_specs_idx;
+ALTER TABLE watches DROP COLUMN IF EXISTS specs;
+DROP TABLE IF EXISTS spec_attributes;
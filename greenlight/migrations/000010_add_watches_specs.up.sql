@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS spec_attributes (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    name text UNIQUE NOT NULL,
+    label text NOT NULL,
+    type text NOT NULL CHECK (type IN ('string', 'integer', 'number', 'boolean', 'enum', 'list')),
+    unit text NOT NULL DEFAULT '',
+    allowed_values text[] NOT NULL DEFAULT '{}',
+    version integer NOT NULL DEFAULT 1
+);
+ALTER TABLE watches ADD COLUMN IF NOT EXISTS specs jsonb NOT NULL DEFAULT '{}';
+CREATE INDEX IF NOT EXISTS watches_specs_idx ON watches USING GIN (specs jsonb_path_ops);
+
+INSERT INTO spec_attributes (name, label, type, unit, allowed_values) VALUES
+    ('movement', 'Movement type', 'enum', '', '{automatic,manual,quartz,spring-drive}'),
+    ('caliber', 'Caliber', 'string', '', '{}'),
+    ('case_mm', 'Case diameter', 'number', 'mm', '{}'),
+    ('water_resistance_m', 'Water resistance', 'integer', 'm', '{}'),
+    ('power_reserve_h', 'Power reserve', 'integer', 'h', '{}'),
+    ('complications', 'Complications', 'list', '', '{}'),
+    ('reference', 'Reference number', 'string', '', '{}');
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS watch_images (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    watch_id bigint NOT NULL REFERENCES watches ON DELETE CASCADE,
+    key_prefix text UNIQUE NOT NULL,
+    content_type text NOT NULL,
+    width integer NOT NULL CHECK (width > 0),
+    height integer NOT NULL CHECK (height > 0),
+    position integer NOT NULL CHECK (position > 0),
+    is_primary bool NOT NULL DEFAULT false
+);
+CREATE INDEX IF NOT EXISTS watch_images_watch_id_position_idx ON watch_images (watch_id, position);
+-- Each watch can have at most one primary image.
+CREATE UNIQUE INDEX IF NOT EXISTS watch_images_primary_idx ON watch_images (watch_id) WHERE is_primary;
@@ -0,0 +1,5 @@
+DELETE FROM permissions WHERE code IN ('inventory:read', 'inventory:write');
+DROP TABLE IF EXISTS stock_movements;
+DROP FUNCTION IF EXISTS stock_movements_prevent_update();
+DROP TABLE IF EXISTS stock_levels;
+DROP TABLE IF EXISTS locations;
@@ -0,0 +1,42 @@
+CREATE TABLE IF NOT EXISTS locations (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    name text UNIQUE NOT NULL,
+    address text NOT NULL DEFAULT '',
+    version integer NOT NULL DEFAULT 1
+);
+CREATE TABLE IF NOT EXISTS stock_levels (
+    watch_id bigint NOT NULL REFERENCES watches ON DELETE CASCADE,
+    location_id bigint NOT NULL REFERENCES locations ON DELETE RESTRICT,
+    quantity integer NOT NULL CHECK (quantity >= 0),
+    version integer NOT NULL DEFAULT 1,
+    PRIMARY KEY (watch_id, location_id)
+);
+CREATE INDEX IF NOT EXISTS stock_levels_location_id_idx ON stock_levels (location_id);
+CREATE TABLE IF NOT EXISTS stock_movements (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    watch_id bigint NOT NULL REFERENCES watches ON DELETE CASCADE,
+    type text NOT NULL CHECK (type IN ('receive', 'transfer', 'sell', 'adjust')),
+    from_location_id bigint REFERENCES locations ON DELETE RESTRICT,
+    to_location_id bigint REFERENCES locations ON DELETE RESTRICT,
+    quantity integer NOT NULL CHECK (quantity > 0),
+    reason text NOT NULL DEFAULT '',
+    user_id bigint NOT NULL REFERENCES users ON DELETE RESTRICT,
+    CHECK (from_location_id IS NOT NULL OR to_location_id IS NOT NULL)
+);
+CREATE INDEX IF NOT EXISTS stock_movements_watch_id_idx ON stock_movements (watch_id, created_at DESC);
+
+-- The ledger is append-only: rows may be removed along with their watch, but never
+-- changed.
+CREATE OR REPLACE FUNCTION stock_movements_prevent_update() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'stock_movements is append-only';
+END;
+$$ LANGUAGE plpgsql;
+CREATE TRIGGER stock_movements_append_only BEFORE UPDATE ON stock_movements
+    FOR EACH ROW EXECUTE FUNCTION stock_movements_prevent_update();
+
+INSERT INTO permissions (code) VALUES
+    ('inventory:read'),
+    ('inventory:write');
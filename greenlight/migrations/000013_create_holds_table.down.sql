@@ -0,0 +1,2 @@
+DELETE FROM permissions WHERE code IN ('holds:read', 'holds:write');
+DROP TABLE IF EXISTS holds;
@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS holds (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    watch_id bigint NOT NULL REFERENCES watches ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users ON DELETE RESTRICT,
+    client_id bigint REFERENCES users ON DELETE SET NULL,
+    client text NOT NULL,
+    note text NOT NULL DEFAULT '',
+    expires_at timestamp(0) with time zone NOT NULL,
+    status text NOT NULL DEFAULT 'active' CHECK (status IN ('active', 'released', 'expired')),
+    released_at timestamp(0) with time zone,
+    version integer NOT NULL DEFAULT 1
+);
+CREATE INDEX IF NOT EXISTS holds_active_watch_id_idx ON holds (watch_id) WHERE status = 'active';
+CREATE INDEX IF NOT EXISTS holds_expires_at_idx ON holds (expires_at) WHERE status = 'active';
+CREATE INDEX IF NOT EXISTS holds_client_id_idx ON holds (client_id) WHERE client_id IS NOT NULL;
+
+INSERT INTO permissions (code) VALUES
+    ('holds:read'),
+    ('holds:write');
@@ -0,0 +1,5 @@
+DELETE FROM permissions WHERE code IN ('orders:create', 'orders:manage');
+DROP TABLE IF EXISTS cart_items;
+DROP TABLE IF EXISTS order_events;
+DROP TABLE IF EXISTS order_lines;
+DROP TABLE IF EXISTS orders;
@@ -0,0 +1,50 @@
+CREATE TABLE IF NOT EXISTS orders (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    user_id bigint NOT NULL REFERENCES users ON DELETE RESTRICT,
+    status text NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'paid', 'shipped', 'delivered', 'cancelled', 'refunded')),
+    total_amount bigint NOT NULL CHECK (total_amount >= 0),
+    total_currency char(3) NOT NULL,
+    expires_at timestamp(0) with time zone,
+    version integer NOT NULL DEFAULT 1
+);
+CREATE INDEX IF NOT EXISTS orders_user_id_idx ON orders (user_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS orders_status_idx ON orders (status);
+-- Pending orders reserve their watches until they expire; the sweeper finds the due
+-- ones through this index.
+CREATE INDEX IF NOT EXISTS orders_pending_expires_at_idx ON orders (expires_at) WHERE status = 'pending';
+
+-- Order lines keep a copy of the title and price at the time of ordering. A watch
+-- that has been ordered can't be deleted.
+CREATE TABLE IF NOT EXISTS order_lines (
+    id bigserial PRIMARY KEY,
+    order_id bigint NOT NULL REFERENCES orders ON DELETE CASCADE,
+    watch_id bigint NOT NULL REFERENCES watches ON DELETE RESTRICT,
+    title text NOT NULL,
+    price_amount bigint NOT NULL CHECK (price_amount >= 0),
+    price_currency char(3) NOT NULL
+);
+CREATE INDEX IF NOT EXISTS order_lines_order_id_idx ON order_lines (order_id);
+CREATE INDEX IF NOT EXISTS order_lines_watch_id_idx ON order_lines (watch_id);
+
+CREATE TABLE IF NOT EXISTS order_events (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    order_id bigint NOT NULL REFERENCES orders ON DELETE CASCADE,
+    from_status text NOT NULL DEFAULT '',
+    to_status text NOT NULL,
+    user_id bigint NOT NULL REFERENCES users ON DELETE RESTRICT,
+    note text NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS order_events_order_id_idx ON order_events (order_id);
+
+CREATE TABLE IF NOT EXISTS cart_items (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    watch_id bigint NOT NULL REFERENCES watches ON DELETE CASCADE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, watch_id)
+);
+
+INSERT INTO permissions (code) VALUES
+    ('orders:create'),
+    ('orders:manage');
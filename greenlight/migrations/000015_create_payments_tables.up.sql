@@ -0,0 +1,31 @@
+CREATE TABLE IF NOT EXISTS payment_intents (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    order_id bigint NOT NULL REFERENCES orders ON DELETE RESTRICT,
+    user_id bigint NOT NULL REFERENCES users ON DELETE RESTRICT,
+    idempotency_key text NOT NULL,
+    amount bigint NOT NULL CHECK (amount >= 0),
+    currency char(3) NOT NULL,
+    provider text NOT NULL,
+    provider_ref text NOT NULL DEFAULT '',
+    status text NOT NULL DEFAULT 'created' CHECK (status IN ('created', 'pending', 'authorized', 'captured', 'declined', 'refunded')),
+    failure_reason text NOT NULL DEFAULT '',
+    version integer NOT NULL DEFAULT 1,
+    UNIQUE (user_id, idempotency_key)
+);
+CREATE INDEX IF NOT EXISTS payment_intents_order_id_idx ON payment_intents (order_id);
+CREATE INDEX IF NOT EXISTS payment_intents_provider_ref_idx ON payment_intents (provider, provider_ref);
+
+-- An order can have only one payment that hasn't failed.
+CREATE UNIQUE INDEX IF NOT EXISTS payment_intents_open_order_id_idx ON payment_intents (order_id)
+    WHERE status IN ('created', 'pending', 'authorized', 'captured');
+
+-- Every webhook event that has been applied, so that redelivered events are ignored.
+CREATE TABLE IF NOT EXISTS payment_events (
+    provider text NOT NULL,
+    event_id text NOT NULL,
+    received_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    provider_ref text NOT NULL,
+    status text NOT NULL,
+    PRIMARY KEY (provider, event_id)
+);
@@ -0,0 +1,4 @@
+DELETE FROM permissions WHERE code IN ('auctions:bid', 'auctions:write');
+ALTER TABLE IF EXISTS auctions DROP CONSTRAINT IF EXISTS auctions_winning_bid_id_fkey;
+DROP TABLE IF EXISTS bids;
+DROP TABLE IF EXISTS auctions;
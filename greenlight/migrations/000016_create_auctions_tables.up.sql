@@ -0,0 +1,35 @@
+CREATE TABLE IF NOT EXISTS auctions (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    watch_id bigint NOT NULL REFERENCES watches ON DELETE RESTRICT,
+    starts_at timestamp with time zone NOT NULL,
+    ends_at timestamp with time zone NOT NULL,
+    starting_price bigint NOT NULL CHECK (starting_price >= 0),
+    reserve_price bigint NOT NULL DEFAULT 0 CHECK (reserve_price >= 0),
+    currency char(3) NOT NULL,
+    min_increment bigint NOT NULL CHECK (min_increment > 0),
+    extension_window integer NOT NULL DEFAULT 120 CHECK (extension_window >= 0),
+    extension integer NOT NULL DEFAULT 120 CHECK (extension >= 0),
+    status text NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'closed', 'cancelled')),
+    winning_bid_id bigint,
+    version integer NOT NULL DEFAULT 1,
+    CHECK (ends_at > starts_at)
+);
+CREATE INDEX IF NOT EXISTS auctions_watch_id_idx ON auctions (watch_id);
+CREATE INDEX IF NOT EXISTS auctions_open_ends_at_idx ON auctions (ends_at) WHERE status = 'open';
+
+-- No two bids on an auction can be for the same amount.
+CREATE TABLE IF NOT EXISTS bids (
+    id bigserial PRIMARY KEY,
+    created_at timestamp with time zone NOT NULL DEFAULT NOW(),
+    auction_id bigint NOT NULL REFERENCES auctions ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users ON DELETE RESTRICT,
+    amount bigint NOT NULL CHECK (amount > 0),
+    UNIQUE (auction_id, amount)
+);
+
+ALTER TABLE auctions ADD CONSTRAINT auctions_winning_bid_id_fkey FOREIGN KEY (winning_bid_id) REFERENCES bids;
+
+INSERT INTO permissions (code) VALUES
+    ('auctions:bid'),
+    ('auctions:write');
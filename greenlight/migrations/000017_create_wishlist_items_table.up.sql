@@ -0,0 +1,8 @@
+-- Wishlist entries go when either the user or the watch is deleted.
+CREATE TABLE IF NOT EXISTS wishlist_items (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    watch_id bigint NOT NULL REFERENCES watches ON DELETE CASCADE,
+    added_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, watch_id)
+);
+CREATE INDEX IF NOT EXISTS wishlist_items_watch_id_idx ON wishlist_items (watch_id);
@@ -0,0 +1,33 @@
+-- An alert is either for a single watch or for every watch matching its filter. The
+-- brand and material filters hold slugs, as in the watch list's query parameters.
+CREATE TABLE IF NOT EXISTS alerts (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    type text NOT NULL CHECK (type IN ('price_drop', 'back_in_stock')),
+    watch_id bigint REFERENCES watches ON DELETE CASCADE,
+    filter_title text NOT NULL DEFAULT '',
+    filter_brand text NOT NULL DEFAULT '',
+    filter_material text NOT NULL DEFAULT '',
+    target_amount bigint CHECK (target_amount >= 0),
+    target_currency char(3),
+    CHECK ((target_amount IS NULL) = (target_currency IS NULL))
+);
+CREATE INDEX IF NOT EXISTS alerts_user_id_idx ON alerts (user_id);
+CREATE INDEX IF NOT EXISTS alerts_watch_id_idx ON alerts (watch_id);
+
+-- Notifications are queued when an alert fires and sent in batches, at most one email
+-- per user per throttle period.
+CREATE TABLE IF NOT EXISTS alert_notifications (
+    id bigserial PRIMARY KEY,
+    created_at timestamp with time zone NOT NULL DEFAULT NOW(),
+    alert_id bigint NOT NULL REFERENCES alerts ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    watch_id bigint NOT NULL REFERENCES watches ON DELETE CASCADE,
+    type text NOT NULL,
+    price_amount bigint NOT NULL,
+    price_currency char(3) NOT NULL,
+    sent_at timestamp with time zone
+);
+CREATE INDEX IF NOT EXISTS alert_notifications_unsent_idx ON alert_notifications (user_id) WHERE sent_at IS NULL;
+CREATE INDEX IF NOT EXISTS alert_notifications_user_sent_at_idx ON alert_notifications (user_id, sent_at);
@@ -0,0 +1,15 @@
+-- query holds the watch list filters as a query string, and last_notified_at is the
+-- watermark up to which new matches have been emailed.
+CREATE TABLE IF NOT EXISTS saved_searches (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    name text NOT NULL,
+    query text NOT NULL DEFAULT '',
+    sort text NOT NULL DEFAULT 'id',
+    frequency text NOT NULL CHECK (frequency IN ('daily', 'weekly')),
+    last_notified_at timestamp with time zone NOT NULL DEFAULT NOW(),
+    version integer NOT NULL DEFAULT 1,
+    UNIQUE (user_id, name)
+);
+CREATE INDEX IF NOT EXISTS saved_searches_last_notified_at_idx ON saved_searches (last_notified_at);
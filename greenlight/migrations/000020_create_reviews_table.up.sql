@@ -0,0 +1,21 @@
+-- A user can review each watch once. Reviews wait as pending until a moderator
+-- approves or rejects them.
+CREATE TABLE IF NOT EXISTS reviews (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    watch_id bigint NOT NULL REFERENCES watches ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    rating smallint NOT NULL CHECK (rating BETWEEN 1 AND 5),
+    text text NOT NULL,
+    status text NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'approved', 'rejected')),
+    moderated_by bigint REFERENCES users ON DELETE SET NULL,
+    moderated_at timestamp(0) with time zone,
+    moderation_note text NOT NULL DEFAULT '',
+    version integer NOT NULL DEFAULT 1,
+    UNIQUE (watch_id, user_id)
+);
+CREATE INDEX IF NOT EXISTS reviews_approved_watch_id_idx ON reviews (watch_id) WHERE status = 'approved';
+CREATE INDEX IF NOT EXISTS reviews_user_id_idx ON reviews (user_id);
+CREATE INDEX IF NOT EXISTS reviews_pending_idx ON reviews (created_at) WHERE status = 'pending';
+
+INSERT INTO permissions (code) VALUES ('reviews:moderate');
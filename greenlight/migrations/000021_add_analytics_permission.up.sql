@@ -0,0 +1 @@
+INSERT INTO permissions (code) VALUES ('analytics:read');
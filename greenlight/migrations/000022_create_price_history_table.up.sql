@@ -0,0 +1,12 @@
+-- Every change to a watch's price, with the user who made it.
+CREATE TABLE IF NOT EXISTS price_history (
+    id bigserial PRIMARY KEY,
+    changed_at timestamp with time zone NOT NULL DEFAULT NOW(),
+    watch_id bigint NOT NULL REFERENCES watches ON DELETE CASCADE,
+    old_amount bigint NOT NULL,
+    old_currency char(3) NOT NULL,
+    new_amount bigint NOT NULL,
+    new_currency char(3) NOT NULL,
+    user_id bigint REFERENCES users ON DELETE SET NULL
+);
+CREATE INDEX IF NOT EXISTS price_history_watch_id_changed_at_idx ON price_history (watch_id, changed_at);
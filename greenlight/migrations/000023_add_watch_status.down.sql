@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS watches_scheduled_publish_at_idx;
+ALTER TABLE watches DROP CONSTRAINT IF EXISTS watches_publish_at_check;
+ALTER TABLE watches DROP CONSTRAINT IF EXISTS watches_status_check;
+ALTER TABLE watches DROP COLUMN IF EXISTS published_at;
+ALTER TABLE watches DROP COLUMN IF EXISTS publish_at;
+ALTER TABLE watches DROP COLUMN IF EXISTS status;
@@ -0,0 +1,10 @@
+-- Watches move through draft, scheduled, published and archived. Existing watches
+-- were all visible, so they start out published.
+ALTER TABLE watches ADD COLUMN IF NOT EXISTS status text NOT NULL DEFAULT 'published';
+ALTER TABLE watches ADD COLUMN IF NOT EXISTS publish_at timestamp with time zone;
+ALTER TABLE watches ADD COLUMN IF NOT EXISTS published_at timestamp with time zone;
+UPDATE watches SET published_at = created_at;
+ALTER TABLE watches ALTER COLUMN status SET DEFAULT 'draft';
+ALTER TABLE watches ADD CONSTRAINT watches_status_check CHECK (status IN ('draft', 'scheduled', 'published', 'archived'));
+ALTER TABLE watches ADD CONSTRAINT watches_publish_at_check CHECK (status <> 'scheduled' OR publish_at IS NOT NULL);
+CREATE INDEX IF NOT EXISTS watches_scheduled_publish_at_idx ON watches (publish_at) WHERE status = 'scheduled';
@@ -0,0 +1,2 @@
+DELETE FROM permissions WHERE code = 'watches:approve';
+DROP TABLE IF EXISTS change_requests;
@@ -0,0 +1,23 @@
+-- Sensitive changes to watches made by ordinary editors wait here until a second
+-- person approves or rejects them. For an update, changes holds the requested edit in
+-- the same form as the PATCH body. watch_title is kept so that a request still reads
+-- sensibly once its watch has been deleted and watch_id is cleared.
+CREATE TABLE IF NOT EXISTS change_requests (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    watch_id bigint REFERENCES watches ON DELETE SET NULL,
+    watch_title text NOT NULL,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    action text NOT NULL CHECK (action IN ('update', 'delete')),
+    changes jsonb,
+    watch_version integer NOT NULL,
+    status text NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'approved', 'rejected')),
+    reviewed_by bigint REFERENCES users ON DELETE SET NULL,
+    reviewed_at timestamp(0) with time zone,
+    review_note text NOT NULL DEFAULT '',
+    version integer NOT NULL DEFAULT 1
+);
+CREATE INDEX IF NOT EXISTS change_requests_pending_idx ON change_requests (created_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS change_requests_user_id_idx ON change_requests (user_id);
+
+INSERT INTO permissions (code) VALUES ('watches:approve');
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS collection_watches;
+DROP TABLE IF EXISTS collections;
+DROP TABLE IF EXISTS watches_categories;
+DROP TABLE IF EXISTS categories;
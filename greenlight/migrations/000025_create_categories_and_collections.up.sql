@@ -0,0 +1,39 @@
+-- Categories form a tree; position orders a category among its siblings. A category
+-- with subcategories can't be deleted until they are moved or deleted.
+CREATE TABLE IF NOT EXISTS categories (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    parent_id bigint REFERENCES categories ON DELETE RESTRICT,
+    name text NOT NULL,
+    slug text NOT NULL UNIQUE,
+    description text NOT NULL DEFAULT '',
+    position integer NOT NULL DEFAULT 0,
+    version integer NOT NULL DEFAULT 1,
+    CHECK (parent_id <> id)
+);
+CREATE INDEX IF NOT EXISTS categories_parent_id_idx ON categories (parent_id);
+
+CREATE TABLE IF NOT EXISTS watches_categories (
+    watch_id bigint NOT NULL REFERENCES watches ON DELETE CASCADE,
+    category_id bigint NOT NULL REFERENCES categories ON DELETE CASCADE,
+    PRIMARY KEY (watch_id, category_id)
+);
+CREATE INDEX IF NOT EXISTS watches_categories_category_id_idx ON watches_categories (category_id);
+
+-- Collections are curated lists of watches in a manually chosen order.
+CREATE TABLE IF NOT EXISTS collections (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    name text NOT NULL,
+    slug text NOT NULL UNIQUE,
+    description text NOT NULL DEFAULT '',
+    version integer NOT NULL DEFAULT 1
+);
+
+CREATE TABLE IF NOT EXISTS collection_watches (
+    collection_id bigint NOT NULL REFERENCES collections ON DELETE CASCADE,
+    watch_id bigint NOT NULL REFERENCES watches ON DELETE CASCADE,
+    position integer NOT NULL,
+    PRIMARY KEY (collection_id, watch_id)
+);
+CREATE INDEX IF NOT EXISTS collection_watches_watch_id_idx ON collection_watches (watch_id);
@@ -0,0 +1,3 @@
+DELETE FROM permissions WHERE code = 'tags:manage';
+DROP TABLE IF EXISTS watches_tags;
+DROP TABLE IF EXISTS tags;
@@ -0,0 +1,18 @@
+-- Free-form tags. Tags are matched by slug, so "Box & Papers" and "box-papers" are
+-- the same tag; name keeps the spelling it was first given, until it is renamed.
+CREATE TABLE IF NOT EXISTS tags (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    name text NOT NULL,
+    slug text NOT NULL UNIQUE,
+    version integer NOT NULL DEFAULT 1
+);
+
+CREATE TABLE IF NOT EXISTS watches_tags (
+    watch_id bigint NOT NULL REFERENCES watches ON DELETE CASCADE,
+    tag_id bigint NOT NULL REFERENCES tags ON DELETE CASCADE,
+    PRIMARY KEY (watch_id, tag_id)
+);
+CREATE INDEX IF NOT EXISTS watches_tags_tag_id_idx ON watches_tags (tag_id);
+
+INSERT INTO permissions (code) VALUES ('tags:manage');
@@ -0,0 +1,4 @@
+DELETE FROM permissions WHERE code IN ('provenance:write', 'provenance:read_private');
+ALTER TABLE watches DROP COLUMN IF EXISTS provenance_head;
+ALTER TABLE watches DROP COLUMN IF EXISTS provenance_sequence;
+DROP TABLE IF EXISTS provenance_entries;
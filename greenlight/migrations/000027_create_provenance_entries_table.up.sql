@@ -0,0 +1,26 @@
+-- The ownership history of a watch. Entries are only ever appended: each one stores
+-- the hash of the entry before it, so editing or removing an entry in the database
+-- breaks the chain and shows up when it is verified. The watch keeps the sequence and
+-- hash of its last entry, so that removing entries from the end shows up too.
+CREATE TABLE IF NOT EXISTS provenance_entries (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL,
+    watch_id bigint NOT NULL REFERENCES watches ON DELETE CASCADE,
+    sequence integer NOT NULL,
+    owner_type text NOT NULL CHECK (owner_type IN ('manufacturer', 'dealer', 'auction_house', 'private', 'museum', 'other')),
+    owner_name text NOT NULL DEFAULT '',
+    acquired_on date,
+    source text NOT NULL DEFAULT '',
+    documents text[] NOT NULL DEFAULT '{}',
+    notes text NOT NULL DEFAULT '',
+    created_by bigint REFERENCES users ON DELETE SET NULL,
+    previous_hash text NOT NULL,
+    hash text NOT NULL,
+    UNIQUE (watch_id, sequence)
+);
+
+ALTER TABLE watches ADD COLUMN IF NOT EXISTS provenance_sequence integer NOT NULL DEFAULT 0;
+ALTER TABLE watches ADD COLUMN IF NOT EXISTS provenance_head text NOT NULL
+    DEFAULT '0000000000000000000000000000000000000000000000000000000000000000';
+
+INSERT INTO permissions (code) VALUES ('provenance:write'), ('provenance:read_private');
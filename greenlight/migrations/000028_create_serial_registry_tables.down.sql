@@ -0,0 +1,3 @@
+DELETE FROM permissions WHERE code = 'registry:review';
+DROP TABLE IF EXISTS registry_reports;
+DROP TABLE IF EXISTS serial_numbers;
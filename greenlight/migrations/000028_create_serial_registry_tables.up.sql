@@ -0,0 +1,35 @@
+-- Serial numbers of the individual pieces of a watch. Serials are stored normalised
+-- (upper case, without spaces or punctuation) and are unique per brand.
+CREATE TABLE IF NOT EXISTS serial_numbers (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    watch_id bigint NOT NULL REFERENCES watches ON DELETE CASCADE,
+    brand_id bigint NOT NULL REFERENCES brands,
+    serial text NOT NULL,
+    UNIQUE (brand_id, serial)
+);
+CREATE INDEX IF NOT EXISTS serial_numbers_watch_id_idx ON serial_numbers (watch_id);
+
+-- Reports of stolen or lost watches. A report doesn't need the serial to be in the
+-- registry, and stays pending until staff verify or dismiss it.
+CREATE TABLE IF NOT EXISTS registry_reports (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    brand_id bigint NOT NULL REFERENCES brands,
+    serial text NOT NULL,
+    kind text NOT NULL CHECK (kind IN ('stolen', 'lost')),
+    occurred_on date,
+    description text NOT NULL DEFAULT '',
+    police_reference text NOT NULL DEFAULT '',
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    status text NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'verified', 'dismissed')),
+    reviewed_by bigint REFERENCES users ON DELETE SET NULL,
+    reviewed_at timestamp(0) with time zone,
+    review_note text NOT NULL DEFAULT '',
+    version integer NOT NULL DEFAULT 1
+);
+CREATE INDEX IF NOT EXISTS registry_reports_serial_idx ON registry_reports (brand_id, serial);
+CREATE INDEX IF NOT EXISTS registry_reports_pending_idx ON registry_reports (created_at) WHERE status = 'pending';
+CREATE UNIQUE INDEX IF NOT EXISTS registry_reports_pending_user_idx ON registry_reports (brand_id, serial, user_id) WHERE status = 'pending';
+
+INSERT INTO permissions (code) VALUES ('registry:review');
@@ -0,0 +1,2 @@
+DELETE FROM permissions WHERE code = 'service:write';
+DROP TABLE IF EXISTS service_records;
@@ -0,0 +1,28 @@
+-- Servicing and maintenance done on a watch. user_id is whoever logged the record,
+-- and owner_id the customer who owns the watch, who is reminded when the next service
+-- is due. A record without an owner, such as one for a watch still in stock, reminds
+-- whoever logged it instead. The record outlives both accounts, with the columns set
+-- to NULL. reminder_sent_at is cleared whenever next_due_on changes, so a rescheduled
+-- service gets a new reminder.
+CREATE TABLE IF NOT EXISTS service_records (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    watch_id bigint NOT NULL REFERENCES watches ON DELETE CASCADE,
+    user_id bigint REFERENCES users ON DELETE SET NULL,
+    owner_id bigint REFERENCES users ON DELETE SET NULL,
+    serviced_on date NOT NULL,
+    workshop text NOT NULL,
+    work_done text NOT NULL,
+    parts text[] NOT NULL DEFAULT '{}',
+    cost_amount bigint,
+    cost_currency char(3),
+    next_due_on date,
+    reminder_sent_at timestamp(0) with time zone,
+    version integer NOT NULL DEFAULT 1,
+    CHECK ((cost_amount IS NULL) = (cost_currency IS NULL)),
+    CHECK (next_due_on IS NULL OR next_due_on > serviced_on)
+);
+CREATE INDEX IF NOT EXISTS service_records_watch_id_idx ON service_records (watch_id, serviced_on);
+CREATE INDEX IF NOT EXISTS service_records_next_due_on_idx ON service_records (next_due_on) WHERE next_due_on IS NOT NULL;
+
+INSERT INTO permissions (code) VALUES ('service:write');
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS watches_year_idx;
@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS watches_year_idx ON watches (year);
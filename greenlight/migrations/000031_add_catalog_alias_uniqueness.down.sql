@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS materials_check_names ON materials;
+DROP TRIGGER IF EXISTS brands_check_names ON brands;
+DROP FUNCTION IF EXISTS check_catalog_entity_names();
@@ -0,0 +1,33 @@
+-- A slug or alias may only name one brand (or material). Arrays can't be covered by a
+-- unique index, so the check is a trigger. The advisory lock serialises concurrent
+-- writers to the same table so that two of them can't both pass the check.
+CREATE OR REPLACE FUNCTION check_catalog_entity_names() RETURNS trigger AS $$
+DECLARE
+    conflict bigint;
+BEGIN
+    PERFORM pg_advisory_xact_lock(hashtext(TG_TABLE_NAME));
+
+    EXECUTE format('SELECT id FROM %I WHERE id <> $1 AND $2 = ANY(aliases) LIMIT 1', TG_TABLE_NAME)
+        INTO conflict USING NEW.id, NEW.slug;
+    IF conflict IS NOT NULL THEN
+        RAISE EXCEPTION 'slug "%" is an alias of another entry in %', NEW.slug, TG_TABLE_NAME
+            USING ERRCODE = 'unique_violation', CONSTRAINT = TG_TABLE_NAME || '_slug_key';
+    END IF;
+
+    EXECUTE format('SELECT id FROM %I WHERE id <> $1 AND (slug = ANY($2) OR aliases && $2) LIMIT 1', TG_TABLE_NAME)
+        INTO conflict USING NEW.id, NEW.aliases;
+    IF conflict IS NOT NULL THEN
+        RAISE EXCEPTION 'aliases of "%" overlap another entry in %', NEW.slug, TG_TABLE_NAME
+            USING ERRCODE = 'unique_violation', CONSTRAINT = TG_TABLE_NAME || '_aliases_key';
+    END IF;
+
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER brands_check_names
+    BEFORE INSERT OR UPDATE OF slug, aliases ON brands
+    FOR EACH ROW EXECUTE FUNCTION check_catalog_entity_names();
+CREATE TRIGGER materials_check_names
+    BEFORE INSERT OR UPDATE OF slug, aliases ON materials
+    FOR EACH ROW EXECUTE FUNCTION check_catalog_entity_names();